@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"log"
+	"os"
 	"time"
 
 	postgres "deployer.com/cmd/db/db"
@@ -12,10 +15,13 @@ import (
 	"deployer.com/modules/deployments"
 	"deployer.com/modules/domains"
 	"deployer.com/modules/execute"
+	"deployer.com/modules/keyrotation"
 	"deployer.com/modules/projects"
+	"deployer.com/modules/runs"
 	"deployer.com/modules/scripts"
 	"deployer.com/modules/secrets"
 	"deployer.com/modules/servers"
+	"deployer.com/modules/stacks"
 	"deployer.com/modules/users"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -49,6 +55,30 @@ func NewDockerCommunication() (*libs.DockerComunication, error) {
 	return docker, nil
 }
 
+// NewKeyProvider picks where the master encryption keys come from with ENCRYPTION_KEY_PROVIDER: env
+// (default), file or vault. A data key is sealed and opened once so a misconfigured provider stops the start
+func NewKeyProvider() (libs.KeyProvider, error) {
+	kind := os.Getenv("ENCRYPTION_KEY_PROVIDER")
+	if kind == "" {
+		kind = libs.KeyProviderEnv
+	}
+	provider, err := libs.LoadKeyProvider(kind)
+	if err != nil {
+		return nil, err
+	}
+	probe := bytes.Repeat([]byte{0x5a}, 32)
+	keyID, wrapped, err := provider.WrapKey(probe)
+	if err != nil {
+		return nil, fmt.Errorf("key provider check failed: %w", err)
+	}
+	unwrapped, err := provider.UnwrapKey(keyID, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, probe) {
+		return nil, fmt.Errorf("key provider check failed: %v", err)
+	}
+	log.Printf("Encryption keys from the %s provider, active key %s", kind, keyID)
+	return provider, nil
+}
+
 func RegisterRoutes(app *fiber.App, db *gorm.DB, docker *libs.DockerComunication) {
 	api := app.Group("/api/v1")
 	api.Get("/health", func(c *fiber.Ctx) error {
@@ -111,11 +141,23 @@ func RegisterRoutes(app *fiber.App, db *gorm.DB, docker *libs.DockerComunication
 		routes := deployments.NewDeploymentsController(&group, deployments.NewDeploymentsService(db))
 		routes.RegisterRoutes(&group)
 	}
+	{
+		group := api.Group("/stacks")
+		routes := stacks.NewStacksController(&group, stacks.NewStacksService(db))
+		routes.RegisterRoutes(&group)
+	}
 	{
 		group := api.Group("/projects")
 		routes := projects.NewProjectsController(&group, projects.NewProjectsService(db))
 		routes.RegisterRoutes(&group)
 	}
+	// Run history is shared between the execute routes and the runs routes
+	runsService := runs.NewRunsService(db)
+	{
+		group := api.Group("/execute/runs")
+		routes := runs.NewRunsController(&group, runsService)
+		routes.RegisterRoutes(&group)
+	}
 	{
 		group := api.Group("/execute")
 		routes := execute.NewExecuteController(
@@ -126,10 +168,22 @@ func RegisterRoutes(app *fiber.App, db *gorm.DB, docker *libs.DockerComunication
 				containers.NewContainersService(db),
 				deployments.NewDeploymentsService(db),
 				projects.NewProjectsService(db),
+				domains.NewDomainsService(db),
+				stacks.NewStacksService(db),
+				runsService,
 				docker,
 			),
 		)
 		routes.RegisterExecuteRoutes(&group)
+
+		projectsGroup := api.Group("/projects")
+		routes.RegisterProjectRoutes(&projectsGroup)
+
+		deploymentsGroup := api.Group("/deployments")
+		routes.RegisterDeploymentRoutes(&deploymentsGroup)
+
+		stacksGroup := api.Group("/stacks")
+		routes.RegisterStackRoutes(&stacksGroup)
 	}
 }
 
@@ -139,25 +193,46 @@ func main() {
 			NewFiber,
 			postgres.NewGormDB,
 			NewDockerCommunication, // Добавляем Docker клиент в DI контейнер
+			NewKeyProvider,
 		),
-		fx.Invoke(func(lc fx.Lifecycle, app *fiber.App, db *gorm.DB, docker *libs.DockerComunication) {
+		fx.Invoke(func(lc fx.Lifecycle, app *fiber.App, db *gorm.DB, docker *libs.DockerComunication, keyProvider libs.KeyProvider) {
+			// Every EncryptionService created by the modules uses the configured provider
+			libs.SetDefaultKeyProvider(keyProvider)
+
 			lc.Append(fx.Hook{
 				OnStart: func(ctx context.Context) error {
+					// AutoMigrate adds run_options as '{}', the containers stored before keep their network and IP
+					// like migration 00024 does for goose
+					addRunOptions := !db.Migrator().HasColumn(&containers.Container{}, "RunOptions")
+
 					// Автомиграция базы данных
 					if err := db.AutoMigrate(
 						&users.User{},
 						&secrets.Secret{},
+						&secrets.SecretVersion{},
 						&servers.Server{},
+						&servers.ServerKey{},
 						&containers.Container{},
 						&scripts.Script{},
 						&domains.Domain{},
 						&domains.SubDomain{},
+						&stacks.Stack{},
 						&deployments.Deployment{},
+						&deployments.DeployedContainer{},
+						&deployments.DeploymentRevision{},
 						&projects.Project{},
 						&projects.ProjectDeployments{},
+						&runs.Run{},
+						&runs.RunStep{},
+						&keyrotation.KeyRotation{},
 					); err != nil {
 						log.Fatal("AutoMigrate failed:", err)
 					}
+					if addRunOptions {
+						if err := containers.BackfillRunOptions(db); err != nil {
+							log.Fatal("Backfilling container run options failed:", err)
+						}
+					}
 
 					// Инициализация кэша deployment-worker контейнеров
 					log.Println("Initializing deployment-worker containers cache...")
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	postgres "deployer.com/cmd/db/db"
+	"deployer.com/modules/runs"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upRuns, downRuns)
+}
+
+func upRuns(ctx context.Context, tx *sql.Tx) error {
+	if err := postgres.DB_MIGRATOR.CreateTable(&runs.Run{}); err != nil {
+		return err
+	}
+	return postgres.DB_MIGRATOR.CreateTable(&runs.RunStep{})
+}
+
+func downRuns(ctx context.Context, tx *sql.Tx) error {
+	if err := postgres.DB_MIGRATOR.DropTable(&runs.RunStep{}); err != nil {
+		return err
+	}
+	return postgres.DB_MIGRATOR.DropTable(&runs.Run{})
+}
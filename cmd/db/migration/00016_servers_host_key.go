@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upServersHostKey, downServersHostKey)
+}
+
+func upServersHostKey(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE servers
+		ADD COLUMN IF NOT EXISTS host_key TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS host_key_fingerprint VARCHAR(255) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS host_key_pinned_at TIMESTAMPTZ`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downServersHostKey(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE servers
+		DROP COLUMN IF EXISTS host_key,
+		DROP COLUMN IF EXISTS host_key_fingerprint,
+		DROP COLUMN IF EXISTS host_key_pinned_at`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
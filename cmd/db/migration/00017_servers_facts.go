@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upServersFacts, downServersFacts)
+}
+
+func upServersFacts(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE servers
+		ADD COLUMN IF NOT EXISTS reachable BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS authenticated BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS os_release VARCHAR(255) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS kernel VARCHAR(255) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS cpu_count BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS memory_mb BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS disk_total_mb BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS disk_free_mb BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS docker_version VARCHAR(255) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS docker_access BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS last_check_error TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMPTZ`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downServersFacts(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE servers
+		DROP COLUMN IF EXISTS reachable,
+		DROP COLUMN IF EXISTS authenticated,
+		DROP COLUMN IF EXISTS os_release,
+		DROP COLUMN IF EXISTS kernel,
+		DROP COLUMN IF EXISTS cpu_count,
+		DROP COLUMN IF EXISTS memory_mb,
+		DROP COLUMN IF EXISTS disk_total_mb,
+		DROP COLUMN IF EXISTS disk_free_mb,
+		DROP COLUMN IF EXISTS docker_version,
+		DROP COLUMN IF EXISTS docker_access,
+		DROP COLUMN IF EXISTS last_check_error,
+		DROP COLUMN IF EXISTS last_checked_at`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upServersSSHKeyPassphrase, downServersSSHKeyPassphrase)
+}
+
+func upServersSSHKeyPassphrase(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE servers ADD COLUMN IF NOT EXISTS ssh_key_passphrase TEXT")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downServersSSHKeyPassphrase(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE servers DROP COLUMN IF EXISTS ssh_key_passphrase")
+	if err != nil {
+		return err
+	}
+	return nil
+}
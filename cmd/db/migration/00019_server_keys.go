@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	postgres "deployer.com/cmd/db/db"
+	"deployer.com/modules/servers"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upServerKeys, downServerKeys)
+}
+
+func upServerKeys(ctx context.Context, tx *sql.Tx) error {
+	return postgres.DB_MIGRATOR.CreateTable(&servers.ServerKey{})
+}
+
+func downServerKeys(ctx context.Context, tx *sql.Tx) error {
+	return postgres.DB_MIGRATOR.DropTable(&servers.ServerKey{})
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upRunTimeouts, downRunTimeouts)
+}
+
+func upRunTimeouts(ctx context.Context, tx *sql.Tx) error {
+	for _, table := range []string{"scripts", "deployments", "runs"} {
+		if _, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN IF NOT EXISTS timeout BIGINT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downRunTimeouts(ctx context.Context, tx *sql.Tx) error {
+	for _, table := range []string{"scripts", "deployments", "runs"} {
+		if _, err := tx.Exec("ALTER TABLE " + table + " DROP COLUMN IF EXISTS timeout"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
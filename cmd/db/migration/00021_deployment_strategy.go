@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upDeploymentStrategy, downDeploymentStrategy)
+}
+
+func upDeploymentStrategy(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE deployments
+		ADD COLUMN IF NOT EXISTS strategy VARCHAR(32) NOT NULL DEFAULT 'sequential',
+		ADD COLUMN IF NOT EXISTS batch_size BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS batch_percent BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS health_check TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS health_check_timeout BIGINT NOT NULL DEFAULT 0`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downDeploymentStrategy(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE deployments
+		DROP COLUMN IF EXISTS strategy,
+		DROP COLUMN IF EXISTS batch_size,
+		DROP COLUMN IF EXISTS batch_percent,
+		DROP COLUMN IF EXISTS health_check,
+		DROP COLUMN IF EXISTS health_check_timeout`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
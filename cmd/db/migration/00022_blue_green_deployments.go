@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	postgres "deployer.com/cmd/db/db"
+	"deployer.com/modules/deployments"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upBlueGreenDeployments, downBlueGreenDeployments)
+}
+
+func upBlueGreenDeployments(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE deployments
+		ADD COLUMN IF NOT EXISTS blue_green BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS health_probe TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS health_probe_timeout BIGINT NOT NULL DEFAULT 0`)
+	if err != nil {
+		return err
+	}
+	return postgres.DB_MIGRATOR.CreateTable(&deployments.DeployedContainer{})
+}
+
+func downBlueGreenDeployments(ctx context.Context, tx *sql.Tx) error {
+	if err := postgres.DB_MIGRATOR.DropTable(&deployments.DeployedContainer{}); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`ALTER TABLE deployments
+		DROP COLUMN IF EXISTS blue_green,
+		DROP COLUMN IF EXISTS health_probe,
+		DROP COLUMN IF EXISTS health_probe_timeout`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	postgres "deployer.com/cmd/db/db"
+	"deployer.com/modules/deployments"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upDeploymentRevisions, downDeploymentRevisions)
+}
+
+func upDeploymentRevisions(ctx context.Context, tx *sql.Tx) error {
+	return postgres.DB_MIGRATOR.CreateTable(&deployments.DeploymentRevision{})
+}
+
+func downDeploymentRevisions(ctx context.Context, tx *sql.Tx) error {
+	return postgres.DB_MIGRATOR.DropTable(&deployments.DeploymentRevision{})
+}
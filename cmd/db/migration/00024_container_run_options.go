@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upContainerRunOptions, downContainerRunOptions)
+}
+
+func upContainerRunOptions(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.Exec(`ALTER TABLE containers ADD COLUMN IF NOT EXISTS run_options JSONB NOT NULL DEFAULT '{}'`); err != nil {
+		return err
+	}
+	// Existing containers keep the network and address every container used to be started with
+	_, err := tx.Exec(`UPDATE containers SET run_options = '{"network":"gateway_network","ip":"172.30.0.20"}' WHERE run_options = '{}'`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downContainerRunOptions(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE containers DROP COLUMN IF EXISTS run_options")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	postgres "deployer.com/cmd/db/db"
+	"deployer.com/modules/stacks"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upStacks, downStacks)
+}
+
+// upStacks creates the stacks with their stack_secrets join table, deployment_stacks is created
+// together with the deployments by AutoMigrate
+func upStacks(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE runs ADD COLUMN IF NOT EXISTS stack_id BIGINT DEFAULT NULL"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_runs_stack_id ON runs (stack_id)"); err != nil {
+		return err
+	}
+	return postgres.DB_MIGRATOR.CreateTable(&stacks.Stack{})
+}
+
+func downStacks(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE runs DROP COLUMN IF EXISTS stack_id"); err != nil {
+		return err
+	}
+	return postgres.DB_MIGRATOR.DropTable("stack_secrets", &stacks.Stack{})
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	postgres "deployer.com/cmd/db/db"
+	"deployer.com/modules/keyrotation"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upKeyRotations, downKeyRotations)
+}
+
+func upKeyRotations(ctx context.Context, tx *sql.Tx) error {
+	return postgres.DB_MIGRATOR.CreateTable(&keyrotation.KeyRotation{})
+}
+
+func downKeyRotations(ctx context.Context, tx *sql.Tx) error {
+	return postgres.DB_MIGRATOR.DropTable(&keyrotation.KeyRotation{})
+}
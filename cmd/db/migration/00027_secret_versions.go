@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	postgres "deployer.com/cmd/db/db"
+	"deployer.com/modules/secrets"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upSecretVersions, downSecretVersions)
+}
+
+// upSecretVersions stores the current content of every secret as its first version, the encrypted content
+// is copied as is
+func upSecretVersions(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE secrets ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := postgres.DB_MIGRATOR.CreateTable(&secrets.SecretVersion{}); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO secret_versions (secret_id, version, content, author_id, created_at, updated_at)
+		SELECT id, 1, content, user_id, COALESCE(updated_at, created_at, NOW()), COALESCE(updated_at, created_at, NOW())
+		FROM secrets`)
+	return err
+}
+
+func downSecretVersions(ctx context.Context, tx *sql.Tx) error {
+	if err := postgres.DB_MIGRATOR.DropTable(&secrets.SecretVersion{}); err != nil {
+		return err
+	}
+	_, err := tx.Exec("ALTER TABLE secrets DROP COLUMN IF EXISTS version")
+	return err
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upSecretModes, downSecretModes)
+}
+
+// upSecretModes keeps every existing secret and version in raw mode
+func upSecretModes(ctx context.Context, tx *sql.Tx) error {
+	for _, table := range []string{"secrets", "secret_versions"} {
+		if _, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN IF NOT EXISTS mode TEXT NOT NULL DEFAULT 'raw'"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downSecretModes(ctx context.Context, tx *sql.Tx) error {
+	for _, table := range []string{"secrets", "secret_versions"} {
+		if _, err := tx.Exec("ALTER TABLE " + table + " DROP COLUMN IF EXISTS mode"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
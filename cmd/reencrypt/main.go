@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	postgres "deployer.com/cmd/db/db"
+	"deployer.com/libs"
+	"deployer.com/modules/keyrotation"
+)
+
+// Re-encrypts every stored value with the active key of the key ring. Rotating the master key:
+//  1. add the new key to ENCRYPTION_KEYS and make it ENCRYPTION_ACTIVE_KEY for the app and this command, or
+//     switch ENCRYPTION_KEY_PROVIDER to vault and keep the old keys configured
+//  2. run this command, an interrupted run continues where it stopped when started again
+//  3. remove the old key from the key ring
+func main() {
+	batchSize := flag.Int("batch-size", 100, "rows re-encrypted per transaction")
+	restart := flag.Bool("restart", false, "ignore the stored progress and check every row again")
+	skipInvalid := flag.Bool("skip-invalid", false, "leave values that cannot be decrypted instead of stopping")
+	flag.Parse()
+
+	provider, err := libs.LoadKeyProvider(os.Getenv("ENCRYPTION_KEY_PROVIDER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := postgres.NewGormDBMigration(); err != nil {
+		log.Fatal(err)
+	}
+
+	service := keyrotation.NewKeyRotationService(postgres.GORM_DB, libs.NewEncryptionServiceWithProvider(provider))
+	log.Printf("Re-encrypting with key %s...", provider.ActiveKeyID())
+	if err := service.Rotate(provider.ActiveKeyID(), keyrotation.RotateOptions{
+		BatchSize:   *batchSize,
+		Restart:     *restart,
+		SkipInvalid: *skipInvalid,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Re-encryption finished")
+}
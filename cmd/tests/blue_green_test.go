@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"deployer.com/libs"
+)
+
+// fakeDocker keeps the containers of a fake docker in $STATE, one file per container holding its image.
+// Every call is appended to $STATE/log
+const fakeDocker = `docker() {
+	echo "$*" >> "$STATE/log"
+	for last in "$@"; do :; done
+	case "$1" in
+	run)
+		name=
+		while [ $# -gt 0 ]; do [ "$1" = --name ] && name=$2; shift; done
+		[ -e "$STATE/containers/$name" ] && { echo "name $name is already in use" >&2; return 125; }
+		echo "$last" > "$STATE/containers/$name" ;;
+	rm)
+		[ -e "$STATE/containers/$last" ] || { echo "no such container: $last" >&2; return 1; }
+		rm "$STATE/containers/$last" ;;
+	rename)
+		[ -e "$STATE/containers/$2" ] && [ ! -e "$STATE/containers/$3" ] || return 1
+		mv "$STATE/containers/$2" "$STATE/containers/$3" ;;
+	network)
+		[ -e "$STATE/containers/$last" ] || return 1 ;;
+	esac
+}
+`
+
+// blueGreenState runs the commands one after the other with fakeDocker and returns the containers left
+// with their images and the docker calls
+func blueGreenState(t *testing.T, running map[string]string, commands []string) (map[string]string, []string) {
+	t.Helper()
+	state := t.TempDir()
+	if err := os.Mkdir(filepath.Join(state, "containers"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, image := range running {
+		if err := os.WriteFile(filepath.Join(state, "containers", name), []byte(image+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := "STATE=" + libs.ShellQuote(state) + "\n" + fakeDocker
+	for _, command := range commands {
+		script += command + " || exit $?\n"
+	}
+	runShell(t, script)
+
+	containers := make(map[string]string)
+	entries, err := os.ReadDir(filepath.Join(state, "containers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		image, err := os.ReadFile(filepath.Join(state, "containers", entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		containers[entry.Name()] = strings.TrimSpace(string(image))
+	}
+	log, _ := os.ReadFile(filepath.Join(state, "log"))
+	return containers, strings.Split(strings.TrimSpace(string(log)), "\n")
+}
+
+func TestBlueGreenCommands(t *testing.T) {
+	runer := libs.NewSSHRuner()
+	build := func(t *testing.T, command func(config *libs.SSHRunerConfig) (string, error), config libs.SSHRunerConfig, name string) string {
+		t.Helper()
+		config.DockerContainerName = &name
+		built, err := command(&config)
+		if err != nil {
+			t.Fatalf("Building the command failed: %v", err)
+		}
+		return built
+	}
+	image, tag := "nginx", "1.27"
+	plain := libs.SSHRunerConfig{DockerImage: &image, DockerTag: &tag, Direct: true}
+	staticIP := plain
+	staticIP.DockerRunOptions = &libs.DockerRunOptions{Network: "backend", IP: "172.20.0.10"}
+	ported := plain
+	ported.DockerRunOptions = &libs.DockerRunOptions{Ports: []string{"8080:80"}}
+	candidate := libs.CandidateContainerName("web")
+
+	tests := []struct {
+		name     string
+		running  map[string]string
+		commands func(t *testing.T) []string
+		expected map[string]string
+		calls    []string
+	}{
+		{
+			name:    "swap",
+			running: map[string]string{"web": "nginx:1.26"},
+			commands: func(t *testing.T) []string {
+				return []string{
+					build(t, runer.RemoveDockerCommand, plain, candidate),
+					build(t, runer.RunCandidateDockerCommand, plain, "web"),
+					build(t, runer.SwapDockerCommand, plain, "web"),
+				}
+			},
+			expected: map[string]string{"web": "nginx:1.27"},
+		},
+		{
+			name:    "first deployment",
+			running: map[string]string{},
+			commands: func(t *testing.T) []string {
+				return []string{
+					build(t, runer.RunCandidateDockerCommand, plain, "web"),
+					build(t, runer.SwapDockerCommand, plain, "web"),
+				}
+			},
+			expected: map[string]string{"web": "nginx:1.27"},
+		},
+		{
+			name:    "leftover candidate",
+			running: map[string]string{"web": "nginx:1.26", candidate: "nginx:broken"},
+			commands: func(t *testing.T) []string {
+				return []string{
+					build(t, runer.RemoveDockerCommand, plain, candidate),
+					build(t, runer.RunCandidateDockerCommand, plain, "web"),
+					build(t, runer.SwapDockerCommand, plain, "web"),
+				}
+			},
+			expected: map[string]string{"web": "nginx:1.27"},
+		},
+		{
+			// A failed probe removes the candidate, the running container is left alone
+			name:    "rollback",
+			running: map[string]string{"web": "nginx:1.26"},
+			commands: func(t *testing.T) []string {
+				return []string{
+					build(t, runer.RunCandidateDockerCommand, plain, "web"),
+					build(t, runer.RemoveDockerCommand, plain, candidate),
+				}
+			},
+			expected: map[string]string{"web": "nginx:1.26"},
+		},
+		{
+			name:    "static ip",
+			running: map[string]string{"web": "nginx:1.26"},
+			commands: func(t *testing.T) []string {
+				return []string{
+					build(t, runer.RunCandidateDockerCommand, staticIP, "web"),
+					build(t, runer.SwapDockerCommand, staticIP, "web"),
+				}
+			},
+			expected: map[string]string{"web": "nginx:1.27"},
+			calls: []string{
+				"run -d --name web-next --network backend nginx:1.27",
+				"rm -f web",
+				"rename web-next web",
+				"network disconnect backend web",
+				"network connect --ip 172.20.0.10 backend web",
+			},
+		},
+		{
+			// Ports are only published once the old container is gone
+			name:    "recreate with ports",
+			running: map[string]string{"web": "nginx:1.26"},
+			commands: func(t *testing.T) []string {
+				return []string{
+					build(t, runer.RunCandidateDockerCommand, ported, "web"),
+					build(t, runer.RemoveDockerCommand, ported, candidate),
+					build(t, runer.RemoveDockerCommand, ported, "web"),
+					build(t, runer.RunDockerCommand, ported, "web"),
+				}
+			},
+			expected: map[string]string{"web": "nginx:1.27"},
+			calls: []string{
+				"run -d --name web-next nginx:1.27",
+				"rm -f web-next",
+				"rm -f web",
+				"run -d --name web -p 8080:80 nginx:1.27",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containers, calls := blueGreenState(t, test.running, test.commands(t))
+			if !reflect.DeepEqual(containers, test.expected) {
+				names := make([]string, 0, len(containers))
+				for name, image := range containers {
+					names = append(names, name+"="+image)
+				}
+				sort.Strings(names)
+				t.Errorf("Expected containers %v, got %v", test.expected, names)
+			}
+			if test.calls != nil && !reflect.DeepEqual(calls, test.calls) {
+				t.Errorf("Expected docker calls %q, got %q", test.calls, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deployer.com/libs"
+	"deployer.com/modules/stacks"
+	"gopkg.in/yaml.v3"
+)
+
+const testCompose = `services:
+  db:
+    image: postgres:${PG_VERSION:-16}
+    environment:
+      POSTGRES_PASSWORD: ${DB_PASSWORD}
+      POSTGRES_USER: $DB_USER
+      UNKNOWN: ${NOT_SET}
+      PRICE: $$5
+`
+
+func TestRenderCompose_InterpolatesSecrets(t *testing.T) {
+	rendered, err := stacks.RenderCompose(testCompose, map[string]string{
+		"DB_PASSWORD": "p@ss: word\n- injected: true",
+		"DB_USER":     "admin$HOME",
+	})
+	if err != nil {
+		t.Fatalf("RenderCompose failed: %v", err)
+	}
+
+	var document struct {
+		Services map[string]struct {
+			Image       string            `yaml:"image"`
+			Environment map[string]string `yaml:"environment"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(rendered), &document); err != nil {
+		t.Fatalf("Rendered compose is not valid YAML: %v", err)
+	}
+	db := document.Services["db"]
+	if db.Environment["POSTGRES_PASSWORD"] != "p@ss: word\n- injected: true" {
+		t.Errorf("Expected the password as a single value, got %q", db.Environment["POSTGRES_PASSWORD"])
+	}
+	if len(db.Environment) != 4 {
+		t.Errorf("Expected the secret not to add keys, got %v", db.Environment)
+	}
+	if db.Environment["POSTGRES_USER"] != "admin$$HOME" {
+		t.Errorf("Expected $ in a secret to be escaped, got %q", db.Environment["POSTGRES_USER"])
+	}
+	if db.Environment["UNKNOWN"] != "${NOT_SET}" || db.Environment["PRICE"] != "$$5" {
+		t.Errorf("Expected unknown variables and $$ to be left to compose, got %v", db.Environment)
+	}
+	if db.Image != "postgres:${PG_VERSION:-16}" {
+		t.Errorf("Expected the default of an unset variable to be left to compose, got %q", db.Image)
+	}
+}
+
+func TestValidateCompose_RequiresServices(t *testing.T) {
+	if err := stacks.ValidateCompose("version: '3'\n"); err == nil {
+		t.Error("Expected a compose file without services to be rejected")
+	}
+	if err := stacks.ValidateCompose("services: [unclosed"); err == nil {
+		t.Error("Expected invalid YAML to be rejected")
+	}
+	if err := stacks.ValidateCompose(testCompose); err != nil {
+		t.Errorf("Expected a valid compose file, got %v", err)
+	}
+}
+
+func TestComposeUpCommand_UploadsFileOnStdin(t *testing.T) {
+	runer := libs.NewSSHRuner()
+	project := "shop"
+	compose := "services:\n  db:\n    environment:\n      POSTGRES_PASSWORD: 's3cr3t value'\n"
+
+	for _, direct := range []bool{true, false} {
+		home := t.TempDir()
+		config := libs.SSHRunerConfig{IP: "10.0.0.1", User: "deploy", ComposeProject: &project, Direct: direct}
+		command, err := runer.ComposeUpCommand(&config)
+		if err != nil {
+			t.Fatalf("ComposeUpCommand failed: %v", err)
+		}
+		if strings.Contains(command, "s3cr3t") {
+			t.Fatalf("direct=%v: expected the compose file to stay out of the command, got %q", direct, command)
+		}
+		input := []byte(compose)
+		if !direct {
+			input = libs.WorkerInput("", "", input)
+		}
+		output := runShellWithInput(t, "HOME="+libs.ShellQuote(home)+"\nexport HOME\n"+command, input)
+
+		path := filepath.Join(home, ".deployer", "stacks", project, "docker-compose.yml")
+		written, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("direct=%v: expected the compose file to be written: %v", direct, err)
+		}
+		if string(written) != compose {
+			t.Errorf("direct=%v: expected the compose file %q, got %q", direct, compose, written)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("direct=%v: expected the compose file to be readable only by its owner, got %v", direct, info.Mode().Perm())
+		}
+		// The docker stub prints its arguments, pull and up read the uploaded file
+		expected := strings.Join([]string{"compose", "-p", project, "-f", path, "pull", "compose", "-p", project, "-f", path, "up", "-d", "--remove-orphans", ""}, "\x00")
+		if output != expected {
+			t.Errorf("direct=%v: expected docker %q, got %q", direct, expected, output)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deployer.com/libs"
+)
+
+// dockerArgs runs a command built by SSHRuner with the docker stub and returns the arguments docker got
+func dockerArgs(t *testing.T, command string, direct bool) []string {
+	t.Helper()
+	var input []byte
+	if !direct {
+		input = libs.WorkerInput("", "", nil)
+	}
+	output := strings.TrimSuffix(runShellWithInput(t, command, input), "\x00")
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, "\x00")
+}
+
+func TestDeploymentStageCommands(t *testing.T) {
+	runer := libs.NewSSHRuner()
+	user, password := "registry user", "pa ss'word"
+	registry, image, tag, name := "registry.example.com", "team/web", "1.2.3", "web"
+	emptyRegistry, emptyTag := "", ""
+
+	tests := []struct {
+		name     string
+		config   libs.SSHRunerConfig
+		command  func(config *libs.SSHRunerConfig) (string, error)
+		expected []string
+	}{
+		{
+			name:     "login",
+			config:   libs.SSHRunerConfig{DockerUser: &user, DockerPassword: &password},
+			command:  runer.LoginDockerCommand,
+			expected: []string{"login", "-u", user, "--password-stdin"},
+		},
+		{
+			name:    "login without credentials",
+			config:  libs.SSHRunerConfig{},
+			command: runer.LoginDockerCommand,
+		},
+		{
+			name:     "pull",
+			config:   libs.SSHRunerConfig{DockerRegistry: &registry, DockerImage: &image, DockerTag: &tag},
+			command:  runer.PullDockerCommand,
+			expected: []string{"pull", "registry.example.com/team/web:1.2.3"},
+		},
+		{
+			name:     "pull defaults to latest",
+			config:   libs.SSHRunerConfig{DockerRegistry: &emptyRegistry, DockerImage: &image, DockerTag: &emptyTag},
+			command:  runer.PullDockerCommand,
+			expected: []string{"pull", "team/web:latest"},
+		},
+		{
+			name:     "run",
+			config:   libs.SSHRunerConfig{DockerRegistry: &registry, DockerImage: &image, DockerTag: &tag, DockerContainerName: &name},
+			command:  runer.RunDockerCommand,
+			expected: []string{"run", "-d", "--name", "web", "registry.example.com/team/web:1.2.3"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, direct := range []bool{true, false} {
+				config := test.config
+				config.IP, config.User, config.Direct = "10.0.0.1", "deploy", direct
+				command, err := test.command(&config)
+				if err != nil {
+					t.Fatalf("Building the command failed: %v", err)
+				}
+				args := dockerArgs(t, command, direct)
+				if strings.Join(args, "\x00") != strings.Join(test.expected, "\x00") {
+					t.Errorf("direct=%v: expected docker %q, got %q", direct, test.expected, args)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginDockerCommand_ReadsPasswordFromStdin(t *testing.T) {
+	runer := libs.NewSSHRuner()
+	user, password := "registry user", "pa ss'word $(id)"
+	// docker shows what it read on stdin, ssh runs the remote command with this docker and the rest of stdin
+	stub := `docker() { printf '%s\0' "$@"; printf 'stdin=%s' "$(cat)"; }
+ssh() { for arg in "$@"; do remote=$arg; done; eval "$remote"; }
+`
+	for _, direct := range []bool{true, false} {
+		config := libs.SSHRunerConfig{IP: "10.0.0.1", User: "deploy", DockerUser: &user, DockerPassword: &password, Direct: direct}
+		command, err := runer.LoginDockerCommand(&config)
+		if err != nil {
+			t.Fatalf("LoginDockerCommand failed: %v", err)
+		}
+		if strings.Contains(command, "pa ss") {
+			t.Fatalf("direct=%v: expected the password to stay out of the command, got %q", direct, command)
+		}
+		input := []byte(password)
+		if !direct {
+			input = libs.WorkerInput("", "", input)
+		}
+		output := runShellWithInput(t, stub+command, input)
+		expected := strings.Join([]string{"login", "-u", user, "--password-stdin", "stdin=" + password}, "\x00")
+		if output != expected {
+			t.Errorf("direct=%v: expected %q, got %q", direct, expected, output)
+		}
+	}
+}
+
+func TestUploadEnvFileCommand_WritesPrivateFile(t *testing.T) {
+	home := t.TempDir()
+	runer := libs.NewSSHRuner()
+	env := map[string]string{"DB_PASSWORD": "s3cr3t value", "TOKEN": "$(id)"}
+	name := "deployment-7.env"
+	config := libs.SSHRunerConfig{Env: &env, EnvFile: &name, Direct: true}
+
+	content, err := runer.ScriptEnvFile(&config)
+	if err != nil {
+		t.Fatalf("ScriptEnvFile failed: %v", err)
+	}
+	command, err := runer.UploadEnvFileCommand(&config)
+	if err != nil {
+		t.Fatalf("UploadEnvFileCommand failed: %v", err)
+	}
+	if strings.Contains(command, "s3cr3t") {
+		t.Fatalf("Expected the secret to stay out of the command, got %q", command)
+	}
+	runShellWithInput(t, "HOME="+libs.ShellQuote(home)+"\n"+command, []byte(content))
+
+	path := filepath.Join(home, ".deployer", "env", name)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the env file to be written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the env file to be readable only by its owner, got %v", info.Mode().Perm())
+	}
+	output := runShell(t, ". "+libs.ShellQuote(path)+"\nprintf '%s|%s' \"$DB_PASSWORD\" \"$TOKEN\"")
+	if output != "s3cr3t value|$(id)" {
+		t.Errorf("Expected the sourced file to restore the values, got %q", output)
+	}
+
+	if _, err := runer.UploadEnvFileCommand(&libs.SSHRunerConfig{Direct: true}); err == nil {
+		t.Error("Expected an error without an env file name")
+	}
+}
@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"deployer.com/modules/deployments"
+	deploymentsDto "deployer.com/modules/deployments/dto"
+	"deployer.com/modules/runs"
+	"gorm.io/gorm"
+)
+
+func TestExecuteService_RunDeploymentFailsBeforeStart(t *testing.T) {
+	suite := newExecuteTest(t)
+	serverId := suite.createServer(t, startExecServer(t, "hunter2", nil), "hunter2")
+	deploymentId := suite.createDeployment(t, deploymentsDto.CreateDeploymentDto{Name: "web", ServerIDs: []uint{serverId}})
+
+	// The deployment cannot be reset to pending once its run and revision exist
+	callback := "tests:fail_deployment_updates"
+	if err := suite.db.Callback().Update().Before("gorm:update").Register(callback, func(db *gorm.DB) {
+		if db.Statement.Table == "deployments" {
+			db.AddError(errors.New("deployments are read only"))
+		}
+	}); err != nil {
+		t.Fatalf("Failed to register callback: %v", err)
+	}
+	t.Cleanup(func() { suite.db.Callback().Update().Remove(callback) })
+
+	if _, err := suite.service.RunDeployment(deploymentId, suite.user.ID, suite.user.IV); err == nil {
+		t.Fatal("Expected RunDeployment to fail")
+	}
+
+	var run runs.Run
+	if err := suite.db.Where("deployment_id = ?", deploymentId).First(&run).Error; err != nil {
+		t.Fatalf("Failed to load run: %v", err)
+	}
+	if run.Status != runs.RunStatusFailed || run.FinishedAt == nil || !strings.Contains(run.Error, "failed to update deployment status") {
+		t.Errorf("Expected the run to be finished as failed, got %s %v %q", run.Status, run.FinishedAt, run.Error)
+	}
+	revision, err := suite.service.DeploymentsService.GetRevision(deploymentId, suite.user.ID, 1)
+	if err != nil {
+		t.Fatalf("GetRevision failed: %v", err)
+	}
+	if revision.RunID != run.ID || revision.Status != deployments.DeploymentStatusFailed {
+		t.Errorf("Expected the revision of run %d to be failed, got run %d %s", run.ID, revision.RunID, revision.Status)
+	}
+}
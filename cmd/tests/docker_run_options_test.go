@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"deployer.com/libs"
+)
+
+func TestDockerRunOptions_Flags(t *testing.T) {
+	options := libs.DockerRunOptions{
+		Ports:   []string{"8080:80", "127.0.0.1:8443:443/tcp"},
+		Volumes: []string{"/srv/data:/data:ro"},
+		Network: "backend",
+		IP:      "172.20.0.10",
+		Restart: "unless-stopped",
+		Memory:  "512m",
+		CPUs:    "0.5",
+		Labels:  map[string]string{"b": "2", "a": "1"},
+	}
+	tests := []struct {
+		name      string
+		candidate bool
+		expected  []string
+	}{
+		{
+			name:      "container",
+			candidate: false,
+			expected: []string{"-p", "8080:80", "-p", "127.0.0.1:8443:443/tcp", "-v", "/srv/data:/data:ro",
+				"--network", "backend", "--ip", "172.20.0.10", "--restart", "unless-stopped", "--memory", "512m",
+				"--cpus", "0.5", "--label", "a=1", "--label", "b=2"},
+		},
+		{
+			// The running container holds the ports and the static IP
+			name:      "candidate",
+			candidate: true,
+			expected: []string{"-v", "/srv/data:/data:ro", "--network", "backend", "--restart", "unless-stopped",
+				"--memory", "512m", "--cpus", "0.5", "--label", "a=1", "--label", "b=2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := options.Flags(test.candidate)
+			if !reflect.DeepEqual(flags, test.expected) {
+				t.Errorf("Expected %q, got %q", test.expected, flags)
+			}
+		})
+	}
+}
+
+func TestRunCandidateDockerCommand_PublishesNoPorts(t *testing.T) {
+	runer := libs.NewSSHRuner()
+	image, name := "nginx", "web"
+	options := libs.DockerRunOptions{Ports: []string{"8080:80"}, Network: "backend", IP: "172.20.0.10"}
+	config := libs.SSHRunerConfig{DockerImage: &image, DockerContainerName: &name, DockerRunOptions: &options, Direct: true}
+
+	candidate, err := runer.RunCandidateDockerCommand(&config)
+	if err != nil {
+		t.Fatalf("RunCandidateDockerCommand failed: %v", err)
+	}
+	if strings.Contains(candidate, "-p ") || strings.Contains(candidate, "--ip ") {
+		t.Errorf("Expected the candidate without ports and static IP, got %q", candidate)
+	}
+	if !strings.Contains(candidate, "--name web-next ") {
+		t.Errorf("Expected the candidate to run as web-next, got %q", candidate)
+	}
+
+	command, err := runer.RunDockerCommand(&config)
+	if err != nil {
+		t.Fatalf("RunDockerCommand failed: %v", err)
+	}
+	if !strings.Contains(command, "-p 8080:80") || !strings.Contains(command, "--ip 172.20.0.10") {
+		t.Errorf("Expected the container with its ports and static IP, got %q", command)
+	}
+}
+
+func TestDockerRunOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options libs.DockerRunOptions
+		valid   bool
+	}{
+		{name: "empty", options: libs.DockerRunOptions{}, valid: true},
+		{name: "ports", options: libs.DockerRunOptions{Ports: []string{"80", "8080:80", "[::1]:53:53/udp", "9000-9010:9000-9010"}}, valid: true},
+		{name: "injected port", options: libs.DockerRunOptions{Ports: []string{"80; rm -rf /"}}},
+		{name: "relative volume target", options: libs.DockerRunOptions{Volumes: []string{"/srv:data"}}},
+		{name: "ip without network", options: libs.DockerRunOptions{IP: "10.0.0.2"}},
+		{name: "invalid ip", options: libs.DockerRunOptions{Network: "backend", IP: "10.0.0"}},
+		{name: "restart", options: libs.DockerRunOptions{Restart: "on-failure:3"}, valid: true},
+		{name: "invalid restart", options: libs.DockerRunOptions{Restart: "sometimes"}},
+		{name: "invalid memory", options: libs.DockerRunOptions{Memory: "lots"}},
+		{name: "invalid label", options: libs.DockerRunOptions{Labels: map[string]string{"a=b": "c"}}},
+		{name: "healthcheck without command", options: libs.DockerRunOptions{HealthCheck: &libs.DockerHealthCheckOptions{Interval: "5s"}}},
+		{name: "invalid healthcheck interval", options: libs.DockerRunOptions{HealthCheck: &libs.DockerHealthCheckOptions{Command: "true", Interval: "often"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.options.Validate()
+			if test.valid && err != nil {
+				t.Errorf("Expected the options to be valid, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("Expected the options to be rejected")
+			}
+		})
+	}
+}
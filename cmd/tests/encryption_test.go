@@ -1,7 +1,10 @@
 package tests
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"encoding/hex"
+	"strings"
 	"testing"
 
 	"deployer.com/libs"
@@ -61,3 +64,123 @@ func TestEncryptionService_Encrypt_InvalidKey(t *testing.T) {
 		t.Error("Expected error for short key, got nil")
 	}
 }
+
+func TestEncryptionService_UniqueNonces(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", strings.Repeat("ab", 32))
+	es := libs.NewEncryptionService()
+	iv := es.GenIv()
+
+	first, err := es.Encrypt("same value", iv)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	second, err := es.Encrypt("same value", iv)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if first == second {
+		t.Error("Expected two encryptions of the same value to differ")
+	}
+	if !strings.HasPrefix(first, "v2:default:") {
+		t.Errorf("Expected a versioned ciphertext, got %q", first)
+	}
+	for _, encrypted := range []string{first, second} {
+		decrypted, err := es.Decrypt(encrypted, iv)
+		if err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+		if decrypted != "same value" {
+			t.Errorf("Expected 'same value', got %q", decrypted)
+		}
+	}
+
+	tampered := first[:len(first)-2] + "00"
+	if tampered == first {
+		tampered = first[:len(first)-2] + "11"
+	}
+	if _, err := es.Decrypt(tampered, iv); err == nil {
+		t.Error("Expected tampered ciphertext to be rejected")
+	}
+}
+
+func TestEncryptionService_DecryptLegacy(t *testing.T) {
+	key := strings.Repeat("cd", 32)
+	t.Setenv("ENCRYPTION_KEY", key)
+	es := libs.NewEncryptionService()
+	iv := es.GenIv()
+
+	// Values stored before the envelope format used the user IV as nonce
+	keyBytes, _ := hex.DecodeString(key)
+	ivBytes, _ := hex.DecodeString(iv)
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := hex.EncodeToString(gcm.Seal(nil, ivBytes, []byte("legacy value"), nil))
+
+	decrypted, err := es.Decrypt(legacy, iv)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if decrypted != "legacy value" {
+		t.Errorf("Expected 'legacy value', got %q", decrypted)
+	}
+}
+
+func TestEncryptionService_KeyRotation(t *testing.T) {
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	for i := range newKey {
+		newKey[i] = byte(i)
+	}
+	oldRing, err := libs.NewKeyRing(map[string][]byte{"old": oldKey}, "old")
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	rotatedRing, err := libs.NewKeyRing(map[string][]byte{"old": oldKey, "new": newKey}, "new")
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	newRing, err := libs.NewKeyRing(map[string][]byte{"new": newKey}, "new")
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	before := libs.NewEncryptionServiceWithProvider(oldRing)
+	during := libs.NewEncryptionServiceWithProvider(rotatedRing)
+	after := libs.NewEncryptionServiceWithProvider(newRing)
+	iv := before.GenIv()
+
+	encrypted, err := before.Encrypt("rotate me", iv)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if current, _ := during.IsCurrent(encrypted); current {
+		t.Error("Expected a value of the old key not to be current")
+	}
+	reencrypted, err := during.Reencrypt(encrypted, iv)
+	if err != nil {
+		t.Fatalf("Reencrypt failed: %v", err)
+	}
+	if current, _ := during.IsCurrent(reencrypted); !current {
+		t.Errorf("Expected the re-encrypted value to use the new key, got %q", reencrypted)
+	}
+	decrypted, err := after.Decrypt(reencrypted, iv)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if decrypted != "rotate me" {
+		t.Errorf("Expected 'rotate me', got %q", decrypted)
+	}
+	if _, err := after.Decrypt(encrypted, iv); err == nil {
+		t.Error("Expected a value of a removed key not to decrypt")
+	}
+
+	// The key id is authenticated, pointing a value at another key fails
+	swapped := "v2:new:" + strings.TrimPrefix(encrypted, "v2:old:")
+	if _, err := during.Decrypt(swapped, iv); err == nil {
+		t.Error("Expected a value with a swapped key id not to decrypt")
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"deployer.com/modules/containers"
+	"deployer.com/modules/deployments"
+	deploymentsDto "deployer.com/modules/deployments/dto"
+	"deployer.com/modules/domains"
+	"deployer.com/modules/execute"
+	"deployer.com/modules/projects"
+	"deployer.com/modules/runs"
+	"deployer.com/modules/scripts"
+	scriptsDto "deployer.com/modules/scripts/dto"
+	"deployer.com/modules/secrets"
+	"deployer.com/modules/servers"
+	serversDto "deployer.com/modules/servers/dto"
+	"deployer.com/modules/stacks"
+	"deployer.com/modules/users"
+	"gorm.io/gorm"
+)
+
+// executeTest runs an ExecuteService in direct mode against exec test servers. It needs DATABASE_URL,
+// everything it stores is removed again together with the user
+type executeTest struct {
+	db      *gorm.DB
+	user    users.User
+	service *execute.ExecuteService
+}
+
+func newExecuteTest(t *testing.T) *executeTest {
+	t.Helper()
+	db := testDB(t)
+	user := createTestUser(t, db)
+	t.Setenv("ENCRYPTION_KEY", strings.Repeat("ab", 32))
+	t.Setenv("EXECUTE_MODE", "direct")
+	// The exec test server runs the commands locally, the files they leave in $HOME go with the test
+	t.Setenv("HOME", t.TempDir())
+
+	service := execute.NewExecuteService(
+		scripts.NewScriptsService(db),
+		servers.NewServersService(db),
+		secrets.NewSecretsService(db),
+		containers.NewContainersService(db),
+		deployments.NewDeploymentsService(db),
+		projects.NewProjectsService(db),
+		domains.NewDomainsService(db),
+		stacks.NewStacksService(db),
+		runs.NewRunsService(db),
+		nil,
+	)
+	t.Cleanup(func() {
+		deploymentIds := db.Model(&deployments.Deployment{}).Select("id").Where("user_id = ?", user.ID)
+		projectIds := db.Model(&projects.Project{}).Select("id").Where("user_id = ?", user.ID)
+		secretIds := db.Model(&secrets.Secret{}).Select("id").Where("user_id = ?", user.ID)
+		db.Unscoped().Where("project_id IN (?)", projectIds).Delete(&projects.ProjectDeployments{})
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&projects.Project{})
+		db.Unscoped().Where("deployment_id IN (?)", deploymentIds).Delete(&deployments.DeployedContainer{})
+		db.Unscoped().Where("deployment_id IN (?)", deploymentIds).Delete(&deployments.DeploymentRevision{})
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&deployments.Deployment{})
+		db.Unscoped().Where("secret_id IN (?)", secretIds).Delete(&secrets.SecretVersion{})
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&secrets.Secret{})
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&scripts.Script{})
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&servers.Server{})
+	})
+	return &executeTest{db: db, user: user, service: service}
+}
+
+// createServer stores a password login to the exec test server, its host key is pinned on first use. Several
+// servers may point to the same exec test server
+func (e *executeTest) createServer(t *testing.T, server *execServer, password string) uint {
+	t.Helper()
+	created, err := e.service.ServersService.CreateServer(e.user.ID, serversDto.CreateServerDto{
+		Name:     fmt.Sprintf("exec %d", time.Now().UnixNano()),
+		Host:     server.Host,
+		Port:     server.Port,
+		Username: "deploy",
+		Password: password,
+	}, e.user.IV)
+	if err != nil {
+		t.Fatalf("CreateServer failed: %v", err)
+	}
+	return created.ID
+}
+
+func (e *executeTest) createScript(t *testing.T, name, script string, timeout int) uint {
+	t.Helper()
+	created, err := e.service.ScriptsService.CreateScript(e.user.ID, scriptsDto.CreateScriptDto{Name: name, Script: script, Timeout: timeout}, e.user.IV)
+	if err != nil {
+		t.Fatalf("CreateScript failed: %v", err)
+	}
+	return created.ID
+}
+
+func (e *executeTest) createDeployment(t *testing.T, deployment deploymentsDto.CreateDeploymentDto) uint {
+	t.Helper()
+	created, err := e.service.DeploymentsService.CreateDeployment(e.user.ID, deployment)
+	if err != nil {
+		t.Fatalf("CreateDeployment failed: %v", err)
+	}
+	return created.ID
+}
+
+// wait blocks until the run finished and returns its result with the output of every step
+func (e *executeTest) wait(t *testing.T, runId uint) runs.RunResultResponse {
+	t.Helper()
+	result, finished, err := e.service.RunsService.WaitRun(runId, e.user.ID, 30*time.Second)
+	if err != nil {
+		t.Fatalf("WaitRun failed: %v", err)
+	}
+	if !finished {
+		t.Fatalf("Expected run %d to finish, it is still %s", runId, result.Status)
+	}
+	return result
+}
+
+// steps returns the names of the steps the run recorded for the server, in order
+func (e *executeTest) steps(result runs.RunResultResponse, serverId uint) []string {
+	names := make([]string, 0)
+	for _, step := range result.Steps {
+		if step.ServerID != nil && *step.ServerID == serverId {
+			names = append(names, step.Name)
+		}
+	}
+	return names
+}
+
+// hasStep tells whether the run has a step called name in status
+func (e *executeTest) hasStep(t *testing.T, runId uint, name string, status runs.RunStatus) bool {
+	t.Helper()
+	run, err := e.service.RunsService.GetRun(runId, e.user.ID)
+	if err != nil {
+		t.Fatalf("GetRun failed: %v", err)
+	}
+	for _, step := range run.Steps {
+		if step.Name == name && step.Status == status {
+			return true
+		}
+	}
+	return false
+}
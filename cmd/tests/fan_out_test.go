@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/execute/dto"
+	"deployer.com/modules/runs"
+)
+
+// fanOutServers stores count servers on the same exec test server, the ones in failing log in with a wrong
+// password so their connection test fails
+func fanOutServers(t *testing.T, suite *executeTest, count int, failing map[int]bool) []uint {
+	t.Helper()
+	server := startExecServer(t, "hunter2", nil)
+	ids := make([]uint, count)
+	for i := range ids {
+		password := "hunter2"
+		if failing[i] {
+			password = "wrong"
+		}
+		ids[i] = suite.createServer(t, server, password)
+	}
+	return ids
+}
+
+func TestRunScript_LimitsConcurrency(t *testing.T) {
+	suite := newExecuteTest(t)
+	serverIds := fanOutServers(t, suite, 6, nil)
+
+	tests := []struct {
+		name        string
+		concurrency int
+		expected    int
+	}{
+		{name: "default", concurrency: 0, expected: 5},
+		{name: "sequential", concurrency: 1, expected: 1},
+		{name: "limited", concurrency: 3, expected: 3},
+		{name: "more than servers", concurrency: 50, expected: 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Every server runs the script on this machine, it counts the scripts running next to it
+			dir := t.TempDir()
+			script := fmt.Sprintf(`dir=%s
+touch "$dir/running.$$"
+ls "$dir" | grep -c '^running' > "$dir/seen.$$"
+sleep 0.5
+rm "$dir/running.$$"`, libs.ShellQuote(dir))
+			scriptId := suite.createScript(t, test.name, script, 0)
+
+			runId, err := suite.service.RunScript(suite.user.ID, suite.user.IV, dto.RunScriptDto{ScriptID: scriptId, ServerIDs: serverIds, Concurrency: test.concurrency})
+			if err != nil {
+				t.Fatalf("RunScript failed: %v", err)
+			}
+			result := suite.wait(t, runId)
+			if result.Status != runs.RunStatusSuccess {
+				t.Fatalf("Expected the run to succeed, got %s: %s", result.Status, result.Error)
+			}
+			for _, serverId := range serverIds {
+				if steps := suite.steps(result, serverId); strings.Join(steps, ",") != "verify_host_key,connection_test,script" {
+					t.Errorf("Expected server %d to run the script once, got %v", serverId, steps)
+				}
+			}
+
+			seen, err := filepath.Glob(filepath.Join(dir, "seen.*"))
+			if err != nil || len(seen) != len(serverIds) {
+				t.Fatalf("Expected a count from every server, got %v %v", seen, err)
+			}
+			peak := 0
+			for _, path := range seen {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("Failed to read count: %v", err)
+				}
+				count, err := strconv.Atoi(strings.TrimSpace(string(content)))
+				if err != nil {
+					t.Fatalf("Unexpected count %q: %v", content, err)
+				}
+				peak = max(peak, count)
+			}
+			if peak != test.expected {
+				t.Errorf("Expected %d servers at once, got %d", test.expected, peak)
+			}
+		})
+	}
+}
+
+func TestRunScript_FanOutErrors(t *testing.T) {
+	suite := newExecuteTest(t)
+	scriptId := suite.createScript(t, "hello", "echo hello", 0)
+
+	tests := []struct {
+		name    string
+		servers int
+		failing map[int]bool
+		prefix  string
+	}{
+		{name: "success", servers: 3},
+		{name: "single server", servers: 1, failing: map[int]bool{0: true}, prefix: "connection_test: "},
+		{name: "one of several", servers: 3, failing: map[int]bool{1: true}, prefix: "failed on 1 of 3 servers, first error: connection_test: "},
+		{name: "several", servers: 3, failing: map[int]bool{0: true, 2: true}, prefix: "failed on 2 of 3 servers, first error: connection_test: "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverIds := fanOutServers(t, suite, test.servers, test.failing)
+			runId, err := suite.service.RunScript(suite.user.ID, suite.user.IV, dto.RunScriptDto{ScriptID: scriptId, ServerIDs: serverIds})
+			if err != nil {
+				t.Fatalf("RunScript failed: %v", err)
+			}
+			result := suite.wait(t, runId)
+			if test.prefix == "" {
+				if result.Status != runs.RunStatusSuccess {
+					t.Errorf("Expected the run to succeed, got %s: %s", result.Status, result.Error)
+				}
+				return
+			}
+			if result.Status != runs.RunStatusFailed || !strings.HasPrefix(result.Error, test.prefix) {
+				t.Errorf("Expected the run to fail with %q, got %s: %s", test.prefix, result.Status, result.Error)
+			}
+			statuses := make(map[uint]runs.RunStatus)
+			for _, server := range result.Servers {
+				statuses[server.ServerID] = server.Status
+			}
+			for i, serverId := range serverIds {
+				expected := runs.RunStatusSuccess
+				if test.failing[i] {
+					expected = runs.RunStatusFailed
+				}
+				if statuses[serverId] != expected {
+					t.Errorf("Expected server %d to end as %s, got %s", serverId, expected, statuses[serverId])
+				}
+			}
+		})
+	}
+}
+
+func TestRunScript_SkipsServersAfterMaxFailures(t *testing.T) {
+	suite := newExecuteTest(t)
+
+	tests := []struct {
+		name    string
+		script  string
+		options dto.RunScriptDto
+		failing map[int]bool
+		cancel  bool
+		status  runs.RunStatus
+		ran     int
+		reason  string
+	}{
+		{
+			name:    "max failures",
+			script:  "echo hello",
+			options: dto.RunScriptDto{Concurrency: 1, MaxFailures: 2},
+			failing: map[int]bool{0: true, 1: true, 2: true, 3: true, 4: true},
+			status:  runs.RunStatusFailed,
+			ran:     2,
+			reason:  "skipped after 2 failure(s)",
+		},
+		{
+			name:    "canceled",
+			script:  "sleep 30",
+			options: dto.RunScriptDto{Concurrency: 1},
+			cancel:  true,
+			status:  runs.RunStatusCanceled,
+			ran:     1,
+			reason:  "skipped: context canceled",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverIds := fanOutServers(t, suite, 5, test.failing)
+			test.options.ScriptID = suite.createScript(t, test.name, test.script, 0)
+			test.options.ServerIDs = serverIds
+			runId, err := suite.service.RunScript(suite.user.ID, suite.user.IV, test.options)
+			if err != nil {
+				t.Fatalf("RunScript failed: %v", err)
+			}
+			if test.cancel {
+				// The run is canceled once the first server runs the script
+				deadline := time.Now().Add(10 * time.Second)
+				for !suite.hasStep(t, runId, "script", runs.RunStatusRunning) {
+					if time.Now().After(deadline) {
+						t.Fatal("Expected the script to start on the first server")
+					}
+					time.Sleep(20 * time.Millisecond)
+				}
+				if err := suite.service.RunsService.CancelRun(runId, suite.user.ID); err != nil {
+					t.Fatalf("CancelRun failed: %v", err)
+				}
+			}
+
+			result := suite.wait(t, runId)
+			if result.Status != test.status {
+				t.Errorf("Expected the run to end as %s, got %s: %s", test.status, result.Status, result.Error)
+			}
+			for i, serverId := range serverIds {
+				steps := suite.steps(result, serverId)
+				if i < test.ran {
+					if len(steps) < 2 || steps[0] != "verify_host_key" {
+						t.Errorf("Expected server %d to run, got %v", serverId, steps)
+					}
+					continue
+				}
+				if strings.Join(steps, ",") != "script" {
+					t.Errorf("Expected server %d to only get a skipped step, got %v", serverId, steps)
+				}
+			}
+			for _, step := range result.Steps {
+				if step.Status == runs.RunStatusSkipped && (step.Name != "script" || !strings.HasPrefix(step.Error, test.reason)) {
+					t.Errorf("Expected the skipped steps to give %q, got %+v", test.reason, step)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"testing"
+
+	"deployer.com/libs"
+	"golang.org/x/crypto/ssh"
+)
+
+// startHostKeyServer runs an ssh server presenting every signer as a host key, it only completes key exchange
+func startHostKeyServer(t *testing.T, signers ...ssh.Signer) (string, int) {
+	t.Helper()
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	for _, signer := range signers {
+		config.AddHostKey(signer)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+	address := listener.Addr().(*net.TCPAddr)
+	return address.IP.String(), address.Port
+}
+
+func newHostSigners(t *testing.T) (ssh.Signer, ssh.Signer) {
+	t.Helper()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ed25519 key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate rsa key: %v", err)
+	}
+	edSigner, err := ssh.NewSignerFromKey(edKey)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	rsaSigner, err := ssh.NewSignerFromKey(rsaKey)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return edSigner, rsaSigner
+}
+
+func TestFetchHostKey_ByType(t *testing.T) {
+	edSigner, rsaSigner := newHostSigners(t)
+	host, port := startHostKeyServer(t, edSigner, rsaSigner)
+	executor := libs.NewSSHExecutor()
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		algorithms []string
+		expected   ssh.PublicKey
+	}{
+		{name: "rsa", algorithms: libs.HostKeyAlgorithms(ssh.KeyAlgoRSA), expected: rsaSigner.PublicKey()},
+		{name: "ed25519", algorithms: libs.HostKeyAlgorithms(ssh.KeyAlgoED25519), expected: edSigner.PublicKey()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := executor.FetchHostKey(ctx, host, port, test.algorithms)
+			if err != nil {
+				t.Fatalf("FetchHostKey failed: %v", err)
+			}
+			if libs.HostKeyFingerprint(key) != libs.HostKeyFingerprint(test.expected) {
+				t.Errorf("Expected %s, got %s", libs.HostKeyFingerprint(test.expected), libs.HostKeyFingerprint(key))
+			}
+		})
+	}
+
+	if _, err := executor.FetchHostKey(ctx, host, port, libs.HostKeyAlgorithms(ssh.KeyAlgoECDSA256)); err == nil {
+		t.Error("Expected an error for a key type the server does not have")
+	}
+}
+
+func TestFetchHostKeys_ReturnsEveryKey(t *testing.T) {
+	edSigner, rsaSigner := newHostSigners(t)
+	host, port := startHostKeyServer(t, edSigner, rsaSigner)
+
+	keys, err := libs.NewSSHExecutor().FetchHostKeys(context.Background(), host, port)
+	if err != nil {
+		t.Fatalf("FetchHostKeys failed: %v", err)
+	}
+	fingerprints := make(map[string]bool)
+	for _, key := range keys {
+		fingerprints[libs.HostKeyFingerprint(key)] = true
+	}
+	for _, signer := range []ssh.Signer{edSigner, rsaSigner} {
+		if !fingerprints[libs.HostKeyFingerprint(signer.PublicKey())] {
+			t.Errorf("Expected %s among the host keys", libs.HostKeyFingerprint(signer.PublicKey()))
+		}
+	}
+	if len(keys) != 2 {
+		t.Errorf("Expected 2 host keys, got %d", len(keys))
+	}
+}
+
+func TestPinnedHostKeyCallback(t *testing.T) {
+	edSigner, rsaSigner := newHostSigners(t)
+	callback := libs.PinnedHostKeyCallback(libs.HostKeyFingerprint(edSigner.PublicKey()))
+	if err := callback("server", nil, edSigner.PublicKey()); err != nil {
+		t.Errorf("Expected the pinned key to be accepted, got %v", err)
+	}
+	err := callback("server", nil, rsaSigner.PublicKey())
+	if _, ok := err.(*libs.HostKeyMismatchError); !ok {
+		t.Errorf("Expected a HostKeyMismatchError for another key, got %v", err)
+	}
+}
+
+func TestKnownHostsLine(t *testing.T) {
+	edSigner, _ := newHostSigners(t)
+	key := libs.MarshalHostKey(edSigner.PublicKey())
+	tests := []struct {
+		name     string
+		host     string
+		port     int
+		expected string
+	}{
+		{name: "default port", host: "10.0.0.1", port: 0, expected: "10.0.0.1 " + key},
+		{name: "port 22", host: "example.com", port: 22, expected: "example.com " + key},
+		{name: "other port", host: "10.0.0.1", port: 2222, expected: "[10.0.0.1]:2222 " + key},
+		{name: "ipv6", host: "::1", port: 2222, expected: "[::1]:2222 " + key},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line := libs.KnownHostsLine(test.host, test.port, edSigner.PublicKey())
+			if line != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, line)
+			}
+		})
+	}
+}
+
+func TestParseHostKey_RoundTrip(t *testing.T) {
+	edSigner, rsaSigner := newHostSigners(t)
+	for _, signer := range []ssh.Signer{edSigner, rsaSigner} {
+		marshaled := libs.MarshalHostKey(signer.PublicKey())
+		// A comment after the key, as ssh-keyscan and authorized_keys have it, is ignored
+		key, err := libs.ParseHostKey(marshaled + " root@server")
+		if err != nil {
+			t.Fatalf("ParseHostKey failed: %v", err)
+		}
+		if libs.HostKeyFingerprint(key) != libs.HostKeyFingerprint(signer.PublicKey()) {
+			t.Errorf("Expected %s to parse back to the same key", marshaled)
+		}
+	}
+	for _, invalid := range []string{"", "ssh-ed25519", "ssh-ed25519 not-base64", "SHA256:abc"} {
+		if _, err := libs.ParseHostKey(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestSSHExecutor_NegotiatesPinnedKeyType(t *testing.T) {
+	_, rsaSigner := newHostSigners(t)
+	server := startExecServer(t, "hunter2", nil, rsaSigner)
+	preferred, err := libs.NewSSHExecutor().FetchHostKey(context.Background(), server.Host, server.Port, nil)
+	if err != nil {
+		t.Fatalf("FetchHostKey failed: %v", err)
+	}
+	// other is the key the server only presents when the client asks for its type
+	other := rsaSigner.PublicKey()
+	if libs.HostKeyFingerprint(preferred) == libs.HostKeyFingerprint(other) {
+		other = server.HostKey.PublicKey()
+	}
+
+	tests := []struct {
+		name     string
+		hostKey  string
+		key      ssh.PublicKey
+		mismatch bool
+	}{
+		{name: "preferred fingerprint", key: preferred},
+		{name: "preferred key", hostKey: libs.MarshalHostKey(preferred), key: preferred},
+		{name: "other key", hostKey: libs.MarshalHostKey(other), key: other},
+		// Without the key its type is unknown, the server presents the key it prefers
+		{name: "other fingerprint", key: other, mismatch: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := server.ExecutorConfig()
+			config.Password = "hunter2"
+			config.HostKey = test.hostKey
+			config.HostKeyFingerprint = libs.HostKeyFingerprint(test.key)
+			_, err := libs.NewSSHExecutor().Run(context.Background(), &config, "true", nil)
+			var mismatch *libs.HostKeyMismatchError
+			if test.mismatch != errors.As(err, &mismatch) {
+				t.Errorf("Expected mismatch=%v, got %v", test.mismatch, err)
+			}
+			if !test.mismatch && err != nil {
+				t.Errorf("Run failed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"deployer.com/libs"
+)
+
+func TestLoadKeyRingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# rotated 2026-10\n" + strings.Repeat("ab", 32) + "\n\nnext:" + strings.Repeat("cd", 32) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyRing, err := libs.LoadKeyRingFile(path, "next")
+	if err != nil {
+		t.Fatalf("LoadKeyRingFile failed: %v", err)
+	}
+	es := libs.NewEncryptionServiceWithProvider(keyRing)
+	encrypted, err := es.Encrypt("from a file", es.GenIv())
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !strings.HasPrefix(encrypted, "v2:next:") {
+		t.Errorf("Expected the active key next, got %q", encrypted)
+	}
+	decrypted, err := es.Decrypt(encrypted, "")
+	if err != nil || decrypted != "from a file" {
+		t.Errorf("Expected 'from a file', got %q (%v)", decrypted, err)
+	}
+
+	if _, err := libs.LoadKeyRingFile(path, "missing"); err == nil {
+		t.Error("Expected an active key that is not in the file to be rejected")
+	}
+}
+
+// fakeTransit emulates the encrypt and decrypt endpoints of the Vault transit engine
+func fakeTransit(token string) *httptest.Server {
+	var mutex sync.Mutex
+	sealed := make(map[string]string)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		var request map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/deployer":
+			ciphertext := "vault:v1:" + strings.Repeat("x", len(sealed)+1)
+			sealed[ciphertext] = request["plaintext"]
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": ciphertext}})
+		case "/v1/transit/decrypt/deployer":
+			plaintext, ok := sealed[request["ciphertext"]]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors":["invalid ciphertext"]}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": plaintext}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func TestVaultKeyProvider_FakeTransit(t *testing.T) {
+	server := fakeTransit("test-token")
+	defer server.Close()
+
+	legacyKey := bytes.Repeat([]byte{7}, 32)
+	legacyRing, err := libs.NewKeyRing(map[string][]byte{libs.DefaultKeyID: legacyKey}, libs.DefaultKeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := libs.NewEncryptionService().GenIv()
+	old, err := libs.NewEncryptionServiceWithProvider(legacyRing).Encrypt("before vault", iv)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	provider, err := libs.NewVaultKeyProvider(libs.VaultKeyProviderConfig{
+		Address:    server.URL,
+		Token:      "test-token",
+		KeyName:    "deployer",
+		LegacyKeys: map[string][]byte{libs.DefaultKeyID: legacyKey},
+	})
+	if err != nil {
+		t.Fatalf("NewVaultKeyProvider failed: %v", err)
+	}
+	es := libs.NewEncryptionServiceWithProvider(provider)
+	encrypted, err := es.Encrypt("sealed by vault", iv)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !strings.HasPrefix(encrypted, "v2:deployer:") {
+		t.Errorf("Expected the transit key id, got %q", encrypted)
+	}
+	for value, expected := range map[string]string{encrypted: "sealed by vault", old: "before vault"} {
+		decrypted, err := es.Decrypt(value, iv)
+		if err != nil || decrypted != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, decrypted, err)
+		}
+	}
+
+	denied, err := libs.NewVaultKeyProvider(libs.VaultKeyProviderConfig{Address: server.URL, Token: "wrong", KeyName: "deployer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := libs.NewEncryptionServiceWithProvider(denied).Encrypt("x", iv); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected the vault error to be reported, got %v", err)
+	}
+}
+
+// TestVaultKeyProvider_DevServer runs against a dev mode Vault (vault server -dev) when VAULT_ADDR and
+// VAULT_TOKEN are set
+func TestVaultKeyProvider_DevServer(t *testing.T) {
+	address, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if address == "" || token == "" {
+		t.Skip("VAULT_ADDR and VAULT_TOKEN are not set")
+	}
+	vault := func(path, body string) {
+		req, _ := http.NewRequest(http.MethodPost, address+"/v1/"+path, strings.NewReader(body))
+		req.Header.Set("X-Vault-Token", token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("vault %s failed: %v", path, err)
+		}
+		res.Body.Close()
+	}
+	// Both fail harmlessly when the engine or the key exist already
+	vault("sys/mounts/transit", `{"type":"transit"}`)
+	vault("transit/keys/deployer-test", `{}`)
+
+	provider, err := libs.NewVaultKeyProvider(libs.VaultKeyProviderConfig{Address: address, Token: token, KeyName: "deployer-test"})
+	if err != nil {
+		t.Fatalf("NewVaultKeyProvider failed: %v", err)
+	}
+	es := libs.NewEncryptionServiceWithProvider(provider)
+	encrypted, err := es.Encrypt("dev vault", "")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	decrypted, err := es.Decrypt(encrypted, "")
+	if err != nil || decrypted != "dev vault" {
+		t.Errorf("Expected 'dev vault', got %q (%v)", decrypted, err)
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"deployer.com/libs"
+	deploymentsDto "deployer.com/modules/deployments/dto"
+	"deployer.com/modules/projects"
+	projectsDto "deployer.com/modules/projects/dto"
+)
+
+func TestRunProject_Groups(t *testing.T) {
+	suite := newExecuteTest(t)
+	serverId := suite.createServer(t, startExecServer(t, "hunter2", nil), "hunter2")
+
+	tests := []struct {
+		name   string
+		orders []int
+		groups [][]int
+	}{
+		{name: "single", orders: []int{0}, groups: [][]int{{0}}},
+		{name: "sorted by order", orders: []int{3, 1, 2}, groups: [][]int{{1}, {2}, {0}}},
+		{name: "shared order", orders: []int{2, 1, 2, 1, 5}, groups: [][]int{{1, 3}, {0, 2}, {4}}},
+		{name: "negative orders", orders: []int{0, -1}, groups: [][]int{{1}, {0}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Every deployment runs a script on this machine, it tells how many deployments finished before it started
+			dir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dir, "done"), 0o755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			pipeline := make([]projectsDto.ProjectDeployments, len(test.orders))
+			for i, order := range test.orders {
+				name := fmt.Sprintf("%s %d", test.name, i)
+				script := fmt.Sprintf(`dir=%s name=%d
+ls "$dir/done" | wc -l > "$dir/before.$name"
+sleep 0.5
+touch "$dir/done/$name"`, libs.ShellQuote(dir), i)
+				pipeline[i] = projectsDto.ProjectDeployments{
+					DeploymentID: suite.createDeployment(t, deploymentsDto.CreateDeploymentDto{
+						Name:       name,
+						RunScripts: true,
+						ServerIDs:  []uint{serverId},
+						ScriptIDs:  []uint{suite.createScript(t, name, script, 0)},
+					}),
+					Order: order,
+				}
+			}
+			project, err := suite.service.ProjectsService.CreateProject(suite.user.ID, projectsDto.CreateProjectDto{Name: test.name, ProjectDeployments: pipeline})
+			if err != nil {
+				t.Fatalf("CreateProject failed: %v", err)
+			}
+
+			if err := suite.service.RunProject(project.ID, suite.user.ID, suite.user.IV, false); err != nil {
+				t.Fatalf("RunProject failed: %v", err)
+			}
+			deadline := time.Now().Add(30 * time.Second)
+			for {
+				entity, err := suite.service.ProjectsService.GetProjectEntity(project.ID, suite.user.ID)
+				if err != nil {
+					t.Fatalf("GetProjectEntity failed: %v", err)
+				}
+				if projectFinished(entity) {
+					for _, pd := range entity.ProjectDeployments {
+						if pd.Status != "success" {
+							t.Fatalf("Expected deployment %d to succeed, got %s: %s", pd.DeploymentID, pd.Status, pd.Logs)
+						}
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("Expected the pipeline to finish")
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			// The deployments of a group start together once the groups before it finished
+			finished := 0
+			for _, group := range test.groups {
+				for _, i := range group {
+					content, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("before.%d", i)))
+					if err != nil {
+						t.Fatalf("Expected deployment %d to run: %v", i, err)
+					}
+					if before, err := strconv.Atoi(strings.TrimSpace(string(content))); err != nil || before != finished {
+						t.Errorf("Expected deployment %d to start after %d deployments, got %q", i, finished, content)
+					}
+				}
+				finished += len(group)
+			}
+		})
+	}
+}
+
+// projectFinished tells whether every deployment of the pipeline reached a final status
+func projectFinished(project projects.Project) bool {
+	for _, pd := range project.ProjectDeployments {
+		if pd.Status == "pending" || pd.Status == "running" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,268 @@
+package tests
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"deployer.com/modules/deployments"
+	deploymentsDto "deployer.com/modules/deployments/dto"
+	"deployer.com/modules/domains"
+	"deployer.com/modules/execute"
+	"deployer.com/modules/runs"
+	"deployer.com/modules/scripts"
+	"deployer.com/modules/secrets"
+	secretsDto "deployer.com/modules/secrets/dto"
+	"deployer.com/modules/servers"
+	"gorm.io/gorm"
+)
+
+func revisionDeployment() deployments.Deployment {
+	return deployments.Deployment{
+		Name:               "web",
+		RunContainers:      true,
+		SetSecretsToServer: true,
+		Timeout:            300,
+		Strategy:           deployments.DeploymentStrategyRolling,
+		BatchSize:          2,
+		HealthCheck:        "curl -fs localhost",
+		BlueGreen:          true,
+		HealthProbe:        "true",
+		Servers:            []servers.Server{{Model: &gorm.Model{ID: 1}}, {Model: &gorm.Model{ID: 2}}},
+		Domains:            []domains.Domain{{Model: gorm.Model{ID: 3}}},
+		SubDomains:         []domains.SubDomain{{Model: gorm.Model{ID: 4}, DomainID: 3}},
+		Secrets:            []secrets.Secret{{Model: &gorm.Model{ID: 5, UpdatedAt: time.Unix(1700000000, 0)}, Name: "db", Version: 4}},
+	}
+}
+
+func TestRevisionSnapshot_Apply(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(deployment *deployments.Deployment)
+		secrets []uint
+	}{
+		{name: "secrets on the server", secrets: []uint{5}},
+		{
+			name: "secrets in the container",
+			modify: func(deployment *deployments.Deployment) {
+				deployment.SetSecretsToServer, deployment.SetSecretsToContainer = false, true
+			},
+			secrets: []uint{5},
+		},
+		{
+			// Secrets a run does not use are left out of the revision
+			name: "secrets not used",
+			modify: func(deployment *deployments.Deployment) {
+				deployment.SetSecretsToServer = false
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := revisionDeployment()
+			if test.modify != nil {
+				test.modify(&deployment)
+			}
+			snapshot := deployments.NewRevisionSnapshot(deployment)
+			for _, secret := range snapshot.Secrets {
+				if secret.Version != 4 {
+					t.Errorf("Expected the snapshot to record version 4 of secret %s, got %d", secret.Name, secret.Version)
+				}
+			}
+			snapshot.Containers = []deployments.RevisionContainer{{ID: 6, Name: "web", Image: "nginx"}}
+
+			// The deployment changed since the revision was taken
+			current := deployments.Deployment{
+				Model:    gorm.Model{ID: 7},
+				Name:     "web renamed",
+				Strategy: deployments.DeploymentStrategySequential,
+				Servers:  []servers.Server{{Model: &gorm.Model{ID: 9}}},
+				Scripts:  []scripts.Script{{Name: "migrate"}},
+			}
+			snapshot.Apply(&current)
+
+			if current.ID != 7 || current.Name != "web renamed" {
+				t.Errorf("Expected the identity of the deployment to be kept, got %d %q", current.ID, current.Name)
+			}
+			settings := func(d deployments.Deployment) []interface{} {
+				return []interface{}{d.RunContainers, d.SetSecretsToServer, d.SetSecretsToContainer, d.Timeout, d.Strategy, d.BatchSize, d.HealthCheck, d.BlueGreen, d.HealthProbe}
+			}
+			if !reflect.DeepEqual(settings(current), settings(deployment)) {
+				t.Errorf("Expected the settings %v, got %v", settings(deployment), settings(current))
+			}
+			ids := func(d deployments.Deployment) [][]uint {
+				result := make([][]uint, 5)
+				for _, server := range d.Servers {
+					result[0] = append(result[0], server.ID)
+				}
+				for _, domain := range d.Domains {
+					result[1] = append(result[1], domain.ID)
+				}
+				for _, subDomain := range d.SubDomains {
+					result[2] = append(result[2], subDomain.ID, subDomain.DomainID)
+				}
+				for _, container := range d.Containers {
+					result[3] = append(result[3], container.ID)
+				}
+				for _, secret := range d.Secrets {
+					result[4] = append(result[4], secret.ID)
+				}
+				return result
+			}
+			expected := [][]uint{{1, 2}, {3}, {4, 3}, {6}, test.secrets}
+			if got := ids(current); !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected the relations %v, got %v", expected, got)
+			}
+			if current.Scripts != nil {
+				t.Errorf("Expected the scripts to come from the snapshot only, got %v", current.Scripts)
+			}
+		})
+	}
+}
+
+func TestRevisionSnapshot_WithoutContents(t *testing.T) {
+	snapshot := deployments.RevisionSnapshot{
+		Scripts: []deployments.RevisionScript{{ID: 1, Name: "migrate", Script: "encrypted script", Timeout: 60}},
+		Stacks:  []deployments.RevisionStack{{ID: 2, Name: "db", Compose: "encrypted compose", SecretIDs: []uint{3}}},
+	}
+	result := snapshot.WithoutContents()
+
+	if result.Scripts[0].Script != "" || result.Stacks[0].Compose != "" {
+		t.Errorf("Expected the contents to be blanked, got %+v %+v", result.Scripts[0], result.Stacks[0])
+	}
+	if result.Scripts[0].Name != "migrate" || result.Scripts[0].Timeout != 60 || result.Stacks[0].Name != "db" || !reflect.DeepEqual(result.Stacks[0].SecretIDs, []uint{3}) {
+		t.Errorf("Expected everything but the contents to be kept, got %+v %+v", result.Scripts[0], result.Stacks[0])
+	}
+	if snapshot.Scripts[0].Script != "encrypted script" || snapshot.Stacks[0].Compose != "encrypted compose" {
+		t.Error("Expected the stored snapshot to be left unchanged")
+	}
+}
+
+func TestDeploymentsService_RollbackRevision(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	service := deployments.NewDeploymentsService(db)
+
+	success, failed := deployments.DeploymentStatusSuccess, deployments.DeploymentStatusFailed
+	tests := []struct {
+		name     string
+		statuses []deployments.DeploymentStatus
+		expected int
+	}{
+		{name: "previous success", statuses: []deployments.DeploymentStatus{success, success}, expected: 1},
+		{name: "skips failed revisions", statuses: []deployments.DeploymentStatus{success, failed, failed}, expected: 1},
+		{name: "latest failed", statuses: []deployments.DeploymentStatus{success, success, failed}, expected: 2},
+		{name: "nothing to roll back to", statuses: []deployments.DeploymentStatus{failed, success}},
+		{name: "single revision", statuses: []deployments.DeploymentStatus{success}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := deployments.Deployment{Name: test.name, UserID: user.ID, Status: deployments.DeploymentStatusPending}
+			if err := db.Create(&deployment).Error; err != nil {
+				t.Fatalf("Failed to create deployment: %v", err)
+			}
+			t.Cleanup(func() {
+				db.Unscoped().Where("deployment_id = ?", deployment.ID).Delete(&deployments.DeploymentRevision{})
+				db.Unscoped().Delete(&deployment)
+			})
+
+			for i, status := range test.statuses {
+				revision := deployments.DeploymentRevision{DeploymentID: deployment.ID, UserID: user.ID, Status: deployments.DeploymentStatusRunning}
+				if err := service.CreateRevision(&revision); err != nil {
+					t.Fatalf("CreateRevision failed: %v", err)
+				}
+				if revision.Revision != i+1 {
+					t.Errorf("Expected revision %d, got %d", i+1, revision.Revision)
+				}
+				if err := service.SetRevisionStatus(revision.ID, status); err != nil {
+					t.Fatalf("SetRevisionStatus failed: %v", err)
+				}
+			}
+
+			result, err := service.GetRollbackRevision(deployment.ID, user.ID)
+			if test.expected == 0 {
+				if err == nil {
+					t.Errorf("Expected no revision to roll back to, got revision %d", result.Revision)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetRollbackRevision failed: %v", err)
+			}
+			if result.Revision != test.expected {
+				t.Errorf("Expected revision %d, got %d", test.expected, result.Revision)
+			}
+			if _, err := service.GetRollbackRevision(deployment.ID, user.ID+1); err == nil {
+				t.Error("Expected the revisions of another user to be hidden")
+			}
+		})
+	}
+}
+
+func TestExecuteService_RollbackDeploysSecretVersions(t *testing.T) {
+	suite := newExecuteTest(t)
+	serverId := suite.createServer(t, startExecServer(t, "hunter2", nil), "hunter2")
+	secret, err := suite.service.EnvsService.CreateSecret(suite.user.ID, secretsDto.CreateSecretDto{Name: "app", Content: "TOKEN=one"}, suite.user.IV)
+	if err != nil {
+		t.Fatalf("CreateSecret failed: %v", err)
+	}
+	deploymentId := suite.createDeployment(t, deploymentsDto.CreateDeploymentDto{
+		Name:               "app",
+		SetSecretsToServer: true,
+		RunScripts:         true,
+		ServerIDs:          []uint{serverId},
+		ScriptIDs:          []uint{suite.createScript(t, "print", `echo "token=$TOKEN"`, 0)},
+		SecretIDs:          []uint{secret.ID},
+	})
+
+	runId, err := suite.service.RunDeployment(deploymentId, suite.user.ID, suite.user.IV)
+	if err != nil {
+		t.Fatalf("RunDeployment failed: %v", err)
+	}
+	if result := suite.wait(t, runId); result.Status != runs.RunStatusSuccess || !strings.Contains(result.Stdout, "token=one\n") {
+		t.Fatalf("Expected the deployment to print the first token, got %s %q", result.Status, result.Stdout)
+	}
+	if _, err := suite.service.EnvsService.UpdateSecret(secret.ID, suite.user.ID, map[string]interface{}{"content": "TOKEN=two"}, suite.user.IV); err != nil {
+		t.Fatalf("UpdateSecret failed: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		currentSecrets bool
+		expected       string
+	}{
+		{name: "versions of the revision", expected: "token=one\n"},
+		{name: "current secrets", currentSecrets: true, expected: "token=two\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runId, rolledBackTo, err := suite.service.RollbackDeployment(deploymentId, suite.user.ID, suite.user.IV, 1, test.currentSecrets)
+			if err != nil {
+				t.Fatalf("RollbackDeployment failed: %v", err)
+			}
+			if rolledBackTo != 1 {
+				t.Errorf("Expected a rollback to revision 1, got %d", rolledBackTo)
+			}
+			if result := suite.wait(t, runId); result.Status != runs.RunStatusSuccess || !strings.Contains(result.Stdout, test.expected) {
+				t.Errorf("Expected the rollback to print %q, got %s %q", test.expected, result.Status, result.Stdout)
+			}
+		})
+	}
+
+	// A revision taken before secrets had versions, or whose version is gone, is not deployed with other values
+	revision, err := suite.service.DeploymentsService.GetRevision(deploymentId, suite.user.ID, 1)
+	if err != nil {
+		t.Fatalf("GetRevision failed: %v", err)
+	}
+	for _, version := range []int{0, 99} {
+		revision.Snapshot.Secrets[0].Version = version
+		if err := suite.db.Save(&revision).Error; err != nil {
+			t.Fatalf("Failed to update revision: %v", err)
+		}
+		if _, _, err := suite.service.RollbackDeployment(deploymentId, suite.user.ID, suite.user.IV, 1, false); !errors.Is(err, execute.ErrSecretVersionMissing) {
+			t.Errorf("Expected the rollback with secret version %d to be refused, got %v", version, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"deployer.com/libs"
+	deploymentsDto "deployer.com/modules/deployments/dto"
+	"deployer.com/modules/runs"
+)
+
+func TestRollingDeployment_Batches(t *testing.T) {
+	suite := newExecuteTest(t)
+	serverIds := fanOutServers(t, suite, 7, nil)
+
+	tests := []struct {
+		name         string
+		batchSize    int
+		batchPercent int
+		servers      int
+		expected     []int
+	}{
+		{name: "one at a time by default", servers: 3, expected: []int{1, 1, 1}},
+		{name: "batch size", batchSize: 2, servers: 5, expected: []int{2, 2, 1}},
+		{name: "batch size larger than servers", batchSize: 10, servers: 4, expected: []int{4}},
+		{name: "batch percent", batchPercent: 50, servers: 4, expected: []int{2, 2}},
+		{name: "batch percent rounds up", batchPercent: 30, servers: 5, expected: []int{2, 2, 1}},
+		{name: "small percent keeps one server", batchPercent: 1, servers: 3, expected: []int{1, 1, 1}},
+		{name: "whole fleet", batchPercent: 100, servers: 7, expected: []int{7}},
+		{name: "batch size wins over percent", batchSize: 1, batchPercent: 100, servers: 2, expected: []int{1, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Every server runs the script on this machine, it tells how many servers finished before it started
+			dir := t.TempDir()
+			script := fmt.Sprintf(`dir=%s
+echo "finished before: $(ls "$dir" | wc -l)"
+sleep 0.5
+touch "$dir/$$"`, libs.ShellQuote(dir))
+			deploymentId := suite.createDeployment(t, deploymentsDto.CreateDeploymentDto{
+				Name:         test.name,
+				RunScripts:   true,
+				Strategy:     "rolling",
+				BatchSize:    test.batchSize,
+				BatchPercent: test.batchPercent,
+				ServerIDs:    serverIds[:test.servers],
+				ScriptIDs:    []uint{suite.createScript(t, test.name, script, 0)},
+			})
+			runId, err := suite.service.RunDeployment(deploymentId, suite.user.ID, suite.user.IV)
+			if err != nil {
+				t.Fatalf("RunDeployment failed: %v", err)
+			}
+			result := suite.wait(t, runId)
+			if result.Status != runs.RunStatusSuccess {
+				t.Fatalf("Expected the deployment to succeed, got %s: %s", result.Status, result.Error)
+			}
+
+			// The servers of a batch start together once the batches before it finished
+			before := make([]int, 0, test.servers)
+			for _, step := range result.Steps {
+				if step.Name != "script:"+test.name {
+					continue
+				}
+				var count int
+				if _, err := fmt.Sscanf(step.Stdout, "finished before: %d", &count); err != nil {
+					t.Fatalf("Unexpected output %q: %v", step.Stdout, err)
+				}
+				before = append(before, count)
+			}
+			sort.Ints(before)
+			expected := make([]int, 0, test.servers)
+			finished := 0
+			for _, size := range test.expected {
+				for range size {
+					expected = append(expected, finished)
+				}
+				finished += size
+			}
+			if !reflect.DeepEqual(before, expected) {
+				t.Errorf("Expected batches of %v servers to see %v servers finished, got %v", test.expected, expected, before)
+			}
+		})
+	}
+}
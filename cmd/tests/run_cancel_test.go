@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/runs"
+)
+
+func TestSSHExecutor_CancelKillsCommand(t *testing.T) {
+	server := startExecServer(t, "hunter2", nil)
+	config := server.ExecutorConfig()
+	config.Password = "hunter2"
+	marker := filepath.Join(t.TempDir(), "finished")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+	begin := time.Now()
+	result, err := libs.NewSSHExecutor().Run(ctx, &config, "echo started; sleep 2; touch "+libs.ShellQuote(marker), func(stream, line string) {
+		if line == "started" {
+			close(started)
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if time.Since(begin) > 1500*time.Millisecond {
+		t.Errorf("Expected the run to return right after the cancel, it took %s", time.Since(begin))
+	}
+	if result == nil || result.Stdout != "started\n" || result.ExitCode != -1 {
+		t.Errorf("Expected the output so far and exit code -1, got %+v", result)
+	}
+	time.Sleep(3 * time.Second)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Expected the remote command to be killed")
+	}
+}
+
+// TestWorkerCommand_CancelKillsRemoteCommand terminates a worker command the way a canceled run does and
+// expects the remote command to be gone, the server keeps it running when only its client goes away
+func TestWorkerCommand_CancelKillsRemoteCommand(t *testing.T) {
+	for _, name := range []string{"bash", "ssh"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s is not available", name)
+		}
+	}
+	privateKey, _, publicKey := newPrivateKeys(t, "unused")
+	server := startExecServer(t, "", publicKey)
+	knownHosts := libs.KnownHostsLine(server.Host, server.Port, server.HostKey.PublicKey())
+	config := libs.SSHRunerConfig{
+		IP:         server.Host,
+		Port:       server.Port,
+		User:       "deploy",
+		SSHKey:     &privateKey,
+		KnownHosts: &knownHosts,
+		Script:     "echo $$; sleep 30",
+	}
+	command, err := libs.NewSSHRuner().CreateScriptRunner(&config)
+	if err != nil {
+		t.Fatalf("CreateScriptRunner failed: %v", err)
+	}
+
+	cmd := exec.Command("bash", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdin = bytes.NewReader(libs.WorkerInput("", privateKey, nil))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start the command: %v", err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("Expected the remote command to start: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("Expected the PID of the remote shell, got %q", line)
+	}
+
+	// Every process of the command gets TERM, like the worker kills a canceled exec
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	io.Copy(io.Discard, stdout)
+	cmd.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Running(pid) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the remote command to be killed")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestRunsService_CancelRun(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	service := runs.NewRunsService(db)
+
+	tests := []struct {
+		name    string
+		prepare func(run runs.Run)
+		userId  uint
+		wantErr error
+	}{
+		{name: "pending", prepare: func(run runs.Run) {}},
+		{name: "running", prepare: func(run runs.Run) { service.StartRun(run.ID) }},
+		{
+			name:    "finished",
+			prepare: func(run runs.Run) { service.FinishRun(run.ID, runs.RunStatusSuccess, nil, "") },
+			wantErr: runs.ErrRunNotActive,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run := createTestRun(t, service, user.ID, 0)
+			test.prepare(run)
+			ctx := service.Context(run.ID)
+			err := service.CancelRun(run.ID, user.ID)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("Expected %v, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CancelRun failed: %v", err)
+			}
+			if !errors.Is(ctx.Err(), context.Canceled) {
+				t.Errorf("Expected the context of the run to be canceled, got %v", ctx.Err())
+			}
+			service.FinishRun(run.ID, runs.RunStatusCanceled, nil, "")
+		})
+	}
+
+	run := createTestRun(t, service, user.ID, 0)
+	defer service.FinishRun(run.ID, runs.RunStatusCanceled, nil, "")
+	if err := service.CancelRun(run.ID, user.ID+1); err == nil {
+		t.Error("Expected the run of another user to be left alone")
+	}
+	if err := service.Context(run.ID).Err(); err != nil {
+		t.Errorf("Expected the run to keep going, got %v", err)
+	}
+}
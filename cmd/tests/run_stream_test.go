@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/runs"
+)
+
+func TestSSHExecutor_StreamsOutputLines(t *testing.T) {
+	server := startExecServer(t, "hunter2", nil)
+	config := server.ExecutorConfig()
+	config.Password = "hunter2"
+
+	tests := []struct {
+		name     string
+		command  string
+		expected []string
+	}{
+		{name: "lines", command: `printf 'one\ntwo\n'`, expected: []string{"stdout:one", "stdout:two"}},
+		{name: "unterminated last line", command: `printf 'one\ntwo'`, expected: []string{"stdout:one", "stdout:two"}},
+		{name: "carriage returns", command: `printf 'one\r\n'`, expected: []string{"stdout:one"}},
+		{name: "stderr", command: `echo out; echo err >&2`, expected: []string{"stdout:out", "stderr:err"}},
+		{name: "empty lines", command: `printf '\n\nx\n'`, expected: []string{"stdout:", "stdout:", "stdout:x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			lines := make([]string, 0)
+			result, err := libs.NewSSHExecutor().Run(context.Background(), &config, test.command, func(stream, line string) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				lines = append(lines, stream+":"+line)
+			})
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			// stdout and stderr are read concurrently, only the order within a stream is kept
+			for _, stream := range []string{libs.ExecStreamStdout, libs.ExecStreamStderr} {
+				got, expected := linesOf(lines, stream), linesOf(test.expected, stream)
+				if !reflect.DeepEqual(got, expected) {
+					t.Errorf("Expected %s lines %q, got %q", stream, expected, got)
+				}
+			}
+			if result.ExitCode != 0 {
+				t.Errorf("Expected exit code 0, got %d", result.ExitCode)
+			}
+		})
+	}
+}
+
+func linesOf(lines []string, stream string) []string {
+	result := make([]string, 0)
+	for _, line := range lines {
+		if len(line) > len(stream) && line[:len(stream)+1] == stream+":" {
+			result = append(result, line[len(stream)+1:])
+		}
+	}
+	return result
+}
+
+func TestRunsService_StreamsEventsToSubscribers(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	service := runs.NewRunsService(db)
+	run := createTestRun(t, service, user.ID, 0)
+	if err := service.StartRun(run.ID); err != nil {
+		t.Fatalf("StartRun failed: %v", err)
+	}
+	step, err := service.StartStep(run.ID, nil, "script")
+	if err != nil {
+		t.Fatalf("StartStep failed: %v", err)
+	}
+	service.PublishOutput(run.ID, step.ID, libs.ExecStreamStdout, "before subscribing")
+
+	history, events, unsubscribe, ok := service.Subscribe(run.ID)
+	if !ok {
+		t.Fatal("Expected the active run to be subscribable")
+	}
+	defer unsubscribe()
+	types := make([]string, len(history))
+	for i, event := range history {
+		types[i] = event.Type
+	}
+	expected := []string{runs.RunEventStatus, runs.RunEventStatus, runs.RunEventStep, runs.RunEventOutput}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("Expected the history %q, got %q", expected, types)
+	}
+
+	service.PublishOutput(run.ID, step.ID, libs.ExecStreamStdout, "after subscribing")
+	if err := service.FinishRun(run.ID, runs.RunStatusSuccess, nil, ""); err != nil {
+		t.Fatalf("FinishRun failed: %v", err)
+	}
+	received := make([]runs.RunEvent, 0)
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case event, open := <-events:
+			if !open {
+				done = true
+				break
+			}
+			received = append(received, event)
+		case <-timeout:
+			t.Fatal("Expected the channel to be closed once the run finished")
+		}
+	}
+	if len(received) != 2 || received[0].Line != "after subscribing" || received[1].Type != runs.RunEventDone || received[1].Status != runs.RunStatusSuccess {
+		t.Errorf("Expected the live line and the done event, got %+v", received)
+	}
+
+	if _, _, _, ok := service.Subscribe(run.ID); ok {
+		t.Error("Expected a finished run to have no live stream")
+	}
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"deployer.com/modules/execute/dto"
+	"deployer.com/modules/runs"
+)
+
+func TestRunScript_Timeout(t *testing.T) {
+	suite := newExecuteTest(t)
+	serverId := suite.createServer(t, startExecServer(t, "hunter2", nil), "hunter2")
+	override := func(seconds int) *int { return &seconds }
+	tests := []struct {
+		name       string
+		script     string
+		configured int
+		override   *int
+		expected   int
+		status     runs.RunStatus
+	}{
+		{name: "default", configured: 0, override: nil, expected: 3600},
+		{name: "configured", configured: 120, override: nil, expected: 120},
+		{name: "override", configured: 120, override: override(30), expected: 30},
+		{name: "override without configured", configured: 0, override: override(600), expected: 600},
+		{name: "zero override", configured: 120, override: override(0), expected: 120},
+		{name: "negative override", configured: 0, override: override(-5), expected: 3600},
+		{name: "expired", script: "sleep 30", configured: 120, override: override(1), expected: 1, status: runs.RunStatusTimedOut},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.script == "" {
+				test.script, test.status = "true", runs.RunStatusSuccess
+			}
+			scriptId := suite.createScript(t, test.name, test.script, test.configured)
+			runId, err := suite.service.RunScript(suite.user.ID, suite.user.IV, dto.RunScriptDto{ScriptID: scriptId, ServerID: serverId, Timeout: test.override})
+			if err != nil {
+				t.Fatalf("RunScript failed: %v", err)
+			}
+			result := suite.wait(t, runId)
+			run, err := suite.service.RunsService.GetRun(runId, suite.user.ID)
+			if err != nil {
+				t.Fatalf("GetRun failed: %v", err)
+			}
+			if run.Timeout != test.expected {
+				t.Errorf("Expected a timeout of %d seconds, got %d", test.expected, run.Timeout)
+			}
+			if result.Status != test.status {
+				t.Errorf("Expected the run to end as %s, got %s: %s", test.status, result.Status, result.Error)
+			}
+			if test.status == runs.RunStatusTimedOut && !strings.HasPrefix(result.Error, "run timed out: ") {
+				t.Errorf("Expected the error to tell the run timed out, got %q", result.Error)
+			}
+		})
+	}
+}
+
+func TestRunsService_TimeoutStartsWithRun(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	service := runs.NewRunsService(db)
+	run := createTestRun(t, service, user.ID, 1)
+	defer service.FinishRun(run.ID, runs.RunStatusTimedOut, nil, "")
+
+	// A run waiting to start keeps its whole timeout
+	time.Sleep(1500 * time.Millisecond)
+	if err := service.Context(run.ID).Err(); err != nil {
+		t.Fatalf("Expected the pending run to have no deadline yet, got %v", err)
+	}
+
+	startedAt := time.Now()
+	if err := service.StartRun(run.ID); err != nil {
+		t.Fatalf("StartRun failed: %v", err)
+	}
+	ctx := service.Context(run.ID)
+	deadline, ok := ctx.Deadline()
+	if !ok || deadline.Before(startedAt.Add(time.Second)) || deadline.After(time.Now().Add(time.Second)) {
+		t.Fatalf("Expected a deadline one second after the start, got %v (%v)", deadline, ok)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the run to time out")
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", ctx.Err())
+	}
+}
+
+func TestRunsService_CancelBeforeStart(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	service := runs.NewRunsService(db)
+	run := createTestRun(t, service, user.ID, 60)
+	defer service.FinishRun(run.ID, runs.RunStatusCanceled, nil, "")
+
+	if err := service.CancelRun(run.ID, user.ID); err != nil {
+		t.Fatalf("CancelRun failed: %v", err)
+	}
+	// The deadline armed at the start does not hide the cancellation
+	if err := service.StartRun(run.ID); err != nil {
+		t.Fatalf("StartRun failed: %v", err)
+	}
+	if err := service.Context(run.ID).Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the run to stay canceled, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"deployer.com/modules/runs"
+)
+
+func TestRunsService_WaitRunAggregatesServers(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	service := runs.NewRunsService(db)
+	run := createTestRun(t, service, user.ID, 0)
+	if err := service.StartRun(run.ID); err != nil {
+		t.Fatalf("StartRun failed: %v", err)
+	}
+
+	first, second := uint(1), uint(2)
+	zero, failed := 0, 2
+	steps := []struct {
+		serverId *uint
+		name     string
+		status   runs.RunStatus
+		stdout   string
+		exitCode *int
+		errMsg   string
+	}{
+		{serverId: &first, name: "upload_env", status: runs.RunStatusSuccess},
+		{serverId: &first, name: "script", status: runs.RunStatusSuccess, stdout: "first\n", exitCode: &zero},
+		{serverId: &second, name: "script", status: runs.RunStatusFailed, stdout: "second\n", exitCode: &failed, errMsg: "script: command exited with code 2"},
+		{serverId: &second, name: "cleanup", status: runs.RunStatusSkipped, stdout: "not part of the output\n", errMsg: "skipped"},
+	}
+	for _, step := range steps {
+		started, err := service.StartStep(run.ID, step.serverId, step.name)
+		if err != nil {
+			t.Fatalf("StartStep failed: %v", err)
+		}
+		if err := service.FinishStep(started, step.status, step.stdout, "", step.exitCode, step.errMsg); err != nil {
+			t.Fatalf("FinishStep failed: %v", err)
+		}
+	}
+
+	// A run that is still going is reported as not finished once the wait ends
+	result, finished, err := service.WaitRun(run.ID, user.ID, 50*time.Millisecond, "script")
+	if err != nil {
+		t.Fatalf("WaitRun failed: %v", err)
+	}
+	if finished || result.Status != runs.RunStatusRunning {
+		t.Errorf("Expected the run to still be running, got %s (finished=%v)", result.Status, finished)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		service.FinishRun(run.ID, runs.RunStatusFailed, &failed, "script failed")
+	}()
+	result, finished, err = service.WaitRun(run.ID, user.ID, 5*time.Second, "script")
+	if err != nil {
+		t.Fatalf("WaitRun failed: %v", err)
+	}
+	if !finished || result.Status != runs.RunStatusFailed || result.ExitCode == nil || *result.ExitCode != 2 {
+		t.Fatalf("Expected the run to be failed with exit code 2, got %+v", result)
+	}
+	if result.Stdout != "first\nsecond\n" {
+		t.Errorf("Expected the output of the script steps, got %q", result.Stdout)
+	}
+	if result.DurationMs <= 0 {
+		t.Errorf("Expected the duration of the run, got %d", result.DurationMs)
+	}
+
+	expected := []runs.RunServerResult{
+		{ServerID: first, Status: runs.RunStatusSuccess, ExitCode: &zero, Stdout: "first\n"},
+		{ServerID: second, Status: runs.RunStatusFailed, ExitCode: &failed, Stdout: "second\n", Error: "script: command exited with code 2"},
+	}
+	if len(result.Servers) != len(expected) {
+		t.Fatalf("Expected %d servers, got %+v", len(expected), result.Servers)
+	}
+	for i, server := range expected {
+		got := result.Servers[i]
+		if got.ServerID != server.ServerID || got.Status != server.Status || got.Stdout != server.Stdout || got.Error != server.Error ||
+			got.ExitCode == nil || *got.ExitCode != *server.ExitCode {
+			t.Errorf("Expected server %+v, got %+v", server, got)
+		}
+	}
+
+	// Without step names every step is part of the output
+	result, _, err = service.WaitRun(run.ID, user.ID, time.Second)
+	if err != nil {
+		t.Fatalf("WaitRun failed: %v", err)
+	}
+	if result.Stdout != "first\nsecond\nnot part of the output\n" {
+		t.Errorf("Expected the output of every step, got %q", result.Stdout)
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"deployer.com/modules/runs"
+	"deployer.com/modules/users"
+	"gorm.io/gorm"
+)
+
+// testDB opens the database of DATABASE_URL and skips the test when it is not set
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := setupTestDB()
+	if err != nil {
+		t.Skipf("Skipping database test: %v", err)
+	}
+	return db
+}
+
+// createTestUser stores a user removed again together with its runs once the test finished
+func createTestUser(t *testing.T, db *gorm.DB) users.User {
+	t.Helper()
+	suffix := time.Now().UnixNano()
+	user := users.User{
+		FirstName:    "Test",
+		LastName:     fmt.Sprint(suffix),
+		Username:     fmt.Sprintf("test%d", suffix),
+		Email:        fmt.Sprintf("test%d@example.com", suffix),
+		PasswordHash: "-",
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Where("run_id IN (?)", db.Model(&runs.Run{}).Select("id").Where("user_id = ?", user.ID)).Delete(&runs.RunStep{})
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&runs.Run{})
+		db.Unscoped().Delete(&user)
+	})
+	return user
+}
+
+func createTestRun(t *testing.T, service *runs.RunsService, userId uint, timeout int) runs.Run {
+	t.Helper()
+	run := runs.Run{Type: runs.RunTypeScript, UserID: userId, Timeout: timeout}
+	if err := service.CreateRun(&run); err != nil {
+		t.Fatalf("CreateRun failed: %v", err)
+	}
+	return run
+}
+
+func TestRunsService_RecordsStepsInOrder(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	service := runs.NewRunsService(db)
+	run := createTestRun(t, service, user.ID, 0)
+	if err := service.StartRun(run.ID); err != nil {
+		t.Fatalf("StartRun failed: %v", err)
+	}
+
+	serverId := uint(1)
+	exitCode := 3
+	steps := []struct {
+		name     string
+		status   runs.RunStatus
+		stdout   string
+		exitCode *int
+		errMsg   string
+	}{
+		{name: "upload_env", status: runs.RunStatusSuccess},
+		{name: "script", status: runs.RunStatusFailed, stdout: "partial output\n", exitCode: &exitCode, errMsg: "script: command exited with code 3"},
+		{name: "health_check", status: runs.RunStatusSkipped, errMsg: "skipped"},
+	}
+	for _, step := range steps {
+		started, err := service.StartStep(run.ID, &serverId, step.name)
+		if err != nil {
+			t.Fatalf("StartStep failed: %v", err)
+		}
+		if err := service.FinishStep(started, step.status, step.stdout, "", step.exitCode, step.errMsg); err != nil {
+			t.Fatalf("FinishStep failed: %v", err)
+		}
+	}
+	if err := service.FinishRun(run.ID, runs.RunStatusFailed, &exitCode, "script failed"); err != nil {
+		t.Fatalf("FinishRun failed: %v", err)
+	}
+
+	result, err := service.GetRun(run.ID, user.ID)
+	if err != nil {
+		t.Fatalf("GetRun failed: %v", err)
+	}
+	if result.Status != runs.RunStatusFailed || result.ExitCode == nil || *result.ExitCode != 3 {
+		t.Errorf("Expected the run to be failed with exit code 3, got %s %v", result.Status, result.ExitCode)
+	}
+	if result.StartedAt == nil || result.FinishedAt == nil {
+		t.Error("Expected the start and end of the run to be recorded")
+	}
+	if len(result.Steps) != len(steps) {
+		t.Fatalf("Expected %d steps, got %d", len(steps), len(result.Steps))
+	}
+	for i, step := range steps {
+		got := result.Steps[i]
+		if got.Name != step.name || got.Order != i+1 || got.Status != step.status || got.Stdout != step.stdout || got.Error != step.errMsg {
+			t.Errorf("Expected step %d to be %+v, got %+v", i+1, step, got)
+		}
+	}
+
+	logs, err := service.GetRunLogs(run.ID)
+	if err != nil {
+		t.Fatalf("GetRunLogs failed: %v", err)
+	}
+	expected := "==> upload_env [success]\n==> script [failed]\npartial output\nerror: script: command exited with code 3\n==> health_check [skipped]\nerror: skipped\n"
+	if logs != expected {
+		t.Errorf("Expected logs %q, got %q", expected, logs)
+	}
+
+	if _, err := service.GetRun(run.ID, user.ID+1); err == nil {
+		t.Error("Expected the run of another user to be hidden")
+	}
+}
@@ -0,0 +1,217 @@
+package tests
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"deployer.com/libs"
+	"deployer.com/modules/secrets"
+	secretsDto "deployer.com/modules/secrets/dto"
+	"gorm.io/gorm"
+)
+
+func TestSecretsService_DiffSecretVersions(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	t.Setenv("ENCRYPTION_KEY", strings.Repeat("ab", 32))
+	service := secrets.NewSecretsService(db)
+
+	masked := "********"
+	tests := []struct {
+		name    string
+		before  string
+		after   string
+		added   []secrets.SecretDiffEntry
+		removed []secrets.SecretDiffEntry
+		changed []secrets.SecretDiffEntry
+	}{
+		{
+			// The first version is compared with the empty secret
+			name:  "first version",
+			after: "TOKEN=abc\nDB_URL=postgres://",
+			added: []secrets.SecretDiffEntry{{Key: "DB_URL", NewValue: masked}, {Key: "TOKEN", NewValue: masked}},
+		},
+		{
+			name:    "removed keys",
+			before:  "B=2\nA=1\nC=3",
+			after:   "B=2",
+			removed: []secrets.SecretDiffEntry{{Key: "A", OldValue: masked}, {Key: "C", OldValue: masked}},
+		},
+		{
+			name:    "changed values",
+			before:  "TOKEN=abc\nURL=x",
+			after:   "TOKEN=abd\nURL=x",
+			changed: []secrets.SecretDiffEntry{{Key: "TOKEN", OldValue: masked, NewValue: masked}},
+		},
+		{
+			// Only whether a value is set shows through the mask
+			name:    "empty values",
+			before:  "TOKEN=\nURL=x",
+			after:   "TOKEN=abc\nURL=\nEMPTY=",
+			added:   []secrets.SecretDiffEntry{{Key: "EMPTY"}},
+			changed: []secrets.SecretDiffEntry{{Key: "TOKEN", NewValue: masked}, {Key: "URL", OldValue: masked}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first := test.before
+			if first == "" {
+				first = test.after
+			}
+			secret, err := service.CreateSecret(user.ID, secretsDto.CreateSecretDto{Name: test.name, Content: first}, "")
+			if err != nil {
+				t.Fatalf("CreateSecret failed: %v", err)
+			}
+			t.Cleanup(func() {
+				db.Unscoped().Where("secret_id = ?", secret.ID).Delete(&secrets.SecretVersion{})
+				db.Unscoped().Where("id = ?", secret.ID).Delete(&secrets.Secret{})
+			})
+			to := 1
+			if test.before != "" {
+				if _, err := service.UpdateSecret(secret.ID, user.ID, map[string]interface{}{"content": test.after}, ""); err != nil {
+					t.Fatalf("UpdateSecret failed: %v", err)
+				}
+				to = 2
+			}
+
+			// Without versions the current version is compared with the one before it
+			result, err := service.DiffSecretVersions(secret.ID, user.ID, 0, 0, "")
+			if err != nil {
+				t.Fatalf("DiffSecretVersions failed: %v", err)
+			}
+			if result.From != to-1 || result.To != to {
+				t.Errorf("Expected the diff from %d to %d, got %d to %d", to-1, to, result.From, result.To)
+			}
+			for _, diff := range []struct {
+				kind     string
+				got      []secrets.SecretDiffEntry
+				expected []secrets.SecretDiffEntry
+			}{
+				{"added", result.Added, test.added},
+				{"removed", result.Removed, test.removed},
+				{"changed", result.Changed, test.changed},
+			} {
+				if diff.expected == nil {
+					diff.expected = []secrets.SecretDiffEntry{}
+				}
+				if !reflect.DeepEqual(diff.got, diff.expected) {
+					t.Errorf("Expected %s %+v, got %+v", diff.kind, diff.expected, diff.got)
+				}
+			}
+
+			same, err := service.DiffSecretVersions(secret.ID, user.ID, to, to, "")
+			if err != nil {
+				t.Fatalf("DiffSecretVersions failed: %v", err)
+			}
+			if len(same.Added)+len(same.Removed)+len(same.Changed) != 0 {
+				t.Errorf("Expected a version to equal itself, got %+v", same)
+			}
+		})
+	}
+}
+
+func TestSecretsService_RestoreCurrentVersion(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	service := secrets.NewSecretsService(db)
+
+	secret := secrets.Secret{Name: "restore", Content: "encrypted", UserID: user.ID, Version: 2, Mode: secrets.SecretModeRaw}
+	if err := db.Create(&secret).Error; err != nil {
+		t.Fatalf("Failed to create secret: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Where("secret_id = ?", secret.ID).Delete(&secrets.SecretVersion{})
+		db.Unscoped().Delete(&secret)
+	})
+	for _, version := range []int{1, 2} {
+		if err := db.Create(&secrets.SecretVersion{SecretID: secret.ID, Version: version, Content: "encrypted", Mode: secrets.SecretModeRaw, AuthorID: user.ID}).Error; err != nil {
+			t.Fatalf("Failed to create secret version: %v", err)
+		}
+	}
+
+	if _, err := service.RestoreSecretVersion(secret.ID, user.ID, 2, ""); !errors.Is(err, secrets.ErrVersionIsCurrent) {
+		t.Errorf("Expected ErrVersionIsCurrent, got %v", err)
+	}
+	if _, err := service.RestoreSecretVersion(secret.ID, user.ID, 3, ""); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Expected a missing version to be not found, got %v", err)
+	}
+	if _, err := service.RestoreSecretVersion(secret.ID, user.ID+1, 1, ""); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Expected the secret of another user to be not found, got %v", err)
+	}
+
+	var stored secrets.Secret
+	if err := db.First(&stored, secret.ID).Error; err != nil {
+		t.Fatalf("Failed to reload secret: %v", err)
+	}
+	var count int64
+	db.Model(&secrets.SecretVersion{}).Where("secret_id = ?", secret.ID).Count(&count)
+	if stored.Version != 2 || count != 2 {
+		t.Errorf("Expected a rejected restore to leave the secret at version 2 with 2 versions, got %d with %d", stored.Version, count)
+	}
+}
+
+func TestSecretsService_VersionsSecretsWithoutVersions(t *testing.T) {
+	db := testDB(t)
+	user := createTestUser(t, db)
+	t.Setenv("ENCRYPTION_KEY", strings.Repeat("ab", 32))
+	service := secrets.NewSecretsService(db)
+
+	tests := []struct {
+		name   string
+		update func(secret secrets.Secret) error
+	}{
+		{
+			name: "update",
+			update: func(secret secrets.Secret) error {
+				_, err := service.UpdateSecret(secret.ID, user.ID, map[string]interface{}{"content": "TOKEN=new"}, "")
+				return err
+			},
+		},
+		{
+			name: "set key",
+			update: func(secret secrets.Secret) error {
+				value := "new"
+				_, err := service.SetSecretKey(secret.ID, user.ID, "TOKEN", secretsDto.SetSecretKeyDto{Value: &value}, "")
+				return err
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A secret as AutoMigrate leaves it, at version 1 without any version rows
+			content, err := libs.NewEncryptionService().Encrypt("TOKEN=old", "")
+			if err != nil {
+				t.Fatalf("Encrypt failed: %v", err)
+			}
+			secret := secrets.Secret{Name: test.name, Content: content, UserID: user.ID, Version: 1, Mode: secrets.SecretModeRaw}
+			if err := db.Create(&secret).Error; err != nil {
+				t.Fatalf("Failed to create secret: %v", err)
+			}
+			t.Cleanup(func() {
+				db.Unscoped().Where("secret_id = ?", secret.ID).Delete(&secrets.SecretVersion{})
+				db.Unscoped().Delete(&secret)
+			})
+
+			if err := test.update(secret); err != nil {
+				t.Fatalf("Updating the secret failed: %v", err)
+			}
+			original, err := service.GetSecretVersion(secret.ID, user.ID, 1, "")
+			if err != nil {
+				t.Fatalf("Expected the content before the edit to be kept as version 1: %v", err)
+			}
+			if original.Content != "TOKEN=old" || original.Current {
+				t.Errorf("Expected version 1 to hold the old content, got %q current=%v", original.Content, original.Current)
+			}
+
+			restored, err := service.RestoreSecretVersion(secret.ID, user.ID, 1, "")
+			if err != nil {
+				t.Fatalf("RestoreSecretVersion failed: %v", err)
+			}
+			if restored.Version != 3 || service.GetEnvMap(restored)["TOKEN"] != "old" {
+				t.Errorf("Expected version 3 to restore the old content, got version %d with %q", restored.Version, restored.Content)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"deployer.com/modules/servers"
+)
+
+// factsPath returns a PATH holding only the tools the facts script needs from this machine and the stubs,
+// the probes of every other tool find nothing
+func factsPath(t *testing.T, stubs map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, tool := range []string{"sh", "awk"} {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			t.Skipf("%s is not available", tool)
+		}
+		if err := os.Symlink(path, filepath.Join(dir, tool)); err != nil {
+			t.Fatalf("Failed to link %s: %v", tool, err)
+		}
+	}
+	for name, script := range stubs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatalf("Failed to write stub %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// localFacts returns the facts the script reads from files of this machine instead of tools
+func localFacts(t *testing.T) (string, int64) {
+	t.Helper()
+	release, _ := exec.Command("sh", "-c", `. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME"`).Output()
+	osRelease := strings.TrimSpace(string(release))
+	if osRelease == "" {
+		osRelease = "Linux"
+	}
+	var memoryMB int64
+	meminfo, _ := os.ReadFile("/proc/meminfo")
+	for _, line := range strings.Split(string(meminfo), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "MemTotal:" {
+			memory, _ := strconv.ParseInt(fields[1], 10, 64)
+			memoryMB = memory / 1024
+		}
+	}
+	return osRelease, memoryMB
+}
+
+func TestServersService_TestServer(t *testing.T) {
+	suite := newExecuteTest(t)
+	server := startExecServer(t, "hunter2", nil)
+	osRelease, memoryMB := localFacts(t)
+
+	uname := `case "$1" in -r) echo 6.8.0-45-generic ;; *) echo Linux ;; esac`
+	df := `echo "Filesystem 1024-blocks Used Available Capacity Mounted on"
+echo "/dev/sda1 81057760 28717548 52340212 36% /"`
+	tests := []struct {
+		name     string
+		stubs    map[string]string
+		password string
+		closed   bool
+		expected servers.ServerFacts
+		failed   bool
+	}{
+		{
+			name: "complete",
+			stubs: map[string]string{
+				"uname":  uname,
+				"df":     df,
+				"nproc":  "echo 4",
+				"docker": `case "$1" in version) echo 27.3.1 ;; info) exit 0 ;; *) exit 1 ;; esac`,
+			},
+			expected: servers.ServerFacts{
+				Reachable:     true,
+				Authenticated: true,
+				OSRelease:     osRelease,
+				Kernel:        "6.8.0-45-generic",
+				CPUCount:      4,
+				MemoryMB:      memoryMB,
+				DiskTotalMB:   79157,
+				DiskFreeMB:    51113,
+				DockerVersion: "27.3.1",
+				DockerAccess:  true,
+			},
+		},
+		{
+			// Probes of missing tools print empty values
+			name:     "missing tools",
+			stubs:    map[string]string{"uname": uname},
+			expected: servers.ServerFacts{Reachable: true, Authenticated: true, OSRelease: osRelease, Kernel: "6.8.0-45-generic", MemoryMB: memoryMB},
+		},
+		{
+			name: "docker client version",
+			stubs: map[string]string{
+				"uname":  uname,
+				"docker": `[ "$1" = --version ] && echo "Docker version 24.0.7, build afdd53b" || exit 1`,
+			},
+			expected: servers.ServerFacts{Reachable: true, Authenticated: true, OSRelease: osRelease, Kernel: "6.8.0-45-generic", MemoryMB: memoryMB, DockerVersion: "Docker version 24.0.7, build afdd53b"},
+		},
+		{
+			name:     "wrong password",
+			password: "wrong",
+			expected: servers.ServerFacts{Reachable: true},
+			failed:   true,
+		},
+		{
+			name:     "unreachable",
+			closed:   true,
+			expected: servers.ServerFacts{},
+			failed:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PATH", factsPath(t, test.stubs))
+			target := server
+			if test.closed {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("Failed to listen: %v", err)
+				}
+				listener.Close()
+				target = &execServer{Host: server.Host, Port: listener.Addr().(*net.TCPAddr).Port}
+			}
+			password := test.password
+			if password == "" {
+				password = "hunter2"
+			}
+			serverId := suite.createServer(t, target, password)
+
+			facts, err := suite.service.ServersService.TestServer(serverId, suite.user.ID, suite.user.IV)
+			if err != nil {
+				t.Fatalf("TestServer failed: %v", err)
+			}
+			if facts.LastCheckedAt == nil || (facts.LastCheckError != "") != test.failed {
+				t.Errorf("Expected a check at a time with failed=%v, got %+v", test.failed, facts)
+			}
+			stored, err := suite.service.ServersService.GetServer(serverId, suite.user.ID, suite.user.IV)
+			if err != nil {
+				t.Fatalf("GetServer failed: %v", err)
+			}
+			if stored.Facts.LastCheckError != facts.LastCheckError || stored.Facts.LastCheckedAt == nil {
+				t.Errorf("Expected the facts to be stored, got %+v", stored.Facts)
+			}
+			for _, got := range []*servers.ServerFacts{&facts, &stored.Facts} {
+				got.LastCheckError, got.LastCheckedAt = "", nil
+				if !reflect.DeepEqual(*got, test.expected) {
+					t.Errorf("Expected %+v, got %+v", test.expected, *got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"deployer.com/libs"
+)
+
+func TestSSHExecutor_RunWithPassword(t *testing.T) {
+	server := startExecServer(t, "pa ss'word$", nil)
+	executor := libs.NewSSHExecutor()
+
+	tests := []struct {
+		name     string
+		config   func(config *libs.SSHExecutorConfig)
+		command  string
+		input    []byte
+		stdout   string
+		stderr   string
+		exitCode int
+		wantErr  bool
+	}{
+		{name: "output", command: "echo hello; echo oops >&2", stdout: "hello\n", stderr: "oops\n"},
+		{name: "exit code", command: "echo partial; exit 3", stdout: "partial\n", exitCode: 3},
+		{name: "stdin", command: "cat", input: []byte("from stdin\x00binary"), stdout: "from stdin\x00binary"},
+		{name: "quoted arguments", command: `printf '%s|' 'it'"'"'s' "$((1+2))"`, stdout: "it's|3|"},
+		{
+			name:    "wrong password",
+			config:  func(config *libs.SSHExecutorConfig) { config.Password = "wrong" },
+			command: "true",
+			wantErr: true,
+		},
+		{
+			name:    "no credentials",
+			config:  func(config *libs.SSHExecutorConfig) { config.Password = "" },
+			command: "true",
+			wantErr: true,
+		},
+		{
+			name:    "host key not pinned",
+			config:  func(config *libs.SSHExecutorConfig) { config.HostKeyFingerprint = "" },
+			command: "true",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := server.ExecutorConfig()
+			config.Password = "pa ss'word$"
+			if test.config != nil {
+				test.config(&config)
+			}
+			result, err := executor.RunWithInput(context.Background(), &config, test.command, test.input, nil)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RunWithInput failed: %v", err)
+			}
+			if result.Stdout != test.stdout || result.Stderr != test.stderr || result.ExitCode != test.exitCode {
+				t.Errorf("Expected stdout %q, stderr %q and exit code %d, got %q, %q and %d",
+					test.stdout, test.stderr, test.exitCode, result.Stdout, result.Stderr, result.ExitCode)
+			}
+		})
+	}
+}
+
+func TestSSHExecutor_ReportsHostKeyMismatch(t *testing.T) {
+	server := startExecServer(t, "hunter2", nil)
+	_, otherKey := newHostSigners(t)
+	config := server.ExecutorConfig()
+	config.Password = "hunter2"
+	config.HostKeyFingerprint = libs.HostKeyFingerprint(otherKey.PublicKey())
+
+	_, err := libs.NewSSHExecutor().Run(context.Background(), &config, "true", nil)
+	var mismatch *libs.HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected a HostKeyMismatchError, got %v", err)
+	}
+	if mismatch.Actual != libs.HostKeyFingerprint(server.HostKey.PublicKey()) {
+		t.Errorf("Expected the presented key %s in the error, got %s", libs.HostKeyFingerprint(server.HostKey.PublicKey()), mismatch.Actual)
+	}
+}
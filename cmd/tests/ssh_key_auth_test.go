@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"deployer.com/libs"
+	"golang.org/x/crypto/ssh"
+)
+
+// newPrivateKeys returns an OpenSSH private key without and with a passphrase and its public key
+func newPrivateKeys(t *testing.T, passphrase string) (string, string, ssh.PublicKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	plain, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	protected, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+	return string(pem.EncodeToMemory(plain)), string(pem.EncodeToMemory(protected)), publicKey
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	plain, protected, publicKey := newPrivateKeys(t, "correct horse")
+	tests := []struct {
+		name       string
+		key        string
+		passphrase string
+		errPart    string
+	}{
+		{name: "plain", key: plain},
+		{name: "plain with passphrase", key: plain, passphrase: "unused", errPart: "failed to parse ssh key"},
+		{name: "protected", key: protected, passphrase: "correct horse"},
+		{name: "protected without passphrase", key: protected, errPart: "set ssh_key_passphrase"},
+		{name: "wrong passphrase", key: protected, passphrase: "wrong", errPart: "failed to parse ssh key"},
+		{name: "garbage", key: "not a key", errPart: "failed to parse ssh key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signer, err := libs.ParsePrivateKey(test.key, test.passphrase)
+			if test.errPart != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errPart) {
+					t.Errorf("Expected an error containing %q, got %v", test.errPart, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePrivateKey failed: %v", err)
+			}
+			if libs.HostKeyFingerprint(signer.PublicKey()) != libs.HostKeyFingerprint(publicKey) {
+				t.Error("Expected the signer of the generated key")
+			}
+		})
+	}
+}
+
+func TestUnencryptedPrivateKey(t *testing.T) {
+	plain, protected, publicKey := newPrivateKeys(t, "correct horse")
+	for _, test := range []struct{ key, passphrase string }{{plain, ""}, {protected, "correct horse"}} {
+		unencrypted, err := libs.UnencryptedPrivateKey(test.key, test.passphrase)
+		if err != nil {
+			t.Fatalf("UnencryptedPrivateKey failed: %v", err)
+		}
+		signer, err := libs.ParsePrivateKey(unencrypted, "")
+		if err != nil {
+			t.Fatalf("Expected a key usable without passphrase, got %v", err)
+		}
+		if libs.HostKeyFingerprint(signer.PublicKey()) != libs.HostKeyFingerprint(publicKey) {
+			t.Error("Expected the same key without its passphrase")
+		}
+	}
+	if _, err := libs.UnencryptedPrivateKey(protected, "wrong"); err == nil {
+		t.Error("Expected a wrong passphrase to be rejected")
+	}
+}
+
+func TestSSHExecutor_RunWithKey(t *testing.T) {
+	plain, protected, publicKey := newPrivateKeys(t, "correct horse")
+	otherKey, _, _ := newPrivateKeys(t, "unused")
+	server := startExecServer(t, "hunter2", publicKey)
+
+	tests := []struct {
+		name       string
+		key        string
+		passphrase string
+		password   string
+		wantErr    bool
+	}{
+		{name: "key", key: plain},
+		{name: "protected key", key: protected, passphrase: "correct horse"},
+		{name: "unauthorized key", key: otherKey, wantErr: true},
+		// The password is tried once the key was refused
+		{name: "unauthorized key with password", key: otherKey, password: "hunter2"},
+		{name: "protected key without passphrase", key: protected, password: "hunter2", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := server.ExecutorConfig()
+			config.PrivateKey = test.key
+			config.PrivateKeyPassphrase = test.passphrase
+			config.Password = test.password
+			result, err := libs.NewSSHExecutor().Run(context.Background(), &config, "echo ok", nil)
+			if test.wantErr {
+				if err == nil {
+					t.Error("Expected the login to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if result.Stdout != "ok\n" {
+				t.Errorf("Expected ok, got %q", result.Stdout)
+			}
+		})
+	}
+}
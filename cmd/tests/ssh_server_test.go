@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"deployer.com/libs"
+	"golang.org/x/crypto/ssh"
+)
+
+// execServer is an in-process ssh server running the commands of exec requests with the local sh. Like
+// sshd without a tty, a command keeps running when its client goes away, only a signal request kills it
+type execServer struct {
+	Host    string
+	Port    int
+	HostKey ssh.Signer
+
+	mutex   sync.Mutex
+	running map[int]bool
+}
+
+// ExecutorConfig returns an executor config for the server with the host key pinned
+func (s *execServer) ExecutorConfig() libs.SSHExecutorConfig {
+	return libs.SSHExecutorConfig{
+		Host:               s.Host,
+		Port:               s.Port,
+		User:               "deploy",
+		HostKeyFingerprint: libs.HostKeyFingerprint(s.HostKey.PublicKey()),
+	}
+}
+
+// startExecServer accepts password for the password logins and authorizedKey for key logins, either may be empty.
+// The server presents an ed25519 host key and extraHostKeys
+func startExecServer(t *testing.T, password string, authorizedKey ssh.PublicKey, extraHostKeys ...ssh.Signer) *execServer {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	hostKey, _ := newHostSigners(t)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, given []byte) (*ssh.Permissions, error) {
+			if password != "" && string(given) == password {
+				return nil, nil
+			}
+			return nil, errAuthRejected
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorizedKey != nil && bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errAuthRejected
+		},
+	}
+	config.AddHostKey(hostKey)
+	for _, signer := range extraHostKeys {
+		config.AddHostKey(signer)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	address := listener.Addr().(*net.TCPAddr)
+	server := &execServer{Host: address.IP.String(), Port: address.Port, HostKey: hostKey, running: make(map[int]bool)}
+	t.Cleanup(func() {
+		listener.Close()
+		// Commands left behind by their clients do not outlive the test
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		for pid := range server.running {
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serveConn(conn, config)
+		}
+	}()
+	return server
+}
+
+// Running tells whether the process group pid started for a command still has a process in it
+func (s *execServer) Running(pid int) bool {
+	return syscall.Kill(-pid, 0) == nil
+}
+
+var errAuthRejected = errors.New("authentication rejected")
+
+func (s *execServer) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveSession(channel, requests)
+	}
+}
+
+func (s *execServer) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	var cmd *exec.Cmd
+	done := make(chan struct{})
+	for request := range requests {
+		switch request.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if cmd != nil || ssh.Unmarshal(request.Payload, &payload) != nil {
+				request.Reply(false, nil)
+				continue
+			}
+			cmd = exec.Command("sh", "-c", payload.Command)
+			// A process group lets a signal reach everything the command started
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			cmd.WaitDelay = time.Second
+			stdin, err := cmd.StdinPipe()
+			if err != nil || cmd.Start() != nil {
+				request.Reply(false, nil)
+				return
+			}
+			request.Reply(true, nil)
+			s.mutex.Lock()
+			s.running[cmd.Process.Pid] = true
+			s.mutex.Unlock()
+			go func() {
+				io.Copy(stdin, channel)
+				stdin.Close()
+			}()
+			go func(cmd *exec.Cmd) {
+				cmd.Wait()
+				s.mutex.Lock()
+				delete(s.running, cmd.Process.Pid)
+				s.mutex.Unlock()
+				status := struct{ Status uint32 }{uint32(cmd.ProcessState.ExitCode())}
+				channel.SendRequest("exit-status", false, ssh.Marshal(&status))
+				channel.Close()
+				close(done)
+			}(cmd)
+		case "signal":
+			if cmd != nil && cmd.Process != nil {
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			}
+			if request.WantReply {
+				request.Reply(true, nil)
+			}
+		default:
+			if request.WantReply {
+				request.Reply(false, nil)
+			}
+		}
+	}
+	// The client went away, like sshd the command is left running and only loses its output
+	if cmd != nil {
+		<-done
+	}
+}
@@ -2,6 +2,7 @@ package libs
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 )
 
 type Container struct {
@@ -31,6 +33,12 @@ type ContainerStats struct {
 	NetworkTx     uint64
 }
 
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
 type DockerComunication struct {
 	client                     *client.Client
 	activeContainers           []Container
@@ -347,6 +355,120 @@ func (dc *DockerComunication) ExecuteCommand(ctx context.Context, containerID st
 	return string(output), nil
 }
 
+// ExecuteCommandWithResult выполняет команду в контейнере и возвращает stdout, stderr и код завершения
+func (dc *DockerComunication) ExecuteCommandWithResult(ctx context.Context, containerID string, cmd string) (*ExecResult, error) {
+	return dc.ExecuteCommandStream(ctx, containerID, cmd, nil)
+}
+
+// execWrapper запоминает PID оболочки в файле $1, чтобы команду $2 можно было прервать из другого exec
+const execWrapper = `echo $$ > "$1"; bash -c "$2"; code=$?; rm -f "$1"; exit $code`
+
+// execKillScript завершает все процессы команды, начиная с самых глубоких; TERM дает bash выполнить trap
+const execKillScript = `[ -f "$1" ] || exit 0
+tree() { for child in $(pgrep -P "$1"); do tree "$child"; done; echo "$1"; }
+pids=$(tree "$(cat "$1")")
+kill -TERM $pids 2>/dev/null
+sleep 2
+kill -KILL $pids 2>/dev/null
+rm -f "$1"`
+
+// ExecuteCommandStream выполняет команду в контейнере и передает каждую строку вывода в onLine по мере появления.
+// При отмене ctx процессы команды в контейнере завершаются, а уже полученный вывод возвращается вместе с ctx.Err()
+func (dc *DockerComunication) ExecuteCommandStream(ctx context.Context, containerID string, cmd string, onLine ExecOutputHandler) (*ExecResult, error) {
+	return dc.ExecuteCommandStreamWithInput(ctx, containerID, cmd, nil, onLine)
+}
+
+// ExecuteCommandStreamWithInput работает как ExecuteCommandStream и передает input в stdin команды,
+// так данные не попадают в аргументы процессов
+func (dc *DockerComunication) ExecuteCommandStreamWithInput(ctx context.Context, containerID string, cmd string, input []byte, onLine ExecOutputHandler) (*ExecResult, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to create exec token: %w", err)
+	}
+	pidFile := fmt.Sprintf("/tmp/deployer-exec-%x.pid", token)
+
+	execConfig := container.ExecOptions{
+		Cmd:          []string{"bash", "-c", execWrapper, "deployer-exec", pidFile, cmd},
+		AttachStdin:  input != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+
+	execResp, err := dc.client.ContainerExecCreate(ctx, containerID, execConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attachResp, err := dc.client.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer attachResp.Close()
+
+	if input != nil {
+		// stdin закрывается после записи, чтобы команда получила EOF
+		go func() {
+			if _, err := attachResp.Conn.Write(input); err != nil {
+				fmt.Printf("ERROR: Failed to write exec input in %s: %v\n", containerID, err)
+			}
+			attachResp.CloseWrite()
+		}()
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			dc.killExec(containerID, pidFile)
+			attachResp.Close()
+		case <-finished:
+		}
+	}()
+
+	// Без TTY Docker мультиплексирует stdout и stderr в один поток кадрами с заголовком
+	stdout := newExecLineWriter(ExecStreamStdout, onLine)
+	stderr := newExecLineWriter(ExecStreamStderr, onLine)
+	_, err = stdcopy.StdCopy(stdout, stderr, attachResp.Reader)
+	stdout.Flush()
+	stderr.Flush()
+	if ctx.Err() != nil {
+		return &ExecResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: -1}, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	inspect, err := dc.client.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+
+	return &ExecResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: inspect.ExitCode,
+	}, nil
+}
+
+// killExec завершает команду, запущенную через ExecuteCommandStream; ssh при этом разрывает соединение с сервером,
+// а команда, собранная SSHRuner, по TERM подключается еще раз и завершает свои процессы на сервере
+func (dc *DockerComunication) killExec(containerID string, pidFile string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	execResp, err := dc.client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd: []string{"bash", "-c", execKillScript, "deployer-kill", pidFile},
+	})
+	if err != nil {
+		fmt.Printf("ERROR: Failed to create kill exec in %s: %v\n", containerID, err)
+		return
+	}
+	if err := dc.client.ContainerExecStart(ctx, execResp.ID, container.ExecStartOptions{Detach: true}); err != nil {
+		fmt.Printf("ERROR: Failed to kill exec in %s: %v\n", containerID, err)
+	}
+}
+
 // GetContainerLogs получает логи контейнера
 func (dc *DockerComunication) GetContainerLogs(ctx context.Context, containerID string, tail string) (string, error) {
 	options := container.LogsOptions{
@@ -0,0 +1,171 @@
+package libs
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DockerRunOptions are the structured options of a container that become docker run flags
+type DockerRunOptions struct {
+	// Ports are published as -p, e.g. "8080:80", "127.0.0.1:8443:443/tcp"
+	Ports []string `json:"ports,omitempty"`
+	// Volumes are mounted as -v, e.g. "/srv/data:/data:ro" or "cache:/cache"
+	Volumes []string `json:"volumes,omitempty"`
+	Network string   `json:"network,omitempty"`
+	// IP is the static address on Network, the network needs a configured subnet
+	IP string `json:"ip,omitempty"`
+	// Restart is no, always, unless-stopped, on-failure or on-failure:N
+	Restart string `json:"restart,omitempty"`
+	// Memory limits the container, e.g. "512m" or "2g"
+	Memory string `json:"memory,omitempty"`
+	// CPUs limits the container to a number of CPUs, e.g. "0.5"
+	CPUs   string            `json:"cpus,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Entrypoint overrides the entrypoint of the image
+	Entrypoint string `json:"entrypoint,omitempty"`
+	// Command replaces the command of the image, one element per argument
+	Command     []string                  `json:"command,omitempty"`
+	HealthCheck *DockerHealthCheckOptions `json:"healthcheck,omitempty"`
+}
+
+// DockerHealthCheckOptions configure the health check docker runs inside the container
+type DockerHealthCheckOptions struct {
+	// Command is run with the shell of the container, exit code 0 is healthy
+	Command string `json:"command"`
+	// Interval, Timeout and StartPeriod are durations such as "30s"
+	Interval    string `json:"interval,omitempty"`
+	Timeout     string `json:"timeout,omitempty"`
+	StartPeriod string `json:"start_period,omitempty"`
+	Retries     int    `json:"retries,omitempty"`
+}
+
+var (
+	dockerPortPattern    = regexp.MustCompile(`^((\d{1,3}\.){3}\d{1,3}:|\[[0-9a-fA-F:]+\]:)?(\d{1,5}(-\d{1,5})?:)?\d{1,5}(-\d{1,5})?(/(tcp|udp|sctp))?$`)
+	dockerMemoryPattern  = regexp.MustCompile(`^\d+[bkmgBKMG]?$`)
+	dockerCPUsPattern    = regexp.MustCompile(`^\d+(\.\d+)?$`)
+	dockerRestartPattern = regexp.MustCompile(`^(no|always|unless-stopped|on-failure(:\d+)?)$`)
+	dockerNamePattern    = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// Validate checks the options before they are stored, every value is quoted anyway when the command is built
+func (o DockerRunOptions) Validate() error {
+	for _, port := range o.Ports {
+		if !dockerPortPattern.MatchString(port) {
+			return fmt.Errorf("invalid port mapping %q", port)
+		}
+	}
+	for _, volume := range o.Volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || !strings.HasPrefix(parts[1], "/") {
+			return fmt.Errorf("invalid volume %q, expected source:/target[:options]", volume)
+		}
+	}
+	if o.Network != "" && !dockerNamePattern.MatchString(o.Network) {
+		return fmt.Errorf("invalid network name %q", o.Network)
+	}
+	if o.IP != "" {
+		if o.Network == "" {
+			return fmt.Errorf("a static ip requires a network")
+		}
+		if net.ParseIP(o.IP) == nil {
+			return fmt.Errorf("invalid ip %q", o.IP)
+		}
+	}
+	if o.Restart != "" && !dockerRestartPattern.MatchString(o.Restart) {
+		return fmt.Errorf("invalid restart policy %q", o.Restart)
+	}
+	if o.Memory != "" && !dockerMemoryPattern.MatchString(o.Memory) {
+		return fmt.Errorf("invalid memory limit %q", o.Memory)
+	}
+	if o.CPUs != "" && !dockerCPUsPattern.MatchString(o.CPUs) {
+		return fmt.Errorf("invalid cpus limit %q", o.CPUs)
+	}
+	for key := range o.Labels {
+		if key == "" || strings.ContainsAny(key, "= \t\n") {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+	}
+	if o.HealthCheck != nil {
+		if o.HealthCheck.Command == "" {
+			return fmt.Errorf("healthcheck command is required")
+		}
+		for name, value := range map[string]string{
+			"interval":     o.HealthCheck.Interval,
+			"timeout":      o.HealthCheck.Timeout,
+			"start_period": o.HealthCheck.StartPeriod,
+		} {
+			if value == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(value); err != nil {
+				return fmt.Errorf("invalid healthcheck %s %q", name, value)
+			}
+		}
+		if o.HealthCheck.Retries < 0 {
+			return fmt.Errorf("healthcheck retries must not be negative")
+		}
+	}
+	return nil
+}
+
+// Flags returns the docker run flags of the options, one element per argument and not quoted yet. A
+// blue/green candidate publishes no ports and joins the network with a dynamic address, the running
+// container still holds both
+func (o DockerRunOptions) Flags(candidate bool) []string {
+	flags := make([]string, 0)
+	if !candidate {
+		for _, port := range o.Ports {
+			flags = append(flags, "-p", port)
+		}
+	}
+	for _, volume := range o.Volumes {
+		flags = append(flags, "-v", volume)
+	}
+	if o.Network != "" {
+		flags = append(flags, "--network", o.Network)
+		if !candidate && o.IP != "" {
+			flags = append(flags, "--ip", o.IP)
+		}
+	}
+	if o.Restart != "" {
+		flags = append(flags, "--restart", o.Restart)
+	}
+	if o.Memory != "" {
+		flags = append(flags, "--memory", o.Memory)
+	}
+	if o.CPUs != "" {
+		flags = append(flags, "--cpus", o.CPUs)
+	}
+	// Sorted so the same options always build the same command
+	keys := make([]string, 0, len(o.Labels))
+	for key := range o.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		flags = append(flags, "--label", key+"="+o.Labels[key])
+	}
+	if o.Entrypoint != "" {
+		flags = append(flags, "--entrypoint", o.Entrypoint)
+	}
+	if o.HealthCheck != nil {
+		flags = append(flags, "--health-cmd", o.HealthCheck.Command)
+		if o.HealthCheck.Interval != "" {
+			flags = append(flags, "--health-interval", o.HealthCheck.Interval)
+		}
+		if o.HealthCheck.Timeout != "" {
+			flags = append(flags, "--health-timeout", o.HealthCheck.Timeout)
+		}
+		if o.HealthCheck.StartPeriod != "" {
+			flags = append(flags, "--health-start-period", o.HealthCheck.StartPeriod)
+		}
+		if o.HealthCheck.Retries > 0 {
+			flags = append(flags, "--health-retries", fmt.Sprint(o.HealthCheck.Retries))
+		}
+	}
+	return flags
+}
@@ -0,0 +1,215 @@
+package libs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DotenvEntry is a variable of a dotenv file, Comment holds the comment lines directly above it
+type DotenvEntry struct {
+	Key     string
+	Value   string
+	Comment string
+}
+
+// dotenvPlainPattern matches values that are written without quotes
+var dotenvPlainPattern = regexp.MustCompile(`^[A-Za-z0-9_@%+:,./-]+$`)
+
+// ParseDotenv reads a dotenv file. Lines may start with export, values may be unquoted, single quoted
+// (taken literally) or double quoted (with \n, \r, \t, \", \\ and \$ escapes), quoted values may span
+// lines. Unquoted values end at a # preceded by whitespace. Variables are not expanded, a key set twice
+// keeps the last value
+func ParseDotenv(content string) ([]DotenvEntry, error) {
+	parser := dotenvParser{input: strings.ReplaceAll(content, "\r\n", "\n"), line: 1}
+	return parser.parse()
+}
+
+type dotenvParser struct {
+	input string
+	pos   int
+	line  int
+}
+
+func (p *dotenvParser) parse() ([]DotenvEntry, error) {
+	entries := make([]DotenvEntry, 0)
+	index := make(map[string]int)
+	comment := make([]string, 0)
+	for p.pos < len(p.input) {
+		p.skipBlanks()
+		if p.pos >= len(p.input) {
+			break
+		}
+		switch p.input[p.pos] {
+		case '\n':
+			// An empty line detaches the comment above it
+			comment = comment[:0]
+			p.next()
+			continue
+		case '#':
+			text := p.restOfLine()[1:]
+			comment = append(comment, strings.TrimPrefix(text, " "))
+			continue
+		}
+
+		entry, err := p.parseEntry()
+		if err != nil {
+			return nil, err
+		}
+		entry.Comment = strings.Join(comment, "\n")
+		comment = comment[:0]
+		if i, ok := index[entry.Key]; ok {
+			entries[i] = entry
+		} else {
+			index[entry.Key] = len(entries)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (p *dotenvParser) parseEntry() (DotenvEntry, error) {
+	line := p.line
+	key := p.readKey()
+	if key == "export" && p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.skipBlanks()
+		key = p.readKey()
+	}
+	if !ValidEnvName(key) {
+		return DotenvEntry{}, fmt.Errorf("line %d: invalid key %q", line, key)
+	}
+	p.skipBlanks()
+	if p.pos >= len(p.input) || p.input[p.pos] != '=' {
+		return DotenvEntry{}, fmt.Errorf("line %d: expected = after %s", line, key)
+	}
+	p.next()
+	start := p.pos
+	p.skipBlanks()
+	spaced := p.pos > start
+
+	var value string
+	var err error
+	if p.pos < len(p.input) && (p.input[p.pos] == '\'' || p.input[p.pos] == '"') {
+		value, err = p.readQuoted(line)
+		if err != nil {
+			return DotenvEntry{}, err
+		}
+		// Only a comment may follow the closing quote
+		p.skipBlanks()
+		if rest := p.restOfLine(); rest != "" && rest[0] != '#' {
+			return DotenvEntry{}, fmt.Errorf("line %d: unexpected %q after the value of %s", line, rest, key)
+		}
+	} else {
+		value = p.restOfLine()
+		if spaced && strings.HasPrefix(value, "#") {
+			value = ""
+		}
+		for i := 1; i < len(value); i++ {
+			if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+				value = value[:i]
+				break
+			}
+		}
+		value = strings.TrimRight(value, " \t")
+	}
+	return DotenvEntry{Key: key, Value: value}, nil
+}
+
+func (p *dotenvParser) readKey() string {
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(" \t\n=", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *dotenvParser) readQuoted(line int) (string, error) {
+	quote := p.input[p.pos]
+	p.next()
+	var value strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		p.next()
+		if c == quote {
+			return value.String(), nil
+		}
+		if c == '\\' && quote == '"' && p.pos < len(p.input) {
+			escaped := p.input[p.pos]
+			switch escaped {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case '"', '\\', '$':
+				value.WriteByte(escaped)
+			default:
+				// Unknown escapes are kept as written
+				value.WriteByte('\\')
+				value.WriteByte(escaped)
+			}
+			p.next()
+			continue
+		}
+		value.WriteByte(c)
+	}
+	return "", fmt.Errorf("line %d: missing closing %c", line, quote)
+}
+
+// restOfLine returns the text up to the end of the line and moves past the line break
+func (p *dotenvParser) restOfLine() string {
+	end := strings.IndexByte(p.input[p.pos:], '\n')
+	if end < 0 {
+		end = len(p.input) - p.pos
+	}
+	text := p.input[p.pos : p.pos+end]
+	p.pos += end
+	if p.pos < len(p.input) {
+		p.next()
+	}
+	return text
+}
+
+func (p *dotenvParser) skipBlanks() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *dotenvParser) next() {
+	if p.input[p.pos] == '\n' {
+		p.line++
+	}
+	p.pos++
+}
+
+// FormatDotenv writes entries as a dotenv file that ParseDotenv reads back unchanged, values that are not
+// plain words are double quoted and comments are written above their entry
+func FormatDotenv(entries []DotenvEntry) string {
+	var builder strings.Builder
+	for i, entry := range entries {
+		if entry.Comment != "" {
+			if i > 0 {
+				builder.WriteString("\n")
+			}
+			for _, line := range strings.Split(entry.Comment, "\n") {
+				if line == "" {
+					builder.WriteString("#\n")
+				} else {
+					builder.WriteString("# " + line + "\n")
+				}
+			}
+		}
+		builder.WriteString(entry.Key + "=" + quoteDotenvValue(entry.Value) + "\n")
+	}
+	return builder.String()
+}
+
+func quoteDotenvValue(value string) string {
+	if value == "" || dotenvPlainPattern.MatchString(value) {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + replacer.Replace(value) + `"`
+}
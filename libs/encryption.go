@@ -7,16 +7,44 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"os"
+	"strings"
+	"sync"
+)
+
+// Encrypted values carry a version prefix:
+// v2:<key id>:<hex nonce + data key sealed by the master key>:<hex nonce + value sealed by the data key>.
+// The KeyProvider seals and opens the data key. v1 values have no key id, values without a prefix are in the
+// legacy format sealed by the master key with the user IV as nonce. Both are read with the LegacyKey
+const (
+	envelopeV1Prefix = "v1:"
+	envelopeV2Prefix = "v2:"
 )
 
 type EncryptionService struct {
+	once        sync.Once
+	provider    KeyProvider
+	providerErr error
 }
 
+// NewEncryptionService uses the provider set with SetDefaultKeyProvider, without one the key ring is read
+// from the environment on first use
 func NewEncryptionService() *EncryptionService {
 	return &EncryptionService{}
 }
 
+func NewEncryptionServiceWithProvider(provider KeyProvider) *EncryptionService {
+	e := &EncryptionService{provider: provider}
+	e.once.Do(func() {})
+	return e
+}
+
+func (e *EncryptionService) keys() (KeyProvider, error) {
+	e.once.Do(func() {
+		e.provider, e.providerErr = defaultKeyProvider()
+	})
+	return e.provider, e.providerErr
+}
+
 func (e *EncryptionService) GenIv() string {
 	iv := make([]byte, 12) // GCM standard nonce size
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
@@ -25,48 +53,109 @@ func (e *EncryptionService) GenIv() string {
 	return hex.EncodeToString(iv)
 }
 
+// Encrypt seals data with a random data key and nonce, the data key is stored with the value sealed by the
+// active master key of the provider. iv is only used to read values of the legacy format
 func (e *EncryptionService) Encrypt(data string, iv string) (string, error) {
-	key, err := hex.DecodeString(os.Getenv("ENCRYPTION_KEY"))
+	provider, err := e.keys()
 	if err != nil {
 		return "", err
 	}
-	if len(key) != 32 {
-		return "", fmt.Errorf("invalid key length: got %d, want 32", len(key))
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
 	}
-	block, err := aes.NewCipher(key)
+	keyID, sealedKey, err := provider.WrapKey(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to seal data key: %w", err)
+	}
+	sealedData, err := seal(dataKey, []byte(data), nil)
 	if err != nil {
 		return "", err
 	}
-	ivBytes, err := hex.DecodeString(iv)
+	return envelopeV2Prefix + keyID + ":" + hex.EncodeToString(sealedKey) + ":" + hex.EncodeToString(sealedData), nil
+}
+
+// Decrypt opens values of every format with the key they were encrypted with
+func (e *EncryptionService) Decrypt(data string, iv string) (string, error) {
+	if data == "" || data == "null" {
+		return "", nil
+	}
+	provider, err := e.keys()
 	if err != nil {
 		return "", err
 	}
-	if len(ivBytes) != 12 {
-		return "", fmt.Errorf("invalid IV length: got %d, want 12", len(ivBytes))
+	if strings.HasPrefix(data, envelopeV2Prefix) {
+		keyID, rest, ok := strings.Cut(strings.TrimPrefix(data, envelopeV2Prefix), ":")
+		if !ok {
+			return "", fmt.Errorf("malformed encrypted value")
+		}
+		return e.decryptEnvelope(rest, func(sealedKey []byte) ([]byte, error) {
+			return provider.UnwrapKey(keyID, sealedKey)
+		})
 	}
-	gcm, err := cipher.NewGCM(block)
+
+	legacyKey, err := provider.LegacyKey()
 	if err != nil {
 		return "", err
 	}
-	cipherText := gcm.Seal(nil, ivBytes, []byte(data), nil)
-	return hex.EncodeToString(cipherText), nil
+	if strings.HasPrefix(data, envelopeV1Prefix) {
+		return e.decryptEnvelope(strings.TrimPrefix(data, envelopeV1Prefix), func(sealedKey []byte) ([]byte, error) {
+			return open(legacyKey, sealedKey, nil)
+		})
+	}
+	return e.decryptLegacy(legacyKey, data, iv)
 }
 
-func (e *EncryptionService) Decrypt(data string, iv string) (string, error) {
+// IsCurrent reports whether the value is empty or already encrypted with the active key
+func (e *EncryptionService) IsCurrent(data string) (bool, error) {
 	if data == "" || data == "null" {
-		return "", nil
+		return true, nil
 	}
-	key, err := hex.DecodeString(os.Getenv("ENCRYPTION_KEY"))
+	provider, err := e.keys()
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(data, envelopeV2Prefix+provider.ActiveKeyID()+":"), nil
+}
+
+// Reencrypt decrypts the value and encrypts it again with the active key, empty values stay empty
+func (e *EncryptionService) Reencrypt(data string, iv string) (string, error) {
+	if data == "" || data == "null" {
+		return data, nil
+	}
+	decrypted, err := e.Decrypt(data, iv)
 	if err != nil {
 		return "", err
 	}
-	if len(key) != 32 {
-		return "", fmt.Errorf("invalid key length: got %d, want 32", len(key))
+	return e.Encrypt(decrypted, iv)
+}
+
+// decryptEnvelope opens the data key of the value with unwrap and the value with the data key
+func (e *EncryptionService) decryptEnvelope(data string, unwrap func(sealedKey []byte) ([]byte, error)) (string, error) {
+	encodedKey, encodedData, ok := strings.Cut(data, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value")
 	}
-	block, err := aes.NewCipher(key)
+	sealedKey, err := hex.DecodeString(encodedKey)
+	if err != nil {
+		return "", err
+	}
+	sealedData, err := hex.DecodeString(encodedData)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := unwrap(sealedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to open data key: %w", err)
+	}
+	plaintext, err := open(dataKey, sealedData, nil)
 	if err != nil {
 		return "", err
 	}
+	return string(plaintext), nil
+}
+
+func (e *EncryptionService) decryptLegacy(masterKey []byte, data string, iv string) (string, error) {
 	ivBytes, err := hex.DecodeString(iv)
 	if err != nil {
 		return "", err
@@ -74,7 +163,7 @@ func (e *EncryptionService) Decrypt(data string, iv string) (string, error) {
 	if len(ivBytes) != 12 {
 		return "", fmt.Errorf("invalid IV length: got %d, want 12", len(ivBytes))
 	}
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(masterKey)
 	if err != nil {
 		return "", err
 	}
@@ -88,3 +177,37 @@ func (e *EncryptionService) Decrypt(data string, iv string) (string, error) {
 	}
 	return string(plaintext), nil
 }
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under a random nonce and returns the nonce followed by the ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open reverses seal
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted value is too short")
+	}
+	nonce, cipherText := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, cipherText, additionalData)
+}
@@ -0,0 +1,55 @@
+package libs
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	ExecStreamStdout = "stdout"
+	ExecStreamStderr = "stderr"
+)
+
+// ExecOutputHandler receives a single line of command output, stream is ExecStreamStdout or ExecStreamStderr
+type ExecOutputHandler func(stream string, line string)
+
+// execLineWriter keeps the whole output of one stream and hands complete lines to the handler
+type execLineWriter struct {
+	stream  string
+	onLine  ExecOutputHandler
+	output  strings.Builder
+	pending []byte
+}
+
+func newExecLineWriter(stream string, onLine ExecOutputHandler) *execLineWriter {
+	return &execLineWriter{stream: stream, onLine: onLine}
+}
+
+func (w *execLineWriter) Write(p []byte) (int, error) {
+	w.output.Write(p)
+	if w.onLine == nil {
+		return len(p), nil
+	}
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(w.stream, strings.TrimSuffix(string(w.pending[:i]), "\r"))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush hands the last unterminated line to the handler
+func (w *execLineWriter) Flush() {
+	if w.onLine != nil && len(w.pending) > 0 {
+		w.onLine(w.stream, strings.TrimSuffix(string(w.pending), "\r"))
+	}
+	w.pending = nil
+}
+
+func (w *execLineWriter) String() string {
+	return w.output.String()
+}
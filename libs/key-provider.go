@@ -0,0 +1,68 @@
+package libs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// KeyProvider holds the master keys, EncryptionService only ever sees the data keys it seals and opens
+type KeyProvider interface {
+	// ActiveKeyID is the id of the master key new data keys are sealed with
+	ActiveKeyID() string
+	// WrapKey seals a data key with the active master key and returns the id of that key
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey opens a data key sealed with the master key keyID
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+	// LegacyKey returns the DefaultKeyID master key itself, values written before key ids existed use it
+	// directly
+	LegacyKey() ([]byte, error)
+}
+
+const (
+	KeyProviderEnv   = "env"
+	KeyProviderFile  = "file"
+	KeyProviderVault = "vault"
+)
+
+var (
+	defaultProviderMutex sync.RWMutex
+	defaultProvider      KeyProvider
+)
+
+// SetDefaultKeyProvider sets the provider of every EncryptionService created by NewEncryptionService
+func SetDefaultKeyProvider(provider KeyProvider) {
+	defaultProviderMutex.Lock()
+	defer defaultProviderMutex.Unlock()
+	defaultProvider = provider
+}
+
+func defaultKeyProvider() (KeyProvider, error) {
+	defaultProviderMutex.RLock()
+	provider := defaultProvider
+	defaultProviderMutex.RUnlock()
+	if provider != nil {
+		return provider, nil
+	}
+	return LoadKeyRing()
+}
+
+// LoadKeyProvider creates the provider of the given kind configured by the environment:
+//   - env: the key ring of ENCRYPTION_KEY, ENCRYPTION_KEYS and ENCRYPTION_ACTIVE_KEY, see LoadKeyRing
+//   - file: the key ring in the file ENCRYPTION_KEY_FILE, see LoadKeyRingFile
+//   - vault: the transit engine of a HashiCorp Vault, see LoadVaultKeyProvider
+func LoadKeyProvider(kind string) (KeyProvider, error) {
+	switch kind {
+	case "", KeyProviderEnv:
+		return LoadKeyRing()
+	case KeyProviderFile:
+		path := os.Getenv("ENCRYPTION_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("ENCRYPTION_KEY_FILE is not set")
+		}
+		return LoadKeyRingFile(path, os.Getenv("ENCRYPTION_ACTIVE_KEY"))
+	case KeyProviderVault:
+		return LoadVaultKeyProvider()
+	}
+	return nil, fmt.Errorf("unknown key provider %q, use env, file or vault", kind)
+}
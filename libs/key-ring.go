@@ -0,0 +1,152 @@
+package libs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultKeyID is the id of the key in ENCRYPTION_KEY, values written before key ids existed are read with it
+const DefaultKeyID = "default"
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// KeyRing is the KeyProvider of local master keys by id, new data keys are sealed with the active key and
+// every key in the ring can still open them
+type KeyRing struct {
+	keys     map[string][]byte
+	activeID string
+}
+
+func NewKeyRing(keys map[string][]byte, activeID string) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no encryption key configured, set ENCRYPTION_KEY or ENCRYPTION_KEYS")
+	}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key id %q, use letters, digits, - and _", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid key length of %s: got %d, want 32", id, len(key))
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not in the key ring", activeID)
+	}
+	return &KeyRing{keys: keys, activeID: activeID}, nil
+}
+
+// LoadKeyRing reads the key ring from the environment: ENCRYPTION_KEY is the key with DefaultKeyID,
+// ENCRYPTION_KEYS adds comma separated id:hex pairs and ENCRYPTION_ACTIVE_KEY picks the key new values are
+// encrypted with, DefaultKeyID when unset
+func LoadKeyRing() (*KeyRing, error) {
+	keys, err := envKeys()
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRing(keys, activeKeyID(os.Getenv("ENCRYPTION_ACTIVE_KEY")))
+}
+
+// envKeys reads the keys of ENCRYPTION_KEY and ENCRYPTION_KEYS
+func envKeys() (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	if value := os.Getenv("ENCRYPTION_KEY"); value != "" {
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENCRYPTION_KEY: %w", err)
+		}
+		keys[DefaultKeyID] = key
+	}
+	if err := parseKeyEntries(keys, strings.Split(os.Getenv("ENCRYPTION_KEYS"), ","), false); err != nil {
+		return nil, fmt.Errorf("invalid ENCRYPTION_KEYS: %w", err)
+	}
+	return keys, nil
+}
+
+// LoadKeyRingFile reads the key ring from a file with one id:hex pair per line, a line holding only the hex
+// key is the DefaultKeyID key. Empty lines and lines starting with # are skipped. activeID picks the key new
+// values are encrypted with, DefaultKeyID when empty
+func LoadKeyRingFile(path, activeID string) (*KeyRing, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	keys := make(map[string][]byte)
+	if err := parseKeyEntries(keys, strings.Split(string(content), "\n"), true); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	return NewKeyRing(keys, activeKeyID(activeID))
+}
+
+// parseKeyEntries adds id:hex entries to keys, with allowDefault a bare hex entry is the DefaultKeyID key
+func parseKeyEntries(keys map[string][]byte, entries []string, allowDefault bool) error {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			if !allowDefault {
+				return fmt.Errorf("expected id:hex entries")
+			}
+			id, value = DefaultKeyID, entry
+		}
+		if _, exists := keys[id]; exists {
+			return fmt.Errorf("encryption key %q is configured twice", id)
+		}
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return nil
+}
+
+func activeKeyID(id string) string {
+	if id == "" {
+		return DefaultKeyID
+	}
+	return id
+}
+
+// ActiveKeyID is the id of the key new values are encrypted with
+func (k *KeyRing) ActiveKeyID() string {
+	return k.activeID
+}
+
+func (k *KeyRing) WrapKey(dataKey []byte) (string, []byte, error) {
+	key, err := k.key(k.activeID)
+	if err != nil {
+		return "", nil, err
+	}
+	// The key id is authenticated with the data key, so it cannot be swapped
+	wrapped, err := seal(key, dataKey, []byte(k.activeID))
+	if err != nil {
+		return "", nil, err
+	}
+	return k.activeID, wrapped, nil
+}
+
+func (k *KeyRing) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, wrapped, []byte(keyID))
+}
+
+func (k *KeyRing) LegacyKey() ([]byte, error) {
+	return k.key(DefaultKeyID)
+}
+
+func (k *KeyRing) key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %q is not in the key ring", id)
+	}
+	return key, nil
+}
@@ -0,0 +1,37 @@
+package libs
+
+import (
+	"regexp"
+	"strings"
+)
+
+// shellSafePattern matches words the shell passes on unchanged, they are left unquoted for readable commands
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// envNamePattern matches the names the shell accepts for variables, other names cannot be exported
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidEnvName reports whether name can be used as an environment variable in generated commands
+func ValidEnvName(name string) bool {
+	return envNamePattern.MatchString(name)
+}
+
+// ShellQuote quotes a value as a single POSIX shell word, nothing inside it is expanded
+func ShellQuote(value string) string {
+	if value == "" {
+		return "''"
+	}
+	if shellSafePattern.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// ShellJoin quotes every argument and joins them into one command line
+func ShellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
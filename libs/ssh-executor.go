@@ -0,0 +1,175 @@
+package libs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHExecutor runs commands on servers with the native Go SSH client instead of sshpass
+type SSHExecutor struct{}
+
+type SSHExecutorConfig struct {
+	Host       string
+	Port       int
+	User       string
+	Password   string
+	PrivateKey string
+	// PrivateKeyPassphrase unlocks a passphrase protected PrivateKey
+	PrivateKeyPassphrase string
+	// HostKeyFingerprint is the pinned SHA256 fingerprint the server has to present
+	HostKeyFingerprint string
+	// HostKey is the pinned key in authorized_keys format, when set only its type is negotiated so a server
+	// preferring another key type still presents the pinned one
+	HostKey string
+	Timeout time.Duration
+}
+
+func NewSSHExecutor() *SSHExecutor {
+	return &SSHExecutor{}
+}
+
+// Dial opens an authenticated SSH connection, the caller must close the client
+func (e *SSHExecutor) Dial(ctx context.Context, config *SSHExecutorConfig) (*ssh.Client, error) {
+	if config.HostKeyFingerprint == "" {
+		return nil, fmt.Errorf("host key of %s is not pinned", config.Host)
+	}
+	auth, err := e.authMethods(config)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 15 * time.Second
+	}
+	port := config.Port
+	if port == 0 {
+		port = 22
+	}
+	address := net.JoinHostPort(config.Host, strconv.Itoa(port))
+
+	clientConfig := &ssh.ClientConfig{
+		User:            config.User,
+		Auth:            auth,
+		HostKeyCallback: PinnedHostKeyCallback(config.HostKeyFingerprint),
+		Timeout:         timeout,
+	}
+	if config.HostKey != "" {
+		key, err := ParseHostKey(config.HostKey)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.HostKeyAlgorithms = HostKeyAlgorithms(key.Type())
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open ssh connection to %s: %w", address, err)
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// Run executes a command on the server and passes every output line to onLine while it runs,
+// a non-zero exit code is reported in the result and not as an error
+func (e *SSHExecutor) Run(ctx context.Context, config *SSHExecutorConfig, command string, onLine ExecOutputHandler) (*ExecResult, error) {
+	return e.RunWithInput(ctx, config, command, nil, onLine)
+}
+
+// RunWithInput runs the command like Run and passes input as its stdin, so the data never shows up in
+// the arguments of a process
+func (e *SSHExecutor) RunWithInput(ctx context.Context, config *SSHExecutorConfig, command string, input []byte, onLine ExecOutputHandler) (*ExecResult, error) {
+	client, err := e.Dial(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssh session: %w", err)
+	}
+	defer session.Close()
+
+	stdout := newExecLineWriter(ExecStreamStdout, onLine)
+	stderr := newExecLineWriter(ExecStreamStderr, onLine)
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if input != nil {
+		session.Stdin = bytes.NewReader(input)
+	}
+
+	if err := session.Start(command); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Kill the remote process and drop the connection so Wait returns
+		session.Signal(ssh.SIGKILL)
+		client.Close()
+		<-done
+		stdout.Flush()
+		stderr.Flush()
+		return &ExecResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: -1}, ctx.Err()
+	}
+	stdout.Flush()
+	stderr.Flush()
+
+	result := &ExecResult{Stdout: stdout.String(), Stderr: stderr.String()}
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitStatus()
+			return result, nil
+		}
+		result.ExitCode = -1
+		return result, fmt.Errorf("failed to run command: %w", err)
+	}
+	return result, nil
+}
+
+func (e *SSHExecutor) authMethods(config *SSHExecutorConfig) ([]ssh.AuthMethod, error) {
+	methods := make([]ssh.AuthMethod, 0)
+	if config.PrivateKey != "" {
+		signer, err := ParsePrivateKey(config.PrivateKey, config.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		password := config.Password
+		methods = append(methods, ssh.Password(password))
+		// Some servers only allow keyboard-interactive for password logins
+		methods = append(methods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = password
+			}
+			return answers, nil
+		}))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh credentials configured, set a password or a private key")
+	}
+	return methods, nil
+}
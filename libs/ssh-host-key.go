@@ -0,0 +1,142 @@
+package libs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMismatchError is returned when a server presents a host key other than the pinned one
+type HostKeyMismatchError struct {
+	Host     string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: expected %s, got %s (re-pin the host key if the server was reinstalled)",
+		e.Host, e.Expected, e.Actual)
+}
+
+var errHostKeyCaptured = errors.New("host key captured")
+
+// HostKeyFingerprint returns the SHA256 fingerprint of a key in the format printed by ssh-keygen -l
+func HostKeyFingerprint(key ssh.PublicKey) string {
+	return ssh.FingerprintSHA256(key)
+}
+
+// MarshalHostKey returns the key in authorized_keys format, e.g. "ssh-ed25519 AAAA..."
+func MarshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// ParseHostKey parses a key in authorized_keys format
+func ParseHostKey(hostKey string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key: %w", err)
+	}
+	return key, nil
+}
+
+// KnownHostsLine builds the known_hosts entry OpenSSH expects for the host, port and key
+func KnownHostsLine(host string, port int, key ssh.PublicKey) string {
+	if port == 0 {
+		port = 22
+	}
+	address := knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))
+	return knownhosts.Line([]string{address}, key)
+}
+
+// PinnedHostKeyCallback accepts only the host key with the given fingerprint
+func PinnedHostKeyCallback(fingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		actual := HostKeyFingerprint(key)
+		if actual != fingerprint {
+			return &HostKeyMismatchError{Host: hostname, Expected: fingerprint, Actual: actual}
+		}
+		return nil
+	}
+}
+
+// hostKeyTypes are the host key types FetchHostKeys asks a server for one by one
+var hostKeyTypes = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA,
+}
+
+// HostKeyAlgorithms returns the algorithms a server may sign with for a host key of keyType, RSA keys
+// sign with SHA-2 on current servers
+func HostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// FetchHostKey connects to the server and returns its host key without authenticating. algorithms limits the
+// host key algorithms offered to the server, nil takes the one the server prefers
+func (e *SSHExecutor) FetchHostKey(ctx context.Context, host string, port int, algorithms []string) (ssh.PublicKey, error) {
+	if port == 0 {
+		port = 22
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+
+	var hostKey ssh.PublicKey
+	clientConfig := &ssh.ClientConfig{
+		User: "deployer",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			// Abort the handshake, the key is all we need
+			return errHostKeyCaptured
+		},
+		HostKeyAlgorithms: algorithms,
+		Timeout:           15 * time.Second,
+	}
+
+	dialer := net.Dialer{Timeout: clientConfig.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(clientConfig.Timeout))
+
+	_, _, _, err = ssh.NewClientConn(conn, address, clientConfig)
+	if hostKey == nil {
+		return nil, fmt.Errorf("failed to read host key of %s: %w", address, err)
+	}
+	return hostKey, nil
+}
+
+// FetchHostKeys returns every host key the server offers, one connection per key type. Types the server
+// has no key for are skipped
+func (e *SSHExecutor) FetchHostKeys(ctx context.Context, host string, port int) ([]ssh.PublicKey, error) {
+	keys := make([]ssh.PublicKey, 0, len(hostKeyTypes))
+	var lastErr error
+	for _, keyType := range hostKeyTypes {
+		key, err := e.FetchHostKey(ctx, host, port, HostKeyAlgorithms(keyType))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, lastErr
+	}
+	return keys, nil
+}
@@ -0,0 +1,72 @@
+package libs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ParsePrivateKey parses a PEM or OpenSSH private key, the passphrase is only used for protected keys
+func ParsePrivateKey(privateKey, passphrase string) (ssh.Signer, error) {
+	var signer ssh.Signer
+	var err error
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(privateKey))
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("ssh key is passphrase protected, set ssh_key_passphrase")
+		}
+		return nil, fmt.Errorf("failed to parse ssh key: %w", err)
+	}
+	return signer, nil
+}
+
+// UnencryptedPrivateKey returns the key in OpenSSH format without a passphrase, so ssh in a deploy-worker
+// can use it without prompting. The result must only ever be written to short-lived files
+func UnencryptedPrivateKey(privateKey, passphrase string) (string, error) {
+	if _, err := ParsePrivateKey(privateKey, passphrase); err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return strings.TrimSpace(privateKey) + "\n", nil
+	}
+	rawKey, err := ssh.ParseRawPrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ssh key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(rawKey, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode ssh key: %w", err)
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// GenerateSSHKeyPair creates an ed25519 keypair, the private key in OpenSSH format and the public key in authorized_keys format
+func GenerateSSHKeyPair(comment string) (privateKey string, publicKey string, err error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate ssh key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, comment)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode ssh key: %w", err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode ssh public key: %w", err)
+	}
+	publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic)))
+	if comment != "" {
+		publicKey += " " + comment
+	}
+	return string(pem.EncodeToMemory(block)), publicKey, nil
+}
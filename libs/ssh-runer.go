@@ -1,17 +1,24 @@
 package libs
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"sort"
 	"strings"
 )
 
 type SSHRuner struct{}
 
 type SSHRunerConfig struct {
-	IP                    string
-	User                  string
-	Password              string
-	Script                string
+	IP   string
+	Port int
+	User string
+	// Password is read from stdin by worker commands like SSHKey, it is never part of the command
+	Password string
+	Script   string
+	// SSHKey is the unencrypted private key itself, not a path. Worker commands read it from their stdin
+	// (WorkerInput), it is never part of the command
 	SSHKey                *string
 	Env                   *map[string]string
 	DockerUser            *string
@@ -22,12 +29,25 @@ type SSHRunerConfig struct {
 	DockerTag             *string
 	SetSecretsToScript    *bool
 	SetSecretsToContainer *bool
+	// DockerRunOptions become the flags of docker run, nil runs the container without any
+	DockerRunOptions *DockerRunOptions
+	// ComposeProject is the docker compose project name of a stack
+	ComposeProject *string
+	// EnvFile is the name of an uploaded env file holding Env, scripts source it and containers get it as
+	// --env-file instead of the values on the command line. The command using it removes it
+	EnvFile *string
+	// KnownHosts is the known_hosts line of the pinned host key, ssh refuses hosts presenting another key
+	KnownHosts *string
+	// Direct returns the bare remote command for SSHExecutor instead of wrapping it in sshpass for a deploy-worker
+	Direct bool
 }
 
 func NewSSHRuner() *SSHRuner {
 	return &SSHRuner{}
 }
 
+// LoginDockerCommand logs in as DockerUser with the password read from its stdin, the caller passes
+// DockerPassword as input so it never appears in process arguments
 func (r *SSHRuner) LoginDockerCommand(confing *SSHRunerConfig) (string, error) {
 	command := r.loginDockerCommand(confing)
 	return command, nil
@@ -39,89 +59,355 @@ func (r *SSHRuner) PullDockerCommand(confing *SSHRunerConfig) (string, error) {
 }
 
 func (r *SSHRuner) RunDockerCommand(confing *SSHRunerConfig) (string, error) {
-	command := r.runDockerCommand(confing)
-	return command, nil
+	return r.runDockerCommand(confing)
+}
+
+// RemoveDockerCommand force removes the container named DockerContainerName, a missing container is not an error
+func (r *SSHRuner) RemoveDockerCommand(confing *SSHRunerConfig) (string, error) {
+	if confing.DockerContainerName == nil {
+		return "", fmt.Errorf("container name is required")
+	}
+	command := fmt.Sprintf("docker rm -f %s >/dev/null 2>&1 || true", ShellQuote(*confing.DockerContainerName))
+	return r.createCommand(confing, r.remoteShell(confing, command)), nil
+}
+
+// RunCandidateDockerCommand starts the new container of a blue/green deployment next to the running one,
+// under CandidateContainerName and without the ports and the static IP the running container holds
+func (r *SSHRuner) RunCandidateDockerCommand(confing *SSHRunerConfig) (string, error) {
+	if confing.DockerImage == nil || confing.DockerContainerName == nil {
+		return "", fmt.Errorf("image and container name are required")
+	}
+	command, err := r.dockerRunCommand(confing, CandidateContainerName(*confing.DockerContainerName), true)
+	if err != nil {
+		return "", err
+	}
+	return r.createCommand(confing, r.remoteShell(confing, r.removeEnvFileAfter(confing, command))), nil
+}
+
+// SwapDockerCommand replaces the running container with the candidate and moves the static IP over to it.
+// Published ports cannot be added to a running container, with ports the container is run again instead,
+// see RunDockerCommand
+func (r *SSHRuner) SwapDockerCommand(confing *SSHRunerConfig) (string, error) {
+	if confing.DockerContainerName == nil {
+		return "", fmt.Errorf("container name is required")
+	}
+	name := ShellQuote(*confing.DockerContainerName)
+	command := fmt.Sprintf("docker rm -f %s >/dev/null 2>&1; docker rename %s %s",
+		name, ShellQuote(CandidateContainerName(*confing.DockerContainerName)), name)
+	if options := confing.DockerRunOptions; options != nil && options.Network != "" && options.IP != "" {
+		network := ShellQuote(options.Network)
+		command += fmt.Sprintf(" && docker network disconnect %s %s && docker network connect --ip %s %s %s",
+			network, name, ShellQuote(options.IP), network, name)
+	}
+	return r.createCommand(confing, r.remoteShell(confing, command)), nil
+}
+
+// ComposeUpCommand writes its stdin to the compose file of the stack on the server, pulls its images and
+// starts it. The file holds the interpolated secrets, it is sent as input of the command so they never
+// appear in process arguments, and only the login user can read it
+func (r *SSHRuner) ComposeUpCommand(confing *SSHRunerConfig) (string, error) {
+	if confing.ComposeProject == nil {
+		return "", fmt.Errorf("compose project is required")
+	}
+	dir := ComposeProjectDir(*confing.ComposeProject)
+	file := dir + "/docker-compose.yml"
+	project := ShellQuote(*confing.ComposeProject)
+	command := fmt.Sprintf("mkdir -p %s && chmod 700 %s && (umask 077 && cat > %s) && "+
+		"docker compose -p %s -f %s pull && docker compose -p %s -f %s up -d --remove-orphans",
+		dir, dir, file, project, file, project, file)
+	return r.createCommand(confing, r.remoteShell(confing, command)), nil
+}
+
+// ComposeDownCommand stops and removes the containers of the stack together with its compose file
+func (r *SSHRuner) ComposeDownCommand(confing *SSHRunerConfig) (string, error) {
+	if confing.ComposeProject == nil {
+		return "", fmt.Errorf("compose project is required")
+	}
+	dir := ComposeProjectDir(*confing.ComposeProject)
+	command := fmt.Sprintf("docker compose -p %s down --remove-orphans && rm -rf %s",
+		ShellQuote(*confing.ComposeProject), dir)
+	return r.createCommand(confing, r.remoteShell(confing, command)), nil
+}
+
+// ComposeStatusCommand lists the containers of the stack as JSON, one object per line
+func (r *SSHRuner) ComposeStatusCommand(confing *SSHRunerConfig) (string, error) {
+	if confing.ComposeProject == nil {
+		return "", fmt.Errorf("compose project is required")
+	}
+	command := fmt.Sprintf("docker compose -p %s ps --all --format json", ShellQuote(*confing.ComposeProject))
+	return r.createCommand(confing, r.remoteShell(confing, command)), nil
+}
+
+// ComposeProjectDir is the directory on the server holding the compose file of a stack
+func ComposeProjectDir(project string) string {
+	return "\"$HOME\"/.deployer/stacks/" + ShellQuote(project)
+}
+
+// UploadEnvFileCommand writes its stdin to EnvFile, readable only by the login user. The content is sent
+// as input of the command so the secrets never appear in process arguments
+func (r *SSHRuner) UploadEnvFileCommand(confing *SSHRunerConfig) (string, error) {
+	if confing.EnvFile == nil {
+		return "", fmt.Errorf("env file name is required")
+	}
+	command := fmt.Sprintf("umask 077 && mkdir -p %[1]s && chmod 700 %[1]s && cat > %[2]s",
+		envFileDir, EnvFilePath(*confing.EnvFile))
+	return r.createCommand(confing, r.remoteShell(confing, command)), nil
+}
+
+// ScriptEnvFile returns the content of an env file scripts source, one export line per variable
+func (r *SSHRuner) ScriptEnvFile(confing *SSHRunerConfig) (string, error) {
+	return r.loadEnvToScript(confing)
+}
+
+// ContainerEnvFile returns the content of an env file for docker run --env-file. Docker reads the lines
+// literally, so values are not quoted and cannot span several lines
+func (r *SSHRuner) ContainerEnvFile(confing *SSHRunerConfig) (string, error) {
+	keys, err := r.envKeys(confing, validContainerEnvName)
+	if err != nil {
+		return "", err
+	}
+	var content strings.Builder
+	for _, key := range keys {
+		value := (*confing.Env)[key]
+		if strings.ContainsAny(value, "\r\n") {
+			return "", fmt.Errorf("value of %s spans several lines, docker env files cannot hold it", key)
+		}
+		fmt.Fprintf(&content, "%s=%s\n", key, value)
+	}
+	return content.String(), nil
+}
+
+// envFileDir is the directory on the server holding the uploaded env files
+const envFileDir = `"$HOME"/.deployer/env`
+
+// EnvFilePath is the path of an uploaded env file on the server
+func EnvFilePath(name string) string {
+	return envFileDir + "/" + ShellQuote(name)
+}
+
+// CandidateContainerName is the name the new container of a blue/green deployment runs under until the swap
+func CandidateContainerName(name string) string {
+	return name + "-next"
 }
 
 func (r *SSHRuner) CreateScriptRunner(confing *SSHRunerConfig) (string, error) {
 	script := confing.Script
 	if confing.SetSecretsToScript != nil && *confing.SetSecretsToScript {
-		envCommand := r.loadEnvToScript(confing)
-		script = envCommand + "\n" + script
+		if confing.EnvFile != nil {
+			// The file is gone before the script itself starts
+			path := EnvFilePath(*confing.EnvFile)
+			script = fmt.Sprintf(". %s; rm -f %s\n%s", path, path, script)
+		} else {
+			envCommand, err := r.loadEnvToScript(confing)
+			if err != nil {
+				return "", err
+			}
+			script = envCommand + "\n" + script
+		}
 	}
 
-	// Since DockerComunication.ExecuteCommand already wraps with bash -c,
-	// we just need to properly escape the script for SSH execution
-	if strings.Contains(script, "\n") || strings.Contains(script, "'") || strings.Contains(script, "\"") {
-		// For complex scripts with special characters, escape them properly
-		escapedScript := strings.ReplaceAll(script, "'", "'\"'\"'")
-		command := r.createCommand(confing, fmt.Sprintf("'%s'", escapedScript))
-		return command, nil
-	}
+	// The native client hands the script to the remote shell as is, the worker passes it to ssh as one word
+	return r.createCommand(confing, r.remoteShell(confing, script)), nil
+}
 
-	// For simple commands, pass directly
-	command := r.createCommand(confing, script)
-	return command, nil
+// remoteShell quotes a command so the worker passes it to the server as a whole, the server's shell
+// then sees the command exactly as it was built
+func (r *SSHRuner) remoteShell(confing *SSHRunerConfig, command string) string {
+	if confing.Direct {
+		return command
+	}
+	return ShellQuote(command)
 }
 
 func (r *SSHRuner) createCommand(confing *SSHRunerConfig, command string) string {
-	// Simple command generation without excessive escaping - matching working manual command
-	if confing.SSHKey != nil {
-		return fmt.Sprintf("sshpass -p %s ssh -o StrictHostKeyChecking=no -i %s %s@%s %s",
-			confing.Password, *confing.SSHKey, confing.User, confing.IP, command)
+	if confing.Direct {
+		return command
+	}
+	port := confing.Port
+	if port == 0 {
+		port = 22
+	}
+	destination := ShellQuote(confing.User + "@" + confing.IP)
+	// The password and the private key arrive on stdin, see WorkerInput. Both lines are read even when empty,
+	// so the rest of stdin reaches ssh. The files below are unique to the command and removed when it exits
+	prefix := "IFS= read -r ssh_password_data && IFS= read -r ssh_key_data && " +
+		`trap 'rm -f "$known_hosts" "$ssh_key"' EXIT && `
+	// Host keys are always checked, the pinned key is written to a known_hosts file of its own
+	options := "-o StrictHostKeyChecking=yes"
+	if confing.KnownHosts != nil {
+		prefix += fmt.Sprintf(`known_hosts=$(mktemp) && printf '%%s\n' %s > "$known_hosts" && `, ShellQuote(*confing.KnownHosts))
+		options += ` -o UserKnownHostsFile="$known_hosts"`
 	}
-	return fmt.Sprintf("sshpass -p %s ssh -o StrictHostKeyChecking=no %s@%s %s",
-		confing.Password, confing.User, confing.IP, command)
+	// The key only lives in a file readable by the worker user while ssh runs
+	if confing.SSHKey != nil && *confing.SSHKey != "" {
+		prefix += `ssh_key=$(umask 077 && mktemp) && printf '%s' "$ssh_key_data" | base64 -d > "$ssh_key" && `
+		options += ` -o IdentitiesOnly=yes -i "$ssh_key"`
+	}
+	// Key-only servers log in without sshpass, BatchMode keeps ssh from waiting for a password prompt
+	ssh := fmt.Sprintf("ssh -o BatchMode=yes %s -p %d %s", options, port, destination)
+	if confing.Password != "" {
+		// sshpass -e reads the password from SSHPASS, which is only set inside this shell
+		prefix += `SSHPASS=$(printf '%s' "$ssh_password_data" | base64 -d) && export SSHPASS && `
+		ssh = fmt.Sprintf("sshpass -e ssh %s -p %d %s", options, port, destination)
+	}
+	// ssh runs without a tty, so the remote command outlives a killed ssh. The remote shell records its PID
+	// and a command terminated in the worker connects once more to kill the process group of it
+	pidFile := remotePidFile()
+	prefix += fmt.Sprintf(`cancel_remote() { trap '' EXIT; (%s %s; rm -f "$known_hosts" "$ssh_key") >/dev/null 2>&1 & exit 143; } && `+
+		"trap cancel_remote TERM && ", ssh, ShellQuote(fmt.Sprintf(remoteKillScript, pidFile)))
+	return fmt.Sprintf("%s%s %s", prefix, ssh, ShellQuote(fmt.Sprintf(remoteWrapper, pidFile)+command))
+}
+
+// remoteWrapper records the PID of the remote shell in %[1]s before it evaluates the quoted command
+// appended to it. sshd starts the shell as the leader of a process group of its own
+const remoteWrapper = `(set -C && echo $$ > %[1]s) || exit 1; trap 'rm -f %[1]s' EXIT; eval `
+
+// remoteKillScript terminates the process group recorded in %[1]s, TERM gives the command a chance to clean up
+const remoteKillScript = `pid=$(cat %[1]s 2>/dev/null) || exit 0
+kill -TERM -"$pid" 2>/dev/null
+sleep 2
+kill -KILL -"$pid" 2>/dev/null
+rm -f %[1]s`
+
+// remotePidFile returns a unique path on the server for the PID of a worker command
+func remotePidFile() string {
+	token := make([]byte, 8)
+	rand.Read(token)
+	return fmt.Sprintf("/tmp/deployer-run-%x.pid", token)
+}
+
+// WorkerInput returns the stdin of a command built for a deploy-worker: the base64 encoded password and
+// private key on a line each, followed by input for the remote command
+func WorkerInput(password, sshKey string, input []byte) []byte {
+	preamble := base64.StdEncoding.EncodeToString([]byte(password)) + "\n" +
+		base64.StdEncoding.EncodeToString([]byte(sshKey)) + "\n"
+	return append([]byte(preamble), input...)
 }
 
 func (r *SSHRuner) loginDockerCommand(confing *SSHRunerConfig) string {
 	command := ""
 	if confing.DockerUser != nil && confing.DockerPassword != nil {
-		command += fmt.Sprintf("docker login -u %s -p %s", *confing.DockerUser, *confing.DockerPassword)
+		command = ShellJoin([]string{"docker", "login", "-u", *confing.DockerUser, "--password-stdin"})
 	}
-	execCommand := r.createCommand(confing, command)
+	execCommand := r.createCommand(confing, r.remoteShell(confing, command))
 	return execCommand
 }
 
 func (r *SSHRuner) pullDockerCommand(confing *SSHRunerConfig) string {
 	command := ""
-	if confing.DockerImage != nil && confing.DockerRegistry != nil && confing.DockerTag != nil {
-		command += fmt.Sprintf("docker pull %s/%s:%s", *confing.DockerRegistry, *confing.DockerImage, *confing.DockerTag)
+	if confing.DockerImage != nil {
+		command = ShellJoin([]string{"docker", "pull", r.imageReference(confing)})
 	}
-	execCommand := r.createCommand(confing, command)
+	execCommand := r.createCommand(confing, r.remoteShell(confing, command))
 	return execCommand
 }
 
-func (r *SSHRuner) runDockerCommand(confing *SSHRunerConfig) string {
+func (r *SSHRuner) runDockerCommand(confing *SSHRunerConfig) (string, error) {
 	command := ""
-	envCommand := ""
-	if confing.SetSecretsToContainer != nil && *confing.SetSecretsToContainer {
-		envCommand = r.createEnvContainerCommand(confing)
+	if confing.DockerImage != nil && confing.DockerContainerName != nil {
+		var err error
+		command, err = r.dockerRunCommand(confing, *confing.DockerContainerName, false)
+		if err != nil {
+			return "", err
+		}
+		command = r.removeEnvFileAfter(confing, command)
+	}
+	execCommand := r.createCommand(confing, r.remoteShell(confing, command))
+	return execCommand, nil
+}
+
+// dockerRunCommand builds docker run for the container name with every option quoted, a candidate is
+// started without ports and static IP
+func (r *SSHRuner) dockerRunCommand(confing *SSHRunerConfig, name string, candidate bool) (string, error) {
+	options := DockerRunOptions{}
+	if confing.DockerRunOptions != nil {
+		options = *confing.DockerRunOptions
 	}
-	if confing.DockerImage != nil && confing.DockerRegistry != nil && confing.DockerTag != nil {
-		command += fmt.Sprintf("docker run -d --name %s %s --network gateway_network --ip 172.30.0.20 %s/%s:%s",
-			*confing.DockerContainerName, envCommand, *confing.DockerRegistry, *confing.DockerImage, *confing.DockerTag)
+	envOptions, err := r.envContainerOptions(confing)
+	if err != nil {
+		return "", err
 	}
-	execCommand := r.createCommand(confing, command)
-	return execCommand
+	args := append(options.Flags(candidate), r.imageReference(confing))
+	args = append(args, options.Command...)
+	return fmt.Sprintf("docker run -d --name %s %s%s", ShellQuote(name), envOptions, ShellJoin(args)), nil
 }
 
-func (r *SSHRuner) createEnvContainerCommand(confing *SSHRunerConfig) string {
-	command := ""
-	if confing.Env != nil {
-		for key, value := range *confing.Env {
-			command += fmt.Sprintf("-e %s=%s ", key, value)
-		}
+// imageReference builds registry/image:tag, skipping the registry when it is empty and defaulting the tag to latest
+func (r *SSHRuner) imageReference(confing *SSHRunerConfig) string {
+	image := *confing.DockerImage
+	if confing.DockerRegistry != nil && *confing.DockerRegistry != "" {
+		image = fmt.Sprintf("%s/%s", *confing.DockerRegistry, image)
 	}
-	return command
+	tag := "latest"
+	if confing.DockerTag != nil && *confing.DockerTag != "" {
+		tag = *confing.DockerTag
+	}
+	return fmt.Sprintf("%s:%s", image, tag)
 }
 
-func (r *SSHRuner) loadEnvToScript(confing *SSHRunerConfig) string {
-	command := ""
-	if confing.Env != nil {
-		for key, value := range *confing.Env {
-			command += fmt.Sprintf("export %s=%s\n", key, value)
+// envContainerOptions returns the --env-file or -e options of the secrets when they are passed to the container
+func (r *SSHRuner) envContainerOptions(confing *SSHRunerConfig) (string, error) {
+	if confing.SetSecretsToContainer == nil || !*confing.SetSecretsToContainer {
+		return "", nil
+	}
+	if confing.EnvFile != nil {
+		return fmt.Sprintf("--env-file %s ", EnvFilePath(*confing.EnvFile)), nil
+	}
+	return r.createEnvContainerCommand(confing)
+}
+
+// removeEnvFileAfter removes the env file once docker run read it, keeping the exit code of the command
+func (r *SSHRuner) removeEnvFileAfter(confing *SSHRunerConfig, command string) string {
+	if confing.EnvFile == nil || confing.SetSecretsToContainer == nil || !*confing.SetSecretsToContainer {
+		return command
+	}
+	return fmt.Sprintf("%s; code=$?; rm -f %s; exit $code", command, EnvFilePath(*confing.EnvFile))
+}
+
+// createEnvContainerCommand passes every variable as one quoted -e KEY=VALUE word
+func (r *SSHRuner) createEnvContainerCommand(confing *SSHRunerConfig) (string, error) {
+	keys, err := r.envKeys(confing, validContainerEnvName)
+	if err != nil {
+		return "", err
+	}
+	var command strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&command, "-e %s ", ShellQuote(key+"="+(*confing.Env)[key]))
+	}
+	return command.String(), nil
+}
+
+// loadEnvToScript exports every variable with its value quoted, nothing inside a value is expanded
+func (r *SSHRuner) loadEnvToScript(confing *SSHRunerConfig) (string, error) {
+	keys, err := r.envKeys(confing, ValidEnvName)
+	if err != nil {
+		return "", err
+	}
+	var command strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&command, "export %s=%s\n", key, ShellQuote((*confing.Env)[key]))
+	}
+	return command.String(), nil
+}
+
+// validContainerEnvName accepts what docker takes as a name, anything up to the first =
+func validContainerEnvName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "=\r\n")
+}
+
+// envKeys returns the sorted names of Env so generated commands are stable, a name that is not valid is
+// an error rather than something to quote
+func (r *SSHRuner) envKeys(confing *SSHRunerConfig, valid func(name string) bool) ([]string, error) {
+	if confing.Env == nil {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(*confing.Env))
+	for key := range *confing.Env {
+		if !valid(key) {
+			return nil, fmt.Errorf("invalid environment variable name %q", key)
 		}
+		keys = append(keys, key)
 	}
-	return command
+	sort.Strings(keys)
+	return keys, nil
 }
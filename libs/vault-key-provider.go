@@ -0,0 +1,171 @@
+package libs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultKeyProvider seals the data keys with a key of the HashiCorp Vault transit engine, the master key
+// never leaves Vault. Keys of the local key ring stay readable so existing values can be re-encrypted
+type VaultKeyProvider struct {
+	config VaultKeyProviderConfig
+	client *http.Client
+	// legacy holds the local keys, it has no active key
+	legacy *KeyRing
+}
+
+type VaultKeyProviderConfig struct {
+	// Address is the Vault URL, e.g. http://127.0.0.1:8200
+	Address   string
+	Token     string
+	Namespace string
+	// Mount is the path the transit engine is mounted at, transit by default
+	Mount string
+	// KeyName is the transit key, it is also the key id stored in the encrypted values
+	KeyName string
+	Timeout time.Duration
+	// LegacyKeys are local keys by id that values written before Vault was used are opened with
+	LegacyKeys map[string][]byte
+}
+
+func NewVaultKeyProvider(config VaultKeyProviderConfig) (*VaultKeyProvider, error) {
+	if config.Address == "" || config.Token == "" {
+		return nil, fmt.Errorf("vault address and token are required")
+	}
+	if config.Mount == "" {
+		config.Mount = "transit"
+	}
+	if !keyIDPattern.MatchString(config.KeyName) {
+		return nil, fmt.Errorf("invalid vault transit key name %q, use letters, digits, - and _", config.KeyName)
+	}
+	if _, exists := config.LegacyKeys[config.KeyName]; exists {
+		return nil, fmt.Errorf("vault transit key %q has the id of a local key", config.KeyName)
+	}
+	for id, key := range config.LegacyKeys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid key length of %s: got %d, want 32", id, len(key))
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	return &VaultKeyProvider{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		legacy: &KeyRing{keys: config.LegacyKeys},
+	}, nil
+}
+
+// LoadVaultKeyProvider configures the provider with VAULT_ADDR, VAULT_TOKEN, the optional VAULT_NAMESPACE,
+// VAULT_TRANSIT_MOUNT (transit by default) and VAULT_TRANSIT_KEY (deployer by default). The keys of
+// ENCRYPTION_KEY and ENCRYPTION_KEYS, when set, still open older values
+func LoadVaultKeyProvider() (*VaultKeyProvider, error) {
+	keys, err := envKeys()
+	if err != nil {
+		return nil, err
+	}
+	keyName := os.Getenv("VAULT_TRANSIT_KEY")
+	if keyName == "" {
+		keyName = "deployer"
+	}
+	return NewVaultKeyProvider(VaultKeyProviderConfig{
+		Address:    os.Getenv("VAULT_ADDR"),
+		Token:      os.Getenv("VAULT_TOKEN"),
+		Namespace:  os.Getenv("VAULT_NAMESPACE"),
+		Mount:      os.Getenv("VAULT_TRANSIT_MOUNT"),
+		KeyName:    keyName,
+		LegacyKeys: keys,
+	})
+}
+
+func (p *VaultKeyProvider) ActiveKeyID() string {
+	return p.config.KeyName
+}
+
+func (p *VaultKeyProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	var response struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := p.transit("encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &response); err != nil {
+		return "", nil, err
+	}
+	// The ciphertext is stored as is, e.g. vault:v1:..., Vault knows the key version it was sealed with
+	return p.config.KeyName, []byte(response.Ciphertext), nil
+}
+
+func (p *VaultKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != p.config.KeyName {
+		return p.legacy.UnwrapKey(keyID, wrapped)
+	}
+	var response struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := p.transit("decrypt", map[string]string{"ciphertext": string(wrapped)}, &response); err != nil {
+		return nil, err
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(response.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data key from vault: %w", err)
+	}
+	return dataKey, nil
+}
+
+func (p *VaultKeyProvider) LegacyKey() ([]byte, error) {
+	return p.legacy.LegacyKey()
+}
+
+// transit calls an operation of the transit engine on the key and decodes the data of the response
+func (p *VaultKeyProvider) transit(operation string, request map[string]string, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/v1/%s/%s/%s", p.config.Address, strings.Trim(p.config.Mount, "/"), operation, url.PathEscape(p.config.KeyName))
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.config.Token)
+	if p.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.config.Namespace)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach vault: %w", err)
+	}
+	defer res.Body.Close()
+	payload, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read vault response: %w", err)
+	}
+
+	var envelope struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil && res.StatusCode < 300 {
+		return fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	if res.StatusCode >= 300 || len(envelope.Errors) > 0 {
+		return fmt.Errorf("vault transit %s failed with status %d: %s", operation, res.StatusCode, strings.Join(envelope.Errors, "; "))
+	}
+	if err := json.Unmarshal(envelope.Data, response); err != nil {
+		return fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package guards
+
+import (
+	"deployer.com/libs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// JwtStreamGuard works like JwtGuard but also accepts the access token in the "token" query parameter,
+// because browsers cannot set headers on EventSource connections
+func JwtStreamGuard(ctx *fiber.Ctx) error {
+	token := ctx.Query("token")
+	if token == "" {
+		return JwtGuard(ctx)
+	}
+	claims, err := libs.ParseAccessToken(token)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthorized",
+			"error":   err.Error(),
+		})
+	}
+	ctx.Locals("user", claims)
+	return ctx.Next()
+}
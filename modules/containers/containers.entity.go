@@ -1,6 +1,7 @@
 package containers
 
 import (
+	"deployer.com/libs"
 	"deployer.com/modules/users"
 	"gorm.io/gorm"
 )
@@ -21,4 +22,18 @@ type Container struct {
 	// CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	// UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	// DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// RunOptions become the docker run flags of the container
+	RunOptions libs.DockerRunOptions `gorm:"type:jsonb;serializer:json;not null;default:'{}'" json:"run_options"`
+}
+
+// legacyRunOptions are the network and address every container was started with before run options existed
+const legacyRunOptions = `{"network":"gateway_network","ip":"172.30.0.20"}`
+
+// BackfillRunOptions gives the containers stored before run options existed the legacy network and address.
+// It is meant for the moment the column is added, afterwards empty options are a choice of the user
+func BackfillRunOptions(db *gorm.DB) error {
+	return db.Unscoped().Model(&Container{}).
+		Where("run_options = '{}'").
+		Update("run_options", gorm.Expr("?::jsonb", legacyRunOptions)).Error
 }
@@ -14,17 +14,18 @@ type ContainersService struct {
 }
 
 type ContainerResponse struct {
-	ID        uint      `json:"id"`
-	Name      string    `json:"name"`
-	Registry  string    `json:"registry"`
-	Image     string    `json:"image"`
-	Tag       string    `json:"tag"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password"`
-	SecretKey string    `json:"secret_key"`
-	Params    string    `json:"params"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uint                  `json:"id"`
+	Name       string                `json:"name"`
+	Registry   string                `json:"registry"`
+	Image      string                `json:"image"`
+	Tag        string                `json:"tag"`
+	Username   string                `json:"username"`
+	Password   string                `json:"password"`
+	SecretKey  string                `json:"secret_key"`
+	Params     string                `json:"params"`
+	RunOptions libs.DockerRunOptions `json:"run_options"`
+	CreatedAt  time.Time             `json:"created_at"`
+	UpdatedAt  time.Time             `json:"updated_at"`
 }
 
 func NewContainersService(db *gorm.DB) *ContainersService {
@@ -33,7 +34,7 @@ func NewContainersService(db *gorm.DB) *ContainersService {
 
 func (s *ContainersService) GetContainers(userId uint, iv string) ([]ContainerResponse, error) {
 	var containers []Container
-	if err := s.db.Where("user_id = ?", userId).Select("id, name, registry, image, tag, username, password, secret_key, params, created_at").Order("created_at DESC").Find(&containers).Error; err != nil {
+	if err := s.db.Where("user_id = ?", userId).Select("id, name, registry, image, tag, username, password, secret_key, params, run_options, created_at").Order("created_at DESC").Find(&containers).Error; err != nil {
 		return nil, err
 	}
 	result := make([]ContainerResponse, len(containers))
@@ -47,17 +48,18 @@ func (s *ContainersService) GetContainers(userId uint, iv string) ([]ContainerRe
 			return nil, err
 		}
 		result[i] = ContainerResponse{
-			ID:        container.ID,
-			Name:      container.Name,
-			Registry:  container.Registry,
-			Image:     container.Image,
-			Tag:       container.Tag,
-			Username:  container.Username,
-			Password:  decodedPassword,
-			SecretKey: decodedSecretKey,
-			Params:    container.Params,
-			CreatedAt: container.CreatedAt,
-			UpdatedAt: container.UpdatedAt,
+			ID:         container.ID,
+			Name:       container.Name,
+			Registry:   container.Registry,
+			Image:      container.Image,
+			Tag:        container.Tag,
+			Username:   container.Username,
+			Password:   decodedPassword,
+			SecretKey:  decodedSecretKey,
+			Params:     container.Params,
+			RunOptions: container.RunOptions,
+			CreatedAt:  container.CreatedAt,
+			UpdatedAt:  container.UpdatedAt,
 		}
 	}
 	return result, nil
@@ -77,17 +79,18 @@ func (s *ContainersService) GetContainer(id, userId uint, iv string) (ContainerR
 		return ContainerResponse{}, err
 	}
 	return ContainerResponse{
-		ID:        container.ID,
-		Name:      container.Name,
-		Registry:  container.Registry,
-		Image:     container.Image,
-		Tag:       container.Tag,
-		Username:  container.Username,
-		Password:  decodedPassword,
-		SecretKey: decodedSecretKey,
-		Params:    container.Params,
-		CreatedAt: container.CreatedAt,
-		UpdatedAt: container.UpdatedAt,
+		ID:         container.ID,
+		Name:       container.Name,
+		Registry:   container.Registry,
+		Image:      container.Image,
+		Tag:        container.Tag,
+		Username:   container.Username,
+		Password:   decodedPassword,
+		SecretKey:  decodedSecretKey,
+		Params:     container.Params,
+		RunOptions: container.RunOptions,
+		CreatedAt:  container.CreatedAt,
+		UpdatedAt:  container.UpdatedAt,
 	}, nil
 }
 
@@ -111,21 +114,25 @@ func (s *ContainersService) CreateContainer(userId uint, dto dto.CreateContainer
 		Params:    dto.Params,
 		UserID:    userId,
 	}
+	if dto.RunOptions != nil {
+		container.RunOptions = *dto.RunOptions
+	}
 	if err := s.db.Create(&container).Error; err != nil {
 		return ContainerResponse{}, err
 	}
 	return ContainerResponse{
-		ID:        container.ID,
-		Name:      container.Name,
-		Registry:  container.Registry,
-		Image:     container.Image,
-		Tag:       container.Tag,
-		Username:  container.Username,
-		Password:  dto.Password,
-		SecretKey: encryptedSecretKey,
-		Params:    dto.Params,
-		CreatedAt: container.CreatedAt,
-		UpdatedAt: container.UpdatedAt,
+		ID:         container.ID,
+		Name:       container.Name,
+		Registry:   container.Registry,
+		Image:      container.Image,
+		Tag:        container.Tag,
+		Username:   container.Username,
+		Password:   dto.Password,
+		SecretKey:  encryptedSecretKey,
+		Params:     dto.Params,
+		RunOptions: container.RunOptions,
+		CreatedAt:  container.CreatedAt,
+		UpdatedAt:  container.UpdatedAt,
 	}, nil
 }
 
@@ -135,6 +142,9 @@ func (s *ContainersService) UpdateContainer(id, userId uint, updates map[string]
 		return ContainerResponse{}, err
 	}
 	libs.SetStructFieldsFromMap(&container, updates)
+	if options, ok := updates["run_options"].(libs.DockerRunOptions); ok {
+		container.RunOptions = options
+	}
 	if updates["password"] != nil {
 		encrypted, err := s.encryptionService.Encrypt(container.Password, iv)
 		if err != nil {
@@ -161,17 +171,18 @@ func (s *ContainersService) UpdateContainer(id, userId uint, updates map[string]
 		return ContainerResponse{}, err
 	}
 	return ContainerResponse{
-		ID:        container.ID,
-		Name:      container.Name,
-		Registry:  container.Registry,
-		Image:     container.Image,
-		Tag:       container.Tag,
-		Username:  container.Username,
-		Password:  decodedPassword,
-		SecretKey: decodedSecretKey,
-		Params:    container.Params,
-		CreatedAt: container.CreatedAt,
-		UpdatedAt: container.UpdatedAt,
+		ID:         container.ID,
+		Name:       container.Name,
+		Registry:   container.Registry,
+		Image:      container.Image,
+		Tag:        container.Tag,
+		Username:   container.Username,
+		Password:   decodedPassword,
+		SecretKey:  decodedSecretKey,
+		Params:     container.Params,
+		RunOptions: container.RunOptions,
+		CreatedAt:  container.CreatedAt,
+		UpdatedAt:  container.UpdatedAt,
 	}, nil
 }
 
@@ -1,6 +1,9 @@
 package dto
 
-import "github.com/go-playground/validator/v10"
+import (
+	"deployer.com/libs"
+	"github.com/go-playground/validator/v10"
+)
 
 type CreateContainerDto struct {
 	Name      string `json:"name" validate:"required,min=1,max=255"`
@@ -11,9 +14,17 @@ type CreateContainerDto struct {
 	Password  string `json:"password" validate:"omitempty,min=0,max=255"`
 	SecretKey string `json:"secret_key" validate:"omitempty,min=0,max=255"`
 	Params    string `json:"params" validate:"omitempty,min=0,max=10000"`
+	// RunOptions are the ports, volumes, network, limits and other docker run flags of the container
+	RunOptions *libs.DockerRunOptions `json:"run_options"`
 }
 
 func ValidateCreateContainerDto(dto CreateContainerDto) error {
 	validate := validator.New()
-	return validate.Struct(dto)
+	if err := validate.Struct(dto); err != nil {
+		return err
+	}
+	if dto.RunOptions != nil {
+		return dto.RunOptions.Validate()
+	}
+	return nil
 }
@@ -1,6 +1,9 @@
 package dto
 
-import "github.com/go-playground/validator/v10"
+import (
+	"deployer.com/libs"
+	"github.com/go-playground/validator/v10"
+)
 
 type UpdateContainerDto struct {
 	Name      *string `json:"name" validate:"omitempty,min=1,max=255"`
@@ -11,6 +14,8 @@ type UpdateContainerDto struct {
 	Password  *string `json:"password" validate:"omitempty,min=0,max=255"`
 	SecretKey *string `json:"secret_key" validate:"omitempty,min=0,max=255"`
 	Params    *string `json:"params" validate:"omitempty,min=0,max=10000"`
+	// RunOptions replace the stored options as a whole
+	RunOptions *libs.DockerRunOptions `json:"run_options"`
 }
 
 func (dto *UpdateContainerDto) GetUpdates() (map[string]interface{}, []string) {
@@ -48,6 +53,10 @@ func (dto *UpdateContainerDto) GetUpdates() (map[string]interface{}, []string) {
 		updates["params"] = *dto.Params
 		fields = append(fields, "params")
 	}
+	if dto.RunOptions != nil {
+		updates["run_options"] = *dto.RunOptions
+		fields = append(fields, "run_options")
+	}
 
 	return updates, fields
 }
@@ -59,5 +68,11 @@ func (dto UpdateContainerDto) HasUpdates() bool {
 
 func ValidateUpdateContainerDto(dto UpdateContainerDto) error {
 	validate := validator.New()
-	return validate.Struct(dto)
+	if err := validate.Struct(dto); err != nil {
+		return err
+	}
+	if dto.RunOptions != nil {
+		return dto.RunOptions.Validate()
+	}
+	return nil
 }
@@ -0,0 +1,54 @@
+package deployments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GetDeployedContainers returns the container tags the deployment last put on its servers
+func (s *DeploymentsService) GetDeployedContainers(id, userId uint) ([]DeployedContainer, error) {
+	var deployment Deployment
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&deployment).Error; err != nil {
+		return nil, err
+	}
+
+	var deployed []DeployedContainer
+	if err := s.db.Where("deployment_id = ?", id).Order("server_id, container_id").Find(&deployed).Error; err != nil {
+		return nil, fmt.Errorf("failed to get deployed containers: %w", err)
+	}
+	return deployed, nil
+}
+
+// GetDeployedContainer returns the tag of a container running on a server, gorm.ErrRecordNotFound when it
+// was never deployed there
+func (s *DeploymentsService) GetDeployedContainer(serverId, containerId uint) (DeployedContainer, error) {
+	var deployed DeployedContainer
+	if err := s.db.Where("server_id = ? AND container_id = ?", serverId, containerId).First(&deployed).Error; err != nil {
+		return DeployedContainer{}, err
+	}
+	return deployed, nil
+}
+
+// RecordDeployedContainer stores the tag now running on the server, the tag it replaced becomes the rollback target
+func (s *DeploymentsService) RecordDeployedContainer(deploymentId, serverId, containerId uint, tag string) error {
+	deployed, err := s.GetDeployedContainer(serverId, containerId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to get deployed container: %w", err)
+	}
+	// Redeploying the same tag keeps the older rollback target
+	if deployed.ID != 0 && deployed.Tag != tag {
+		deployed.PreviousTag = deployed.Tag
+	}
+	deployed.ServerID = serverId
+	deployed.ContainerID = containerId
+	deployed.DeploymentID = deploymentId
+	deployed.Tag = tag
+	deployed.DeployedAt = time.Now()
+	if err := s.db.Save(&deployed).Error; err != nil {
+		return fmt.Errorf("failed to record deployed container: %w", err)
+	}
+	return nil
+}
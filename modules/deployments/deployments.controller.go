@@ -22,6 +22,9 @@ func NewDeploymentsController(router *fiber.Router, deploymentsService *Deployme
 func (c *DeploymentsController) RegisterRoutes(router *fiber.Router) {
 	(*c.router).Get("/", guards.JwtGuard, c.GetDeployments)
 	(*c.router).Get("/:id", guards.JwtGuard, c.GetDeployment)
+	(*c.router).Get("/:id/containers", guards.JwtGuard, c.GetDeployedContainers)
+	(*c.router).Get("/:id/revisions", guards.JwtGuard, c.GetRevisions)
+	(*c.router).Get("/:id/revisions/:revision", guards.JwtGuard, c.GetRevision)
 	(*c.router).Post("/", guards.JwtGuard, c.CreateDeployment)
 	(*c.router).Patch("/:id", guards.JwtGuard, c.UpdateDeployment)
 	(*c.router).Delete("/:id", guards.JwtGuard, c.DeleteDeployment)
@@ -75,6 +78,86 @@ func (c *DeploymentsController) GetDeployment(ctx *fiber.Ctx) error {
 	return ctx.Status(fiber.StatusOK).JSON(deployment)
 }
 
+func (c *DeploymentsController) GetDeployedContainers(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid deployment ID format",
+		})
+	}
+
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+
+	deployed, err := c.deploymentsService.GetDeployedContainers(uint(id), uint(userClaims.UserID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Deployment not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve deployed containers",
+			"details": err.Error(),
+		})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(deployed)
+}
+
+func (c *DeploymentsController) GetRevisions(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid deployment ID format",
+		})
+	}
+
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+
+	revisions, err := c.deploymentsService.GetRevisions(uint(id), uint(userClaims.UserID))
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve revisions",
+			"details": err.Error(),
+		})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(revisions)
+}
+
+func (c *DeploymentsController) GetRevision(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid deployment ID format",
+		})
+	}
+	number, err := strconv.Atoi(ctx.Params("revision"))
+	if err != nil || number < 1 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid revision format",
+		})
+	}
+
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+
+	revision, err := c.deploymentsService.GetRevision(uint(id), uint(userClaims.UserID), number)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Revision not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve revision",
+			"details": err.Error(),
+		})
+	}
+	revision.Snapshot = revision.Snapshot.WithoutContents()
+
+	return ctx.Status(fiber.StatusOK).JSON(revision)
+}
+
 func (c *DeploymentsController) CreateDeployment(ctx *fiber.Ctx) error {
 	userClaims := ctx.Locals("user").(*libs.UserClaims)
 
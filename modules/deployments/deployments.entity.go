@@ -3,11 +3,13 @@ package deployments
 import (
 	"time"
 
+	"deployer.com/libs"
 	"deployer.com/modules/containers"
 	"deployer.com/modules/domains"
 	"deployer.com/modules/scripts"
 	"deployer.com/modules/secrets"
 	"deployer.com/modules/servers"
+	"deployer.com/modules/stacks"
 	"deployer.com/modules/users"
 	"gorm.io/gorm"
 )
@@ -20,9 +22,19 @@ const (
 	DeploymentStatusSuccess  DeploymentStatus = "success"
 	DeploymentStatusFailed   DeploymentStatus = "failed"
 	DeploymentStatusCanceled DeploymentStatus = "canceled"
+	DeploymentStatusTimedOut DeploymentStatus = "timed_out"
 	DeploymentStatusSkipped  DeploymentStatus = "skipped"
 )
 
+type DeploymentStrategy string
+
+const (
+	// DeploymentStrategySequential deploys one server after the other and stops on the first failure
+	DeploymentStrategySequential DeploymentStrategy = "sequential"
+	// DeploymentStrategyRolling deploys the servers in batches and halts when a batch fails
+	DeploymentStrategyRolling DeploymentStrategy = "rolling"
+)
+
 type Deployment struct {
 	gorm.Model
 	Name   string     `gorm:"not null;index" json:"name"`
@@ -36,6 +48,8 @@ type Deployment struct {
 	Servers    []servers.Server       `gorm:"many2many:deployment_servers;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"servers"`
 	Scripts    []scripts.Script       `gorm:"many2many:deployment_scripts;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"scripts"`
 	Secrets    []secrets.Secret       `gorm:"many2many:deployment_secrets;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"secrets"`
+	// Stacks are docker compose stacks brought up next to the containers when RunContainers is set
+	Stacks []stacks.Stack `gorm:"many2many:deployment_stacks;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"stacks"`
 
 	Status                DeploymentStatus `gorm:"not null" json:"status"`
 	LastRunAt             *time.Time       `gorm:"index;default:null" json:"last_run_at"`
@@ -46,4 +60,115 @@ type Deployment struct {
 	SetSecretsToServer    bool             `gorm:"not null;default:false" json:"set_secrets_to_server"`
 	SetSecretsToContainer bool             `gorm:"not null;default:false" json:"set_secrets_to_container"`
 	RunScripts            bool             `gorm:"not null;default:false" json:"run_script"`
+	// Timeout bounds a whole run of the deployment in seconds, 0 uses the default of the executor
+	Timeout int `gorm:"not null;default:0" json:"timeout"`
+
+	Strategy DeploymentStrategy `gorm:"not null;default:'sequential'" json:"strategy"`
+	// BatchSize is the number of servers a rolling deployment updates at once,
+	// BatchPercent a share of the servers used when BatchSize is 0
+	BatchSize    int `gorm:"not null;default:0" json:"batch_size"`
+	BatchPercent int `gorm:"not null;default:0" json:"batch_percent"`
+	// HealthCheck is a command run on every server of a batch, the next batch starts once it passed everywhere
+	HealthCheck string `gorm:"type:text;not null;default:''" json:"health_check"`
+	// HealthCheckTimeout is how long in seconds the health check is retried, 0 uses the default
+	HealthCheckTimeout int `gorm:"not null;default:0" json:"health_check_timeout"`
+
+	// BlueGreen starts the new container next to the old one and only swaps them once HealthProbe passed
+	BlueGreen bool `gorm:"not null;default:false" json:"blue_green"`
+	// HealthProbe is a command run on the server with $CONTAINER set to the new container, empty waits
+	// until the container is running or healthy
+	HealthProbe        string `gorm:"type:text;not null;default:''" json:"health_probe"`
+	HealthProbeTimeout int    `gorm:"not null;default:0" json:"health_probe_timeout"`
+}
+
+// DeployedContainer records the tag of a container running on a server, PreviousTag is the rollback target
+type DeployedContainer struct {
+	gorm.Model
+	ServerID     uint      `gorm:"not null;uniqueIndex:idx_deployed_containers_server_container" json:"server_id"`
+	ContainerID  uint      `gorm:"not null;uniqueIndex:idx_deployed_containers_server_container" json:"container_id"`
+	DeploymentID uint      `gorm:"not null;index" json:"deployment_id"`
+	Tag          string    `gorm:"not null" json:"tag"`
+	PreviousTag  string    `gorm:"not null;default:''" json:"previous_tag"`
+	DeployedAt   time.Time `gorm:"not null" json:"deployed_at"`
+}
+
+// DeploymentRevision is the immutable snapshot of a deployment taken when it runs, only the status follows the run
+type DeploymentRevision struct {
+	gorm.Model
+	DeploymentID uint `gorm:"not null;uniqueIndex:idx_deployment_revisions_revision" json:"deployment_id"`
+	Revision     int  `gorm:"not null;uniqueIndex:idx_deployment_revisions_revision" json:"revision"`
+	UserID       uint `gorm:"not null;index" json:"user_id"`
+	RunID        uint `gorm:"not null;index" json:"run_id"`
+	// RolledBackFrom is the revision this one re-ran, nil for regular runs
+	RolledBackFrom *int             `gorm:"default:null" json:"rolled_back_from"`
+	Status         DeploymentStatus `gorm:"not null" json:"status"`
+	Snapshot       RevisionSnapshot `gorm:"type:jsonb;serializer:json;not null" json:"snapshot"`
+}
+
+// RevisionSnapshot holds the settings and the resolved resources of a deployment at the time of a run
+type RevisionSnapshot struct {
+	Name                  string             `json:"name"`
+	SetUpDomains          bool               `json:"setup_domains"`
+	PoolContainers        bool               `json:"pool_containers"`
+	RunContainers         bool               `json:"run_containers"`
+	SetUpServers          bool               `json:"setup_servers"`
+	SetSecretsToServer    bool               `json:"set_secrets_to_server"`
+	SetSecretsToContainer bool               `json:"set_secrets_to_container"`
+	RunScripts            bool               `json:"run_scripts"`
+	Timeout               int                `json:"timeout"`
+	Strategy              DeploymentStrategy `json:"strategy"`
+	BatchSize             int                `json:"batch_size"`
+	BatchPercent          int                `json:"batch_percent"`
+	HealthCheck           string             `json:"health_check"`
+	HealthCheckTimeout    int                `json:"health_check_timeout"`
+	BlueGreen             bool               `json:"blue_green"`
+	HealthProbe           string             `json:"health_probe"`
+	HealthProbeTimeout    int                `json:"health_probe_timeout"`
+
+	ServerIDs  []uint              `json:"server_ids"`
+	DomainIDs  []uint              `json:"domain_ids"`
+	SubDomains []RevisionSubDomain `json:"sub_domains"`
+	Containers []RevisionContainer `json:"containers"`
+	Scripts    []RevisionScript    `json:"scripts"`
+	Secrets    []RevisionSecret    `json:"secrets"`
+	Stacks     []RevisionStack     `json:"stacks"`
+}
+
+type RevisionSubDomain struct {
+	ID       uint `json:"id"`
+	DomainID uint `json:"domain_id"`
+}
+
+type RevisionContainer struct {
+	ID         uint                  `json:"id"`
+	Name       string                `json:"name"`
+	Registry   string                `json:"registry"`
+	Image      string                `json:"image"`
+	Tag        string                `json:"tag"`
+	RunOptions libs.DockerRunOptions `json:"run_options"`
+}
+
+type RevisionScript struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	// Script is the content encrypted like Script.Script, it is never returned by the API
+	Script  string `json:"script,omitempty"`
+	Timeout int    `json:"timeout"`
+}
+
+type RevisionStack struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	// Compose is the compose file before interpolation encrypted like Stack.Compose, it is never returned by the API
+	Compose   string `json:"compose,omitempty"`
+	SecretIDs []uint `json:"secret_ids"`
+}
+
+// RevisionSecret identifies the secret content used by a run, a rollback deploys Version again
+type RevisionSecret struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	// UpdatedAt is when the secret was last changed, revisions taken before secrets had versions only have this
+	UpdatedAt time.Time `json:"updated_at"`
 }
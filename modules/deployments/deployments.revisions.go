@@ -0,0 +1,198 @@
+package deployments
+
+import (
+	"fmt"
+
+	"deployer.com/modules/containers"
+	"deployer.com/modules/domains"
+	"deployer.com/modules/secrets"
+	"deployer.com/modules/servers"
+	"deployer.com/modules/stacks"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewRevisionSnapshot captures the settings and resource IDs of a deployment, the execute module adds the
+// resolved containers, scripts and stacks
+func NewRevisionSnapshot(deployment Deployment) RevisionSnapshot {
+	snapshot := RevisionSnapshot{
+		Name:                  deployment.Name,
+		SetUpDomains:          deployment.SetUpDomains,
+		PoolContainers:        deployment.PoolContainers,
+		RunContainers:         deployment.RunContainers,
+		SetUpServers:          deployment.SetUpServers,
+		SetSecretsToServer:    deployment.SetSecretsToServer,
+		SetSecretsToContainer: deployment.SetSecretsToContainer,
+		RunScripts:            deployment.RunScripts,
+		Timeout:               deployment.Timeout,
+		Strategy:              deployment.Strategy,
+		BatchSize:             deployment.BatchSize,
+		BatchPercent:          deployment.BatchPercent,
+		HealthCheck:           deployment.HealthCheck,
+		HealthCheckTimeout:    deployment.HealthCheckTimeout,
+		BlueGreen:             deployment.BlueGreen,
+		HealthProbe:           deployment.HealthProbe,
+		HealthProbeTimeout:    deployment.HealthProbeTimeout,
+		ServerIDs:             make([]uint, 0, len(deployment.Servers)),
+		DomainIDs:             make([]uint, 0, len(deployment.Domains)),
+		SubDomains:            make([]RevisionSubDomain, 0, len(deployment.SubDomains)),
+		Containers:            make([]RevisionContainer, 0),
+		Scripts:               make([]RevisionScript, 0),
+		Secrets:               make([]RevisionSecret, 0, len(deployment.Secrets)),
+		Stacks:                make([]RevisionStack, 0),
+	}
+	for _, server := range deployment.Servers {
+		snapshot.ServerIDs = append(snapshot.ServerIDs, server.ID)
+	}
+	for _, domain := range deployment.Domains {
+		snapshot.DomainIDs = append(snapshot.DomainIDs, domain.ID)
+	}
+	for _, subDomain := range deployment.SubDomains {
+		snapshot.SubDomains = append(snapshot.SubDomains, RevisionSubDomain{ID: subDomain.ID, DomainID: subDomain.DomainID})
+	}
+	if deployment.SetSecretsToServer || deployment.SetSecretsToContainer {
+		for _, secret := range deployment.Secrets {
+			snapshot.Secrets = append(snapshot.Secrets, RevisionSecret{ID: secret.ID, Name: secret.Name, Version: secret.Version, UpdatedAt: secret.UpdatedAt})
+		}
+	}
+	return snapshot
+}
+
+// Apply configures the deployment as it was when the snapshot was taken. The relations only carry their
+// IDs and scripts are left out, they are taken from the snapshot itself like the compose files of the stacks
+func (r RevisionSnapshot) Apply(deployment *Deployment) {
+	deployment.SetUpDomains = r.SetUpDomains
+	deployment.PoolContainers = r.PoolContainers
+	deployment.RunContainers = r.RunContainers
+	deployment.SetUpServers = r.SetUpServers
+	deployment.SetSecretsToServer = r.SetSecretsToServer
+	deployment.SetSecretsToContainer = r.SetSecretsToContainer
+	deployment.RunScripts = r.RunScripts
+	deployment.Timeout = r.Timeout
+	deployment.Strategy = r.Strategy
+	deployment.BatchSize = r.BatchSize
+	deployment.BatchPercent = r.BatchPercent
+	deployment.HealthCheck = r.HealthCheck
+	deployment.HealthCheckTimeout = r.HealthCheckTimeout
+	deployment.BlueGreen = r.BlueGreen
+	deployment.HealthProbe = r.HealthProbe
+	deployment.HealthProbeTimeout = r.HealthProbeTimeout
+
+	deployment.Servers = make([]servers.Server, 0, len(r.ServerIDs))
+	for _, id := range r.ServerIDs {
+		deployment.Servers = append(deployment.Servers, servers.Server{Model: &gorm.Model{ID: id}})
+	}
+	deployment.Domains = make([]domains.Domain, 0, len(r.DomainIDs))
+	for _, id := range r.DomainIDs {
+		deployment.Domains = append(deployment.Domains, domains.Domain{Model: gorm.Model{ID: id}})
+	}
+	deployment.SubDomains = make([]domains.SubDomain, 0, len(r.SubDomains))
+	for _, subDomain := range r.SubDomains {
+		deployment.SubDomains = append(deployment.SubDomains, domains.SubDomain{Model: gorm.Model{ID: subDomain.ID}, DomainID: subDomain.DomainID})
+	}
+	deployment.Containers = make([]containers.Container, 0, len(r.Containers))
+	for _, container := range r.Containers {
+		deployment.Containers = append(deployment.Containers, containers.Container{Model: &gorm.Model{ID: container.ID}})
+	}
+	deployment.Secrets = make([]secrets.Secret, 0, len(r.Secrets))
+	for _, secret := range r.Secrets {
+		deployment.Secrets = append(deployment.Secrets, secrets.Secret{Model: &gorm.Model{ID: secret.ID}})
+	}
+	deployment.Stacks = make([]stacks.Stack, 0, len(r.Stacks))
+	for _, stack := range r.Stacks {
+		deployment.Stacks = append(deployment.Stacks, stacks.Stack{Model: gorm.Model{ID: stack.ID}, Name: stack.Name})
+	}
+	deployment.Scripts = nil
+}
+
+// CreateRevision stores a new revision numbered after the latest revision of its deployment
+func (s *DeploymentsService) CreateRevision(revision *DeploymentRevision) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Runs of the same deployment wait for each other here, so no two read the same latest revision
+		var deployment Deployment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", revision.DeploymentID).
+			First(&deployment).Error; err != nil {
+			return fmt.Errorf("failed to lock deployment: %w", err)
+		}
+		var latest int
+		if err := tx.Model(&DeploymentRevision{}).
+			Where("deployment_id = ?", revision.DeploymentID).
+			Select("COALESCE(MAX(revision), 0)").
+			Scan(&latest).Error; err != nil {
+			return fmt.Errorf("failed to get latest revision: %w", err)
+		}
+		revision.Revision = latest + 1
+		if err := tx.Create(revision).Error; err != nil {
+			return fmt.Errorf("failed to create revision: %w", err)
+		}
+		return nil
+	})
+}
+
+// SetRevisionStatus records the outcome of the run that created the revision
+func (s *DeploymentsService) SetRevisionStatus(id uint, status DeploymentStatus) error {
+	return s.db.Model(&DeploymentRevision{}).Where("id = ?", id).Update("status", status).Error
+}
+
+// GetRevisions returns the revisions of a deployment, newest first and without script and compose contents
+func (s *DeploymentsService) GetRevisions(deploymentId, userId uint) ([]DeploymentRevision, error) {
+	var revisions []DeploymentRevision
+	if err := s.db.Where("deployment_id = ? AND user_id = ?", deploymentId, userId).
+		Order("revision DESC").
+		Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get revisions: %w", err)
+	}
+	for i := range revisions {
+		revisions[i].Snapshot = revisions[i].Snapshot.WithoutContents()
+	}
+	return revisions, nil
+}
+
+// GetRevision returns a revision of a deployment including the encrypted script contents
+func (s *DeploymentsService) GetRevision(deploymentId, userId uint, revision int) (DeploymentRevision, error) {
+	var result DeploymentRevision
+	if err := s.db.Where("deployment_id = ? AND user_id = ? AND revision = ?", deploymentId, userId, revision).
+		First(&result).Error; err != nil {
+		return DeploymentRevision{}, err
+	}
+	return result, nil
+}
+
+// GetRollbackRevision returns the newest successful revision before the latest one, the target of a rollback
+// without an explicit revision
+func (s *DeploymentsService) GetRollbackRevision(deploymentId, userId uint) (DeploymentRevision, error) {
+	var latest DeploymentRevision
+	if err := s.db.Where("deployment_id = ? AND user_id = ?", deploymentId, userId).
+		Order("revision DESC").
+		First(&latest).Error; err != nil {
+		return DeploymentRevision{}, err
+	}
+
+	var result DeploymentRevision
+	if err := s.db.Where("deployment_id = ? AND user_id = ? AND status = ? AND revision < ?",
+		deploymentId, userId, DeploymentStatusSuccess, latest.Revision).
+		Order("revision DESC").
+		First(&result).Error; err != nil {
+		return DeploymentRevision{}, err
+	}
+	return result, nil
+}
+
+// WithoutContents blanks the encrypted script and compose contents before a snapshot is returned by the API
+func (r RevisionSnapshot) WithoutContents() RevisionSnapshot {
+	scripts := make([]RevisionScript, len(r.Scripts))
+	for i, script := range r.Scripts {
+		script.Script = ""
+		scripts[i] = script
+	}
+	r.Scripts = scripts
+	stackList := make([]RevisionStack, len(r.Stacks))
+	for i, stack := range r.Stacks {
+		stack.Compose = ""
+		stackList[i] = stack
+	}
+	r.Stacks = stackList
+	return r
+}
@@ -11,6 +11,7 @@ import (
 	"deployer.com/modules/scripts"
 	"deployer.com/modules/secrets"
 	"deployer.com/modules/servers"
+	"deployer.com/modules/stacks"
 	"gorm.io/gorm"
 )
 
@@ -49,6 +50,11 @@ type SecretSummary struct {
 	Name string `json:"name"`
 }
 
+type StackSummary struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
 type DeploymentResponse struct {
 	ID                    uint               `json:"id"`
 	Name                  string             `json:"name"`
@@ -58,6 +64,7 @@ type DeploymentResponse struct {
 	Servers               []ServerSummary    `json:"servers"`
 	Scripts               []ScriptSummary    `json:"scripts"`
 	Secrets               []SecretSummary    `json:"secrets"`
+	Stacks                []StackSummary     `json:"stacks"`
 	Status                DeploymentStatus   `json:"status"`
 	LastRunAt             *time.Time         `json:"last_run_at"`
 	SetUpDomains          bool               `json:"setup_domains"`
@@ -67,6 +74,15 @@ type DeploymentResponse struct {
 	SetSecretsToServer    bool               `json:"set_secrets_to_server"`
 	SetSecretsToContainer bool               `json:"set_secrets_to_container"`
 	RunScripts            bool               `json:"run_scripts"`
+	Timeout               int                `json:"timeout"`
+	Strategy              DeploymentStrategy `json:"strategy"`
+	BatchSize             int                `json:"batch_size"`
+	BatchPercent          int                `json:"batch_percent"`
+	HealthCheck           string             `json:"health_check"`
+	HealthCheckTimeout    int                `json:"health_check_timeout"`
+	BlueGreen             bool               `json:"blue_green"`
+	HealthProbe           string             `json:"health_probe"`
+	HealthProbeTimeout    int                `json:"health_probe_timeout"`
 	CreatedAt             time.Time          `json:"created_at"`
 	UpdatedAt             time.Time          `json:"updated_at"`
 }
@@ -108,6 +124,11 @@ func (s *DeploymentsService) safePreloadRelations(deployment *Deployment) {
 	if err := s.db.Model(deployment).Association("Secrets").Find(&deployment.Secrets); err != nil {
 		deployment.Secrets = []secrets.Secret{} // Set empty slice if error
 	}
+
+	// Try Stacks
+	if err := s.db.Model(deployment).Association("Stacks").Find(&deployment.Stacks); err != nil {
+		deployment.Stacks = []stacks.Stack{} // Set empty slice if error
+	}
 }
 
 // Helper function to check if a table exists
@@ -284,6 +305,14 @@ func (s *DeploymentsService) safeCreateAssociationsFromIDs(deployment *Deploymen
 		s.db.Model(deployment).Association("Secrets").Replace(secrets)
 	}
 
+	if len(dto.StackIDs) > 0 && s.tableExists("deployment_stacks") {
+		stackList, err := s.findStacks(dto.StackIDs, userId)
+		if err != nil {
+			return err
+		}
+		s.db.Model(deployment).Association("Stacks").Replace(stackList)
+	}
+
 	return nil
 }
 
@@ -392,9 +421,36 @@ func (s *DeploymentsService) safeUpdateAssociations(deployment *Deployment, upda
 		delete(updates, "Secrets")
 	}
 
+	// Stacks are only linked by id, an empty list removes them
+	if st, ok := updates["StackIDs"]; ok {
+		if stackIDs, ok := st.([]uint); ok && s.tableExists("deployment_stacks") {
+			stackList, err := s.findStacks(stackIDs, userId)
+			if err != nil {
+				return err
+			}
+			s.db.Model(deployment).Association("Stacks").Replace(stackList)
+		}
+		delete(updates, "StackIDs")
+	}
+
 	return nil
 }
 
+// findStacks loads the stacks by id and makes sure all of them belong to the user
+func (s *DeploymentsService) findStacks(ids []uint, userId uint) ([]stacks.Stack, error) {
+	stackList := make([]stacks.Stack, 0, len(ids))
+	if len(ids) == 0 {
+		return stackList, nil
+	}
+	if err := s.db.Where("id IN ? AND user_id = ?", ids, userId).Find(&stackList).Error; err != nil {
+		return nil, err
+	}
+	if len(stackList) != len(ids) {
+		return nil, fmt.Errorf("some stacks do not belong to this user or do not exist")
+	}
+	return stackList, nil
+}
+
 // Helper function to convert domain slice to summary slice
 func convertDomainsToSummary(domains []domains.Domain) []DomainSummary {
 	result := make([]DomainSummary, len(domains))
@@ -467,6 +523,18 @@ func convertSecretsToSummary(secrets []secrets.Secret) []SecretSummary {
 	return result
 }
 
+// Helper function to convert stack slice to summary slice
+func convertStacksToSummary(stacks []stacks.Stack) []StackSummary {
+	result := make([]StackSummary, len(stacks))
+	for i, stack := range stacks {
+		result[i] = StackSummary{
+			ID:   stack.ID,
+			Name: stack.Name,
+		}
+	}
+	return result
+}
+
 func (s *DeploymentsService) convertToResponse(deployment Deployment) DeploymentResponse {
 	return DeploymentResponse{
 		ID:                    deployment.ID,
@@ -479,6 +547,15 @@ func (s *DeploymentsService) convertToResponse(deployment Deployment) Deployment
 		SetSecretsToServer:    deployment.SetSecretsToServer,
 		SetSecretsToContainer: deployment.SetSecretsToContainer,
 		RunScripts:            deployment.RunScripts,
+		Timeout:               deployment.Timeout,
+		Strategy:              deployment.Strategy,
+		BatchSize:             deployment.BatchSize,
+		BatchPercent:          deployment.BatchPercent,
+		HealthCheck:           deployment.HealthCheck,
+		HealthCheckTimeout:    deployment.HealthCheckTimeout,
+		BlueGreen:             deployment.BlueGreen,
+		HealthProbe:           deployment.HealthProbe,
+		HealthProbeTimeout:    deployment.HealthProbeTimeout,
 		CreatedAt:             deployment.CreatedAt,
 		UpdatedAt:             deployment.UpdatedAt,
 		Status:                deployment.Status,
@@ -488,6 +565,7 @@ func (s *DeploymentsService) convertToResponse(deployment Deployment) Deployment
 		Servers:               convertServersToSummary(deployment.Servers),
 		Scripts:               convertScriptsToSummary(deployment.Scripts),
 		Secrets:               convertSecretsToSummary(deployment.Secrets),
+		Stacks:                convertStacksToSummary(deployment.Stacks),
 	}
 }
 
@@ -538,6 +616,18 @@ func (s *DeploymentsService) CreateDeployment(userId uint, dto dto.CreateDeploym
 		SetSecretsToServer:    dto.SetSecretsToServer,
 		SetSecretsToContainer: dto.SetSecretsToContainer,
 		RunScripts:            dto.RunScripts,
+		Timeout:               dto.Timeout,
+		Strategy:              DeploymentStrategySequential,
+		BatchSize:             dto.BatchSize,
+		BatchPercent:          dto.BatchPercent,
+		HealthCheck:           dto.HealthCheck,
+		HealthCheckTimeout:    dto.HealthCheckTimeout,
+		BlueGreen:             dto.BlueGreen,
+		HealthProbe:           dto.HealthProbe,
+		HealthProbeTimeout:    dto.HealthProbeTimeout,
+	}
+	if dto.Strategy != "" {
+		deployment.Strategy = DeploymentStrategy(dto.Strategy)
 	}
 
 	// Create the deployment first
@@ -603,3 +693,22 @@ func (s *DeploymentsService) DeleteDeployment(id, userId uint) error {
 	}
 	return nil
 }
+
+// GetDeploymentEntity returns the raw deployment with all relations loaded, used by the execute module
+func (s *DeploymentsService) GetDeploymentEntity(id, userId uint) (Deployment, error) {
+	var deployment Deployment
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&deployment).Error; err != nil {
+		return Deployment{}, err
+	}
+	s.safePreloadRelations(&deployment)
+	return deployment, nil
+}
+
+// SetDeploymentStatus updates the run status of a deployment and optionally its last run time
+func (s *DeploymentsService) SetDeploymentStatus(id uint, status DeploymentStatus, lastRunAt *time.Time) error {
+	updates := map[string]interface{}{"status": status}
+	if lastRunAt != nil {
+		updates["last_run_at"] = *lastRunAt
+	}
+	return s.db.Model(&Deployment{}).Where("id = ?", id).Updates(updates).Error
+}
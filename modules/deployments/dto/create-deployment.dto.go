@@ -20,6 +20,17 @@ type CreateDeploymentDto struct {
 	SetSecretsToServer    bool   `json:"set_secrets_to_server"`
 	SetSecretsToContainer bool   `json:"set_secrets_to_container"`
 	RunScripts            bool   `json:"run_scripts"`
+	// Timeout in seconds for a whole run, 0 uses the default
+	Timeout int `json:"timeout" validate:"omitempty,min=0,max=86400"`
+	// Strategy is sequential (default) or rolling, the batch and health check fields only apply to rolling
+	Strategy           string `json:"strategy" validate:"omitempty,oneof=sequential rolling"`
+	BatchSize          int    `json:"batch_size" validate:"omitempty,min=0,max=1000"`
+	BatchPercent       int    `json:"batch_percent" validate:"omitempty,min=0,max=100"`
+	HealthCheck        string `json:"health_check" validate:"omitempty,max=4096"`
+	HealthCheckTimeout int    `json:"health_check_timeout" validate:"omitempty,min=0,max=3600"`
+	BlueGreen          bool   `json:"blue_green"`
+	HealthProbe        string `json:"health_probe" validate:"omitempty,max=4096"`
+	HealthProbeTimeout int    `json:"health_probe_timeout" validate:"omitempty,min=0,max=3600"`
 
 	// Fixed validation tags - removed 'min=1' from complex structs
 	Domains    []domains.Domain       `json:"domains" validate:"omitempty,dive"`
@@ -36,6 +47,7 @@ type CreateDeploymentDto struct {
 	ServerIDs    []uint `json:"server_ids" validate:"omitempty,dive,min=1"` // Commented out
 	ScriptIDs    []uint `json:"script_ids" validate:"omitempty,dive,min=1"`
 	SecretIDs    []uint `json:"secret_ids" validate:"omitempty,dive,min=1"`
+	StackIDs     []uint `json:"stack_ids" validate:"omitempty,dive,min=1"`
 }
 
 func ValidateCreateDeploymentDto(dto CreateDeploymentDto) error {
@@ -55,7 +67,7 @@ func (dto *CreateDeploymentDto) UseIDsOnly() bool {
 	// Return true if any ID fields are populated, indicating client prefers ID-based approach
 	return len(dto.DomainIDs) > 0 || len(dto.SubDomainIDs) > 0 ||
 		len(dto.ContainerIDs) > 0 || len(dto.ScriptIDs) > 0 ||
-		len(dto.SecretIDs) > 0
+		len(dto.SecretIDs) > 0 || len(dto.StackIDs) > 0
 }
 
 // Helper method to check if using full objects
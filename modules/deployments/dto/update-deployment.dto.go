@@ -22,6 +22,15 @@ type UpdateDeploymentDto struct {
 	SetSecretsToServer    *bool   `json:"set_secrets_to_server" db:"SetSecretsToServer"`
 	SetSecretsToContainer *bool   `json:"set_secrets_to_container" db:"SetSecretsToContainer"`
 	RunScripts            *bool   `json:"run_scripts" db:"RunScripts"`
+	Timeout               *int    `json:"timeout" validate:"omitempty,min=0,max=86400" db:"Timeout"`
+	Strategy              *string `json:"strategy" validate:"omitempty,oneof=sequential rolling" db:"Strategy"`
+	BatchSize             *int    `json:"batch_size" validate:"omitempty,min=0,max=1000" db:"BatchSize"`
+	BatchPercent          *int    `json:"batch_percent" validate:"omitempty,min=0,max=100" db:"BatchPercent"`
+	HealthCheck           *string `json:"health_check" validate:"omitempty,max=4096" db:"HealthCheck"`
+	HealthCheckTimeout    *int    `json:"health_check_timeout" validate:"omitempty,min=0,max=3600" db:"HealthCheckTimeout"`
+	BlueGreen             *bool   `json:"blue_green" db:"BlueGreen"`
+	HealthProbe           *string `json:"health_probe" validate:"omitempty,max=4096" db:"HealthProbe"`
+	HealthProbeTimeout    *int    `json:"health_probe_timeout" validate:"omitempty,min=0,max=3600" db:"HealthProbeTimeout"`
 
 	// Fixed validation tags - removed 'min=1' from complex structs
 	Domains    []domains.Domain       `json:"domains" validate:"omitempty,dive" db:"Domains"`
@@ -38,6 +47,7 @@ type UpdateDeploymentDto struct {
 	ServerIDs    []uint `json:"server_ids" validate:"omitempty,dive,min=1" db:"ServerIDs"` // Commented out
 	ScriptIDs    []uint `json:"script_ids" validate:"omitempty,dive,min=1" db:"ScriptIDs"`
 	SecretIDs    []uint `json:"secret_ids" validate:"omitempty,dive,min=1" db:"SecretIDs"`
+	StackIDs     []uint `json:"stack_ids" validate:"omitempty,dive,min=1" db:"StackIDs"`
 }
 
 func (dto *UpdateDeploymentDto) GetUpdates() (map[string]interface{}, []string) {
@@ -69,7 +79,7 @@ func (dto *UpdateDeploymentDto) GetUpdates() (map[string]interface{}, []string)
 			fieldName := fieldType.Name
 			if fieldName == "DomainIDs" || fieldName == "SubDomainIDs" ||
 				fieldName == "ContainerIDs" || fieldName == "ScriptIDs" ||
-				fieldName == "SecretIDs" {
+				fieldName == "SecretIDs" || fieldName == "StackIDs" {
 				// Convert IDs to actual objects (you'll need to implement this)
 				updates[dbTag] = field.Interface()
 			} else {
@@ -0,0 +1,5 @@
+package dto
+
+type RunProjectDto struct {
+	ContinueOnError bool `json:"continue_on_error"`
+}
@@ -1,8 +1,25 @@
 package dto
 
+import "github.com/go-playground/validator/v10"
+
 type RunScriptDto struct {
 	ScriptID uint `json:"script_id" validate:"required"`
-	ServerID uint `json:"server_id" validate:"required"`
-	EnvID    uint `json:"env_id" validate:"omitempty"`
-	LoadEnv  bool `json:"load_env" validate:"required"`
+	// ServerID, ServerIDs and DeploymentID (all servers of the deployment) are combined, one of them is required
+	ServerID     uint   `json:"server_id" validate:"required_without_all=ServerIDs DeploymentID"`
+	ServerIDs    []uint `json:"server_ids" validate:"omitempty,max=500,dive,min=1"`
+	DeploymentID *uint  `json:"deployment_id" validate:"omitempty,min=1"`
+	EnvID        uint   `json:"env_id" validate:"omitempty"`
+	LoadEnv      bool   `json:"load_env"`
+	// Mode overrides EXECUTE_MODE for this run, "worker" or "direct"
+	Mode string `json:"mode" validate:"omitempty,oneof=worker direct"`
+	// Timeout overrides the timeout of the script for this run, in seconds
+	Timeout *int `json:"timeout" validate:"omitempty,min=1,max=86400"`
+	// Concurrency limits the servers the script runs on at once, MaxFailures stops starting new ones after that many failed
+	Concurrency int `json:"concurrency" validate:"omitempty,min=1,max=100"`
+	MaxFailures int `json:"max_failures" validate:"omitempty,min=1"`
+}
+
+func ValidateRunScriptDto(dto RunScriptDto) error {
+	validate := validator.New()
+	return validate.Struct(dto)
 }
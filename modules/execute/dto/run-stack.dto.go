@@ -0,0 +1,20 @@
+package dto
+
+import "github.com/go-playground/validator/v10"
+
+type RunStackDto struct {
+	// ServerIDs and DeploymentID (all servers of the deployment) are combined, one of them is required
+	ServerIDs    []uint `json:"server_ids" validate:"required_without=DeploymentID,omitempty,max=500,dive,min=1"`
+	DeploymentID *uint  `json:"deployment_id" validate:"omitempty,min=1"`
+	// Mode overrides EXECUTE_MODE for this run, "worker" or "direct"
+	Mode    string `json:"mode" validate:"omitempty,oneof=worker direct"`
+	Timeout *int   `json:"timeout" validate:"omitempty,min=1,max=86400"`
+	// Concurrency limits the servers handled at once, MaxFailures stops starting new ones after that many failed
+	Concurrency int `json:"concurrency" validate:"omitempty,min=1,max=100"`
+	MaxFailures int `json:"max_failures" validate:"omitempty,min=1"`
+}
+
+func ValidateRunStackDto(dto RunStackDto) error {
+	validate := validator.New()
+	return validate.Struct(dto)
+}
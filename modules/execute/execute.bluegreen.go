@@ -0,0 +1,183 @@
+package execute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/containers"
+	"deployer.com/modules/servers"
+	"gorm.io/gorm"
+)
+
+// containerRollbackTimeout bounds the rollback of a container, it also runs when the run was canceled
+const containerRollbackTimeout = 2 * time.Minute
+
+// defaultHealthProbe passes once the container reports healthy, or is still running a moment after it
+// started when the image has no health check
+const defaultHealthProbe = `status=$(docker inspect -f '{{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}}' "$CONTAINER") || return 1
+case "$status" in
+	healthy) return 0 ;;
+	running) sleep 5 && [ "$(docker inspect -f '{{.State.Status}}' "$CONTAINER")" = running ] ;;
+	*) return 1 ;;
+esac`
+
+// swapContainer deploys a container blue/green: the new tag starts next to the running container and
+// replaces it once the health probe passed. A failure removes the new container, the old one keeps running
+// or, when it is already gone, is restored from the previously deployed tag
+func (s *ExecuteService) swapContainer(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, container containers.ContainerResponse, executor commandExecutor) error {
+	config := s.containerConfig(plan, server, container, executor)
+	candidateName := libs.CandidateContainerName(container.Name)
+	candidate := config
+	candidate.DockerContainerName = &candidateName
+
+	// A candidate left over by an earlier failed run is replaced
+	command, err := s.SSHRuner.RemoveDockerCommand(&candidate)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "remove_container:"+candidateName, command); err != nil {
+		return err
+	}
+
+	if err := s.uploadEnvFile(ctx, plan.RunID, server, executor, &config, "upload_env:"+container.Name); err != nil {
+		return err
+	}
+	command, err = s.SSHRuner.RunCandidateDockerCommand(&config)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "run_candidate:"+container.Name, command); err != nil {
+		return s.rollbackContainer(ctx, plan, server, container, executor, false, err)
+	}
+
+	probe := plan.Deployment.HealthProbe
+	if probe == "" {
+		probe = defaultHealthProbe
+	}
+	probeConfig := s.serverConfig(server, executor)
+	probeConfig.Script = fmt.Sprintf("CONTAINER=%s\n%s", libs.ShellQuote(candidateName), retryScript(probe, plan.Deployment.HealthProbeTimeout))
+	command, err = s.SSHRuner.CreateScriptRunner(&probeConfig)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "health_probe:"+container.Name, command); err != nil {
+		return s.rollbackContainer(ctx, plan, server, container, executor, false, err)
+	}
+
+	if len(container.RunOptions.Ports) > 0 {
+		return s.recreateContainer(ctx, plan, server, container, executor, config, candidate)
+	}
+	command, err = s.SSHRuner.SwapDockerCommand(&config)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "swap_container:"+container.Name, command); err != nil {
+		// The old container may already be removed
+		return s.rollbackContainer(ctx, plan, server, container, executor, true, err)
+	}
+	return nil
+}
+
+// recreateContainer finishes a blue/green deployment of a container publishing ports. The candidate ran
+// without them while the old container held them, so both are removed and the probed tag runs again under
+// the container name with its ports and static IP
+func (s *ExecuteService) recreateContainer(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, container containers.ContainerResponse, executor commandExecutor, config, candidate libs.SSHRunerConfig) error {
+	command, err := s.SSHRuner.RemoveDockerCommand(&candidate)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "remove_container:"+*candidate.DockerContainerName, command); err != nil {
+		return s.rollbackContainer(ctx, plan, server, container, executor, false, err)
+	}
+	command, err = s.SSHRuner.RemoveDockerCommand(&config)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "remove_container:"+container.Name, command); err != nil {
+		return s.rollbackContainer(ctx, plan, server, container, executor, true, err)
+	}
+
+	// The env file of the candidate was removed once it started
+	config.EnvFile = nil
+	if err := s.uploadEnvFile(ctx, plan.RunID, server, executor, &config, "upload_env:"+container.Name); err != nil {
+		return s.rollbackContainer(ctx, plan, server, container, executor, true, err)
+	}
+	command, err = s.SSHRuner.RunDockerCommand(&config)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "swap_container:"+container.Name, command); err != nil {
+		return s.rollbackContainer(ctx, plan, server, container, executor, true, err)
+	}
+	return nil
+}
+
+// rollbackContainer removes the candidate and with restore runs the previously deployed tag again. The
+// returned error wraps the failure that caused the rollback
+func (s *ExecuteService) rollbackContainer(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, container containers.ContainerResponse, executor commandExecutor, restore bool, cause error) error {
+	// Cleaning up has to happen even when the run was canceled or timed out
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), containerRollbackTimeout)
+	defer cancel()
+
+	if err := s.runRollback(ctx, plan, server, container, executor, restore); err != nil {
+		fmt.Printf("ERROR: Deployment %d: rollback of %s on %s@%s failed: %v\n",
+			plan.Deployment.ID, container.Name, server.Username, server.Host, err)
+		return fmt.Errorf("container %s failed and rollback failed (%v): %w", container.Name, err, cause)
+	}
+	return fmt.Errorf("container %s rolled back: %w", container.Name, cause)
+}
+
+func (s *ExecuteService) runRollback(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, container containers.ContainerResponse, executor commandExecutor, restore bool) error {
+	config := s.containerConfig(plan, server, container, executor)
+	candidateName := libs.CandidateContainerName(container.Name)
+	candidate := config
+	candidate.DockerContainerName = &candidateName
+	command, err := s.SSHRuner.RemoveDockerCommand(&candidate)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "rollback:"+container.Name, command); err != nil {
+		return err
+	}
+	if !restore {
+		return nil
+	}
+
+	deployed, err := s.DeploymentsService.GetDeployedContainer(server.ID, container.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("no previously deployed tag recorded for %s", container.Name)
+	}
+	if err != nil {
+		return err
+	}
+	command, err = s.SSHRuner.RemoveDockerCommand(&config)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "remove_container:"+container.Name, command); err != nil {
+		return err
+	}
+	config.DockerTag = &deployed.Tag
+	if err := s.uploadEnvFile(ctx, plan.RunID, server, executor, &config, "upload_env:"+container.Name); err != nil {
+		return err
+	}
+	command, err = s.SSHRuner.RunDockerCommand(&config)
+	if err != nil {
+		return err
+	}
+	return s.executeStep(ctx, plan.RunID, server, executor, "restore_container:"+container.Name, command)
+}
+
+// recordDeployedContainer remembers the tag now running on the server as the rollback target of the next deployment
+func (s *ExecuteService) recordDeployedContainer(plan *deploymentPlan, server servers.ServerResponse, container containers.ContainerResponse) {
+	tag := container.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	if err := s.DeploymentsService.RecordDeployedContainer(plan.Deployment.ID, server.ID, container.ID, tag); err != nil {
+		fmt.Printf("ERROR: Deployment %d: %v\n", plan.Deployment.ID, err)
+	}
+}
@@ -1,10 +1,22 @@
 package execute
 
 import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
 	"deployer.com/libs"
 	"deployer.com/modules/auth/guards"
 	"deployer.com/modules/execute/dto"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultWaitTimeout and maxWaitTimeout bound how long a wait=true request blocks, in seconds
+	defaultWaitTimeout = 300
+	maxWaitTimeout     = 1800
 )
 
 type ExecuteController struct {
@@ -18,7 +30,25 @@ func NewExecuteController(router *fiber.Router, executeService *ExecuteService)
 
 func (c *ExecuteController) RegisterExecuteRoutes(router *fiber.Router) {
 	(*c.router).Post("/script", guards.JwtGuard, c.RunScript)
+	(*c.router).Post("/deployment/:id", guards.JwtGuard, c.RunDeployment)
+
+}
+
+// RegisterProjectRoutes adds the pipeline routes to the projects group
+func (c *ExecuteController) RegisterProjectRoutes(router *fiber.Router) {
+	(*router).Post("/:id/run", guards.JwtGuard, c.RunProject)
+}
+
+// RegisterDeploymentRoutes adds the routes that run deployments to the deployments group
+func (c *ExecuteController) RegisterDeploymentRoutes(router *fiber.Router) {
+	(*router).Post("/:id/rollback", guards.JwtGuard, c.RollbackDeployment)
+}
 
+// RegisterStackRoutes adds the routes that bring stacks up and down to the stacks group
+func (c *ExecuteController) RegisterStackRoutes(router *fiber.Router) {
+	(*router).Post("/:id/deploy", guards.JwtGuard, c.DeployStack)
+	(*router).Post("/:id/down", guards.JwtGuard, c.StopStack)
+	(*router).Get("/:id/status", guards.JwtGuard, c.StackStatus)
 }
 
 func (c *ExecuteController) RunScript(ctx *fiber.Ctx) error {
@@ -29,12 +59,267 @@ func (c *ExecuteController) RunScript(ctx *fiber.Ctx) error {
 			"error": err.Error(),
 		})
 	}
-	if err := c.executeService.RunScript(runScriptDto.ScriptID, uint(userClaims.UserID), runScriptDto.ServerID, runScriptDto.EnvID, userClaims.IV, runScriptDto.LoadEnv); err != nil {
+	if err := dto.ValidateRunScriptDto(runScriptDto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	waitTimeout, err := c.waitTimeout(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	runId, err := c.executeService.RunScript(uint(userClaims.UserID), userClaims.IV, runScriptDto)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if waitTimeout > 0 {
+		// Only the output of the script itself, not of the connection test
+		return c.waitRun(ctx, runId, waitTimeout, "script")
+	}
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Script started",
+		"run_id":  runId,
+	})
+}
+
+func (c *ExecuteController) RunDeployment(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	waitTimeout, err := c.waitTimeout(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	runId, err := c.executeService.RunDeployment(uint(id), uint(userClaims.UserID), userClaims.IV)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if waitTimeout > 0 {
+		return c.waitRun(ctx, runId, waitTimeout)
+	}
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Deployment started",
+		"run_id":  runId,
+	})
+}
+
+func (c *ExecuteController) RollbackDeployment(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	// Without ?revision= the previous successful revision is used
+	revision := ctx.QueryInt("revision", 0)
+	if revision < 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "revision must be a positive number",
+		})
+	}
+	// ?current_secrets=true deploys the secrets as they are now instead of the versions of the revision
+	currentSecrets := ctx.QueryBool("current_secrets", false)
+	waitTimeout, err := c.waitTimeout(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	runId, rolledBackTo, err := c.executeService.RollbackDeployment(uint(id), uint(userClaims.UserID), userClaims.IV, revision, currentSecrets)
+	if err != nil {
+		if errors.Is(err, ErrSecretVersionMissing) {
+			return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No revision to roll back to",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if waitTimeout > 0 {
+		return c.waitRun(ctx, runId, waitTimeout)
+	}
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":  "Rollback started",
+		"run_id":   runId,
+		"revision": rolledBackTo,
+	})
+}
+
+func (c *ExecuteController) DeployStack(ctx *fiber.Ctx) error {
+	return c.runStack(ctx, stackActionUp)
+}
+
+func (c *ExecuteController) StopStack(ctx *fiber.Ctx) error {
+	return c.runStack(ctx, stackActionDown)
+}
+
+func (c *ExecuteController) runStack(ctx *fiber.Ctx, action stackAction) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	var runStackDto dto.RunStackDto
+	if err := ctx.BodyParser(&runStackDto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := dto.ValidateRunStackDto(runStackDto); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	waitTimeout, err := c.waitTimeout(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	message := "Stack deployment started"
+	var runId uint
+	if action == stackActionUp {
+		runId, err = c.executeService.DeployStack(uint(id), uint(userClaims.UserID), userClaims.IV, runStackDto)
+	} else {
+		message = "Stack shutdown started"
+		runId, err = c.executeService.StopStack(uint(id), uint(userClaims.UserID), userClaims.IV, runStackDto)
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if waitTimeout > 0 {
+		return c.waitRun(ctx, runId, waitTimeout)
+	}
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": message,
+		"run_id":  runId,
+	})
+}
+
+// StackStatus reports the services of the stack on the servers given by ?server_ids=1,2 and/or ?deployment_id=
+func (c *ExecuteController) StackStatus(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	serverIds := make([]uint, 0)
+	for _, value := range strings.Split(ctx.Query("server_ids"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		serverId, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || serverId == 0 {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "server_ids must be a comma separated list of ids",
+			})
+		}
+		serverIds = append(serverIds, uint(serverId))
+	}
+	var deploymentId *uint
+	if value := ctx.QueryInt("deployment_id", 0); value > 0 {
+		id := uint(value)
+		deploymentId = &id
+	}
+	if len(serverIds) == 0 && deploymentId == nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "server_ids or deployment_id is required",
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	status, err := c.executeService.StackStatus(uint(id), uint(userClaims.UserID), userClaims.IV, serverIds, deploymentId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(status)
+}
+
+// waitTimeout reads ?wait=true and ?wait_timeout=, zero means the caller does not wait
+func (c *ExecuteController) waitTimeout(ctx *fiber.Ctx) (time.Duration, error) {
+	if !ctx.QueryBool("wait") {
+		return 0, nil
+	}
+	seconds := ctx.QueryInt("wait_timeout", defaultWaitTimeout)
+	if seconds < 1 || seconds > maxWaitTimeout {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "wait_timeout must be between 1 and "+strconv.Itoa(maxWaitTimeout))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// waitRun blocks until the run finishes, a run still active after the timeout is answered with 202
+func (c *ExecuteController) waitRun(ctx *fiber.Ctx, runId uint, timeout time.Duration, outputSteps ...string) error {
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	result, finished, err := c.executeService.RunsService.WaitRun(runId, uint(userClaims.UserID), timeout, outputSteps...)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if !finished {
+		return ctx.Status(fiber.StatusAccepted).JSON(result)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(result)
+}
+
+func (c *ExecuteController) RunProject(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	var runProjectDto dto.RunProjectDto
+	// The body is optional, an empty request runs with the defaults
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&runProjectDto); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	if err := c.executeService.RunProject(uint(id), uint(userClaims.UserID), userClaims.IV, runProjectDto.ContinueOnError); err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
-	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Script executed successfully",
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Project pipeline started",
 	})
 }
@@ -0,0 +1,461 @@
+package execute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/containers"
+	"deployer.com/modules/deployments"
+	"deployer.com/modules/domains"
+	"deployer.com/modules/runs"
+	"deployer.com/modules/scripts"
+	"deployer.com/modules/servers"
+)
+
+// setUpServerScript makes sure docker and the default gateway network exist on the server
+const setUpServerScript = `command -v docker >/dev/null 2>&1 || (curl -fsSL https://get.docker.com | sh)
+docker network inspect gateway_network >/dev/null 2>&1 || docker network create --subnet 172.30.0.0/16 gateway_network`
+
+// deploymentPlan holds the decrypted resources of a deployment, resolved before the run starts
+type deploymentPlan struct {
+	RunID      uint
+	Deployment deployments.Deployment
+	Servers    []servers.ServerResponse
+	Containers []containers.ContainerResponse
+	Scripts    []scripts.ScriptResponse
+	Domains    []domains.DomainResponse
+	Stacks     []stackPlan
+	Env        map[string]string
+	// Snapshot is stored as a new revision of the deployment when the run is created
+	Snapshot       deployments.RevisionSnapshot
+	RevisionID     uint
+	RolledBackFrom *int
+}
+
+type deploymentStage struct {
+	Name string
+	Run  func(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error
+}
+
+func (s *ExecuteService) RunDeployment(id, userId uint, iv string) (uint, error) {
+	plan, err := s.prepareDeployment(id, userId, iv)
+	if err != nil {
+		return 0, err
+	}
+
+	executor, err := s.newExecutor("")
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.createDeploymentRun(plan); err != nil {
+		return 0, err
+	}
+
+	go s.runDeployment(plan, executor)
+
+	return plan.RunID, nil
+}
+
+// prepareDeployment loads a deployment with its decrypted resources so it can be run later
+func (s *ExecuteService) prepareDeployment(id, userId uint, iv string) (*deploymentPlan, error) {
+	deployment, err := s.DeploymentsService.GetDeploymentEntity(id, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	if len(deployment.Servers) == 0 {
+		return nil, fmt.Errorf("deployment %s has no servers", deployment.Name)
+	}
+	plan, err := s.resolveDeployment(deployment, userId, iv)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.renderStacks(plan, userId, iv); err != nil {
+		return nil, err
+	}
+	if plan.Snapshot, err = s.revisionSnapshot(plan, iv); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// createDeploymentRun records a new run for the plan and resets the deployment to pending
+func (s *ExecuteService) createDeploymentRun(plan *deploymentPlan) error {
+	run := runs.Run{
+		Type:         runs.RunTypeDeployment,
+		UserID:       plan.Deployment.UserID,
+		DeploymentID: &plan.Deployment.ID,
+		Timeout:      runTimeout(plan.Deployment.Timeout, nil),
+	}
+	if err := s.RunsService.CreateRun(&run); err != nil {
+		return fmt.Errorf("failed to create run: %w", err)
+	}
+	plan.RunID = run.ID
+
+	revision := deployments.DeploymentRevision{
+		DeploymentID:   plan.Deployment.ID,
+		UserID:         plan.Deployment.UserID,
+		RunID:          run.ID,
+		RolledBackFrom: plan.RolledBackFrom,
+		Status:         deployments.DeploymentStatusPending,
+		Snapshot:       plan.Snapshot,
+	}
+	if err := s.DeploymentsService.CreateRevision(&revision); err != nil {
+		s.finishRun(run.ID, err)
+		return err
+	}
+	plan.RevisionID = revision.ID
+
+	if err := s.DeploymentsService.SetDeploymentStatus(plan.Deployment.ID, deployments.DeploymentStatusPending, nil); err != nil {
+		err = fmt.Errorf("failed to update deployment status: %w", err)
+		// The run and its revision never start, they are not left pending
+		s.finishRun(run.ID, err)
+		if statusErr := s.DeploymentsService.SetRevisionStatus(revision.ID, deployments.DeploymentStatusFailed); statusErr != nil {
+			fmt.Printf("ERROR: Failed to update revision status of deployment %d: %v\n", plan.Deployment.ID, statusErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// resolveDeployment decrypts every resource linked to the deployment so the run does not depend on the request
+func (s *ExecuteService) resolveDeployment(deployment deployments.Deployment, userId uint, iv string) (*deploymentPlan, error) {
+	plan := &deploymentPlan{Deployment: deployment, Env: map[string]string{}}
+
+	for _, server := range deployment.Servers {
+		decoded, err := s.ServersService.GetServer(server.ID, userId, iv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get server %d: %w", server.ID, err)
+		}
+		plan.Servers = append(plan.Servers, decoded)
+	}
+
+	if deployment.PoolContainers || deployment.RunContainers {
+		for _, container := range deployment.Containers {
+			decoded, err := s.ContainersService.GetContainer(container.ID, userId, iv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get container %d: %w", container.ID, err)
+			}
+			plan.Containers = append(plan.Containers, decoded)
+		}
+	}
+
+	if deployment.RunContainers {
+		for _, stack := range deployment.Stacks {
+			decoded, err := s.StacksService.GetStack(stack.ID, userId, iv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get stack %d: %w", stack.ID, err)
+			}
+			plan.Stacks = append(plan.Stacks, stackPlan{StackResponse: decoded})
+		}
+	}
+
+	if deployment.RunScripts {
+		for _, script := range deployment.Scripts {
+			decoded, err := s.ScriptsService.GetScript(script.ID, userId, iv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get script %d: %w", script.ID, err)
+			}
+			plan.Scripts = append(plan.Scripts, decoded)
+		}
+	}
+
+	if deployment.SetUpDomains {
+		// Sub domains are served with the certificate of their parent domain
+		domainIDs := make([]uint, 0, len(deployment.Domains)+len(deployment.SubDomains))
+		seen := make(map[uint]bool)
+		for _, domain := range deployment.Domains {
+			if !seen[domain.ID] {
+				seen[domain.ID] = true
+				domainIDs = append(domainIDs, domain.ID)
+			}
+		}
+		for _, subDomain := range deployment.SubDomains {
+			if !seen[subDomain.DomainID] {
+				seen[subDomain.DomainID] = true
+				domainIDs = append(domainIDs, subDomain.DomainID)
+			}
+		}
+		for _, domainID := range domainIDs {
+			decoded, err := s.DomainsService.GetDomain(domainID, userId, iv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get domain %d: %w", domainID, err)
+			}
+			plan.Domains = append(plan.Domains, decoded)
+		}
+	}
+
+	if deployment.SetSecretsToServer || deployment.SetSecretsToContainer {
+		for _, secret := range deployment.Secrets {
+			decoded, err := s.EnvsService.GetSecret(secret.ID, userId, iv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get secret %d: %w", secret.ID, err)
+			}
+			for key, value := range s.EnvsService.GetEnvMap(decoded) {
+				plan.Env[key] = value
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// runDeployment executes the plan on every server and blocks until the deployment finished
+func (s *ExecuteService) runDeployment(plan *deploymentPlan, executor commandExecutor) error {
+	deploymentId := plan.Deployment.ID
+
+	startedAt := time.Now()
+	if err := s.DeploymentsService.SetDeploymentStatus(deploymentId, deployments.DeploymentStatusRunning, &startedAt); err != nil {
+		fmt.Printf("ERROR: Failed to mark deployment %d as running: %v\n", deploymentId, err)
+	}
+	if err := s.RunsService.StartRun(plan.RunID); err != nil {
+		fmt.Printf("ERROR: Failed to mark run %d as running: %v\n", plan.RunID, err)
+	}
+	// The deadline of the run starts with StartRun
+	ctx := s.RunsService.Context(plan.RunID)
+	if err := s.DeploymentsService.SetRevisionStatus(plan.RevisionID, deployments.DeploymentStatusRunning); err != nil {
+		fmt.Printf("ERROR: Failed to update revision status of deployment %d: %v\n", deploymentId, err)
+	}
+
+	status := deployments.DeploymentStatusSuccess
+	var runErr error
+	stages := s.deploymentStages(plan.Deployment)
+	if plan.Deployment.Strategy == deployments.DeploymentStrategyRolling {
+		runErr = s.runRollingDeployment(ctx, plan, stages, executor)
+	} else {
+		runErr = s.runSequentialDeployment(ctx, plan, stages, executor)
+	}
+	if runErr != nil {
+		status = deployments.DeploymentStatusFailed
+		if errors.Is(runErr, context.Canceled) {
+			status = deployments.DeploymentStatusCanceled
+		} else if errors.Is(runErr, context.DeadlineExceeded) {
+			status = deployments.DeploymentStatusTimedOut
+		}
+	}
+
+	if err := s.DeploymentsService.SetDeploymentStatus(deploymentId, status, nil); err != nil {
+		fmt.Printf("ERROR: Failed to update deployment %d status: %v\n", deploymentId, err)
+	}
+	if err := s.DeploymentsService.SetRevisionStatus(plan.RevisionID, status); err != nil {
+		fmt.Printf("ERROR: Failed to update revision status of deployment %d: %v\n", deploymentId, err)
+	}
+	s.finishRun(plan.RunID, runErr)
+	fmt.Printf("DEBUG: Deployment %d finished with status %s in %s\n", deploymentId, status, time.Since(startedAt))
+	return runErr
+}
+
+// runSequentialDeployment deploys one server after the other and stops on the first failure
+func (s *ExecuteService) runSequentialDeployment(ctx context.Context, plan *deploymentPlan, stages []deploymentStage, executor commandExecutor) error {
+	for _, server := range plan.Servers {
+		if err := s.runDeploymentStages(ctx, plan, stages, server, executor); err != nil {
+			fmt.Printf("ERROR: Deployment %d failed on server %s@%s: %v\n", plan.Deployment.ID, server.Username, server.Host, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ExecuteService) runDeploymentStages(ctx context.Context, plan *deploymentPlan, stages []deploymentStage, server servers.ServerResponse, executor commandExecutor) error {
+	if err := s.verifyHostKey(ctx, plan.RunID, &server); err != nil {
+		return err
+	}
+	for _, stage := range stages {
+		fmt.Printf("DEBUG: Deployment %d: running stage %s on %s@%s using %s\n",
+			plan.Deployment.ID, stage.Name, server.Username, server.Host, executor.Describe())
+		if err := stage.Run(ctx, plan, server, executor); err != nil {
+			return fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+	}
+	return nil
+}
+
+// deploymentStages returns the enabled stages of a deployment in execution order
+func (s *ExecuteService) deploymentStages(deployment deployments.Deployment) []deploymentStage {
+	stages := make([]deploymentStage, 0)
+	if deployment.SetUpServers {
+		stages = append(stages, deploymentStage{Name: "setup_servers", Run: s.setUpServerStage})
+	}
+	if deployment.SetUpDomains {
+		stages = append(stages, deploymentStage{Name: "setup_domains", Run: s.setUpDomainsStage})
+	}
+	if deployment.SetSecretsToServer {
+		stages = append(stages, deploymentStage{Name: "set_secrets_to_server", Run: s.setSecretsToServerStage})
+	}
+	if deployment.PoolContainers {
+		stages = append(stages, deploymentStage{Name: "pool_containers", Run: s.pullContainersStage})
+	}
+	if deployment.RunContainers {
+		stages = append(stages, deploymentStage{Name: "run_containers", Run: s.runContainersStage})
+	}
+	if deployment.RunContainers && len(deployment.Stacks) > 0 {
+		stages = append(stages, deploymentStage{Name: "run_stacks", Run: s.runStacksStage})
+	}
+	if deployment.RunScripts {
+		stages = append(stages, deploymentStage{Name: "run_scripts", Run: s.runScriptsStage})
+	}
+	return stages
+}
+
+func (s *ExecuteService) setUpServerStage(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error {
+	// Networks the containers join are created as well, a static IP needs a network with a subnet
+	var script strings.Builder
+	script.WriteString(setUpServerScript)
+	seen := map[string]bool{"gateway_network": true}
+	for _, container := range plan.Containers {
+		network := container.RunOptions.Network
+		if network == "" || seen[network] {
+			continue
+		}
+		seen[network] = true
+		fmt.Fprintf(&script, "\ndocker network inspect %[1]s >/dev/null 2>&1 || docker network create %[1]s", libs.ShellQuote(network))
+	}
+
+	config := s.serverConfig(server, executor)
+	config.Script = script.String()
+	command, err := s.SSHRuner.CreateScriptRunner(&config)
+	if err != nil {
+		return err
+	}
+	return s.executeStep(ctx, plan.RunID, server, executor, "setup_server", command)
+}
+
+func (s *ExecuteService) setUpDomainsStage(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error {
+	for _, domain := range plan.Domains {
+		if domain.SSLCert == "" || domain.SSLKey == "" {
+			continue
+		}
+		dir := "\"$HOME\"/.deployer/ssl/" + libs.ShellQuote(domain.Name)
+		var script strings.Builder
+		fmt.Fprintf(&script, "mkdir -p %s\n", dir)
+		fmt.Fprintf(&script, "printf '%%s\\n' %s > %s/fullchain.pem\n", libs.ShellQuote(strings.TrimSpace(domain.SSLCert)), dir)
+		fmt.Fprintf(&script, "(umask 077 && printf '%%s\\n' %s > %s/privkey.pem)\n", libs.ShellQuote(strings.TrimSpace(domain.SSLKey)), dir)
+		fmt.Fprintf(&script, "chmod 600 %s/privkey.pem", dir)
+
+		config := s.serverConfig(server, executor)
+		config.Script = script.String()
+		command, err := s.SSHRuner.CreateScriptRunner(&config)
+		if err != nil {
+			return err
+		}
+		if err := s.executeStep(ctx, plan.RunID, server, executor, "setup_domain:"+domain.Name, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSecretsToServerStage keeps the secrets of the deployment on the server in a file of their own, scripts
+// and logins on the server can source it. Every run replaces the file
+func (s *ExecuteService) setSecretsToServerStage(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error {
+	config := s.serverConfig(server, executor)
+	config.Env = &plan.Env
+	content, err := s.SSHRuner.ScriptEnvFile(&config)
+	if err != nil {
+		return err
+	}
+	name := deploymentEnvFileName(plan.Deployment.ID)
+	config.EnvFile = &name
+	command, err := s.SSHRuner.UploadEnvFileCommand(&config)
+	if err != nil {
+		return err
+	}
+	return s.executeInputStep(ctx, plan.RunID, server, executor, "set_secrets_to_server", command, []byte(content))
+}
+
+func (s *ExecuteService) pullContainersStage(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error {
+	for _, container := range plan.Containers {
+		config := s.containerConfig(plan, server, container, executor)
+		if container.Username != "" && container.Password != "" {
+			command, err := s.SSHRuner.LoginDockerCommand(&config)
+			if err != nil {
+				return err
+			}
+			if err := s.executeInputStep(ctx, plan.RunID, server, executor, "docker_login:"+container.Name, command, []byte(container.Password)); err != nil {
+				return err
+			}
+		}
+		command, err := s.SSHRuner.PullDockerCommand(&config)
+		if err != nil {
+			return err
+		}
+		if err := s.executeStep(ctx, plan.RunID, server, executor, "pull_container:"+container.Name, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ExecuteService) runContainersStage(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error {
+	for _, container := range plan.Containers {
+		var err error
+		if plan.Deployment.BlueGreen {
+			err = s.swapContainer(ctx, plan, server, container, executor)
+		} else {
+			err = s.replaceContainer(ctx, plan, server, container, executor)
+		}
+		if err != nil {
+			return err
+		}
+		s.recordDeployedContainer(plan, server, container)
+	}
+	return nil
+}
+
+// replaceContainer removes the previous container of the same name and runs the new one
+func (s *ExecuteService) replaceContainer(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, container containers.ContainerResponse, executor commandExecutor) error {
+	config := s.containerConfig(plan, server, container, executor)
+	command, err := s.SSHRuner.RemoveDockerCommand(&config)
+	if err != nil {
+		return err
+	}
+	if err := s.executeStep(ctx, plan.RunID, server, executor, "remove_container:"+container.Name, command); err != nil {
+		return err
+	}
+	if err := s.uploadEnvFile(ctx, plan.RunID, server, executor, &config, "upload_env:"+container.Name); err != nil {
+		return err
+	}
+	command, err = s.SSHRuner.RunDockerCommand(&config)
+	if err != nil {
+		return err
+	}
+	return s.executeStep(ctx, plan.RunID, server, executor, "run_container:"+container.Name, command)
+}
+
+func (s *ExecuteService) runScriptsStage(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error {
+	for _, script := range plan.Scripts {
+		config := s.serverConfig(server, executor)
+		config.Script = script.Script
+		config.Env = &plan.Env
+		config.SetSecretsToScript = &plan.Deployment.SetSecretsToServer
+		if err := s.uploadEnvFile(ctx, plan.RunID, server, executor, &config, "upload_env:script:"+script.Name); err != nil {
+			return err
+		}
+		command, err := s.SSHRuner.CreateScriptRunner(&config)
+		if err != nil {
+			return err
+		}
+		if err := s.executeStep(ctx, plan.RunID, server, executor, "script:"+script.Name, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ExecuteService) containerConfig(plan *deploymentPlan, server servers.ServerResponse, container containers.ContainerResponse, executor commandExecutor) libs.SSHRunerConfig {
+	config := s.serverConfig(server, executor)
+	config.DockerUser = &container.Username
+	config.DockerPassword = &container.Password
+	config.DockerImage = &container.Image
+	config.DockerRegistry = &container.Registry
+	config.DockerTag = &container.Tag
+	config.DockerContainerName = &container.Name
+	config.DockerRunOptions = &container.RunOptions
+	config.Env = &plan.Env
+	config.SetSecretsToContainer = &plan.Deployment.SetSecretsToContainer
+	return config
+}
@@ -0,0 +1,58 @@
+package execute
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"deployer.com/libs"
+	"deployer.com/modules/servers"
+)
+
+// uploadEnvFile writes the secrets of config to a permission-restricted env file on the server and points
+// config.EnvFile at it, the command built from config afterwards reads and removes the file. Nothing is
+// uploaded when the secrets are not passed or there are none
+func (s *ExecuteService) uploadEnvFile(ctx context.Context, runId uint, server servers.ServerResponse, executor commandExecutor, config *libs.SSHRunerConfig, step string) error {
+	forContainer := config.SetSecretsToContainer != nil && *config.SetSecretsToContainer
+	forScript := config.SetSecretsToScript != nil && *config.SetSecretsToScript
+	if (!forContainer && !forScript) || config.Env == nil || len(*config.Env) == 0 {
+		return nil
+	}
+
+	var content string
+	var err error
+	if forContainer {
+		content, err = s.SSHRuner.ContainerEnvFile(config)
+	} else {
+		content, err = s.SSHRuner.ScriptEnvFile(config)
+	}
+	if err != nil {
+		return err
+	}
+
+	name, err := envFileName(runId)
+	if err != nil {
+		return err
+	}
+	config.EnvFile = &name
+	command, err := s.SSHRuner.UploadEnvFileCommand(config)
+	if err != nil {
+		return err
+	}
+	return s.executeInputStep(ctx, runId, server, executor, step, command, []byte(content))
+}
+
+// envFileName returns a unique file name so parallel steps on a server never share a file
+func envFileName(runId uint) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate env file name: %w", err)
+	}
+	return fmt.Sprintf("run-%d-%s.env", runId, hex.EncodeToString(random)), nil
+}
+
+// deploymentEnvFileName is the env file a deployment keeps its secrets in on its servers
+func deploymentEnvFileName(deploymentId uint) string {
+	return fmt.Sprintf("deployment-%d.env", deploymentId)
+}
@@ -0,0 +1,29 @@
+package execute
+
+import "fmt"
+
+// exitError reports a command that reached the server but exited with a non-zero code
+type exitError struct {
+	Step   string
+	Code   int
+	Direct bool
+}
+
+func newExitError(step string, code int, direct bool) *exitError {
+	return &exitError{Step: step, Code: code, Direct: direct}
+}
+
+func (e *exitError) Error() string {
+	if e.Direct {
+		return fmt.Sprintf("%s: command exited with code %d", e.Step, e.Code)
+	}
+	// Through a worker the codes below come from sshpass and ssh, not from the remote command
+	switch e.Code {
+	case 5:
+		// sshpass uses exit code 5 for a rejected password
+		return fmt.Sprintf("%s: SSH authentication failed, check the server username and password", e.Step)
+	case 255:
+		return fmt.Sprintf("%s: SSH connection failed (exit code 255)", e.Step)
+	}
+	return fmt.Sprintf("%s: command exited with code %d", e.Step, e.Code)
+}
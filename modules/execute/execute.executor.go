@@ -0,0 +1,103 @@
+package execute
+
+import (
+	"context"
+	"fmt"
+
+	"deployer.com/libs"
+	"deployer.com/modules/servers"
+)
+
+type ExecuteMode string
+
+const (
+	// ExecuteModeWorker runs sshpass/ssh inside a deploy-worker container
+	ExecuteModeWorker ExecuteMode = "worker"
+	// ExecuteModeDirect connects to the server with the native Go SSH client
+	ExecuteModeDirect ExecuteMode = "direct"
+)
+
+// commandExecutor runs the commands built by SSHRuner against a server
+type commandExecutor interface {
+	// Direct tells SSHRuner to build bare remote commands instead of sshpass wrappers
+	Direct() bool
+	Execute(ctx context.Context, server servers.ServerResponse, command string, onLine libs.ExecOutputHandler) (*libs.ExecResult, error)
+	// ExecuteWithInput runs the command with input as its stdin
+	ExecuteWithInput(ctx context.Context, server servers.ServerResponse, command string, input []byte, onLine libs.ExecOutputHandler) (*libs.ExecResult, error)
+	Describe() string
+}
+
+type workerExecutor struct {
+	docker *libs.DockerComunication
+	worker *libs.Container
+}
+
+func (e *workerExecutor) Direct() bool {
+	return false
+}
+
+func (e *workerExecutor) Execute(ctx context.Context, server servers.ServerResponse, command string, onLine libs.ExecOutputHandler) (*libs.ExecResult, error) {
+	return e.ExecuteWithInput(ctx, server, command, nil, onLine)
+}
+
+func (e *workerExecutor) ExecuteWithInput(ctx context.Context, server servers.ServerResponse, command string, input []byte, onLine libs.ExecOutputHandler) (*libs.ExecResult, error) {
+	// The command reads the credentials of the server from its stdin before the input
+	result, err := e.docker.ExecuteCommandStreamWithInput(ctx, e.worker.ID, command, libs.WorkerInput(server.Password, workerKey(server), input), onLine)
+	if err != nil {
+		// The partial output of a canceled command is kept
+		return result, fmt.Errorf("failed to execute command in container: %w", err)
+	}
+	return result, nil
+}
+
+func (e *workerExecutor) Describe() string {
+	return fmt.Sprintf("worker %s", e.worker.ID)
+}
+
+type directExecutor struct {
+	ssh *libs.SSHExecutor
+}
+
+func (e *directExecutor) Direct() bool {
+	return true
+}
+
+func (e *directExecutor) Execute(ctx context.Context, server servers.ServerResponse, command string, onLine libs.ExecOutputHandler) (*libs.ExecResult, error) {
+	return e.ExecuteWithInput(ctx, server, command, nil, onLine)
+}
+
+func (e *directExecutor) ExecuteWithInput(ctx context.Context, server servers.ServerResponse, command string, input []byte, onLine libs.ExecOutputHandler) (*libs.ExecResult, error) {
+	config := libs.SSHExecutorConfig{
+		Host:                 server.Host,
+		Port:                 server.Port,
+		User:                 server.Username,
+		Password:             server.Password,
+		PrivateKey:           server.SSHKey,
+		PrivateKeyPassphrase: server.SSHKeyPassphrase,
+		HostKeyFingerprint:   server.HostKeyFingerprint,
+		HostKey:              server.HostKey,
+	}
+	return e.ssh.RunWithInput(ctx, &config, command, input, onLine)
+}
+
+func (e *directExecutor) Describe() string {
+	return "direct ssh"
+}
+
+// newExecutor picks how commands reach the servers, an empty mode falls back to the configured default
+func (s *ExecuteService) newExecutor(mode ExecuteMode) (commandExecutor, error) {
+	if mode == "" {
+		mode = s.Mode
+	}
+	switch mode {
+	case ExecuteModeDirect:
+		return &directExecutor{ssh: s.SSHExecutor}, nil
+	case ExecuteModeWorker:
+		worker := s.getWorker()
+		if worker == nil {
+			return nil, fmt.Errorf("no deployment worker containers available")
+		}
+		return &workerExecutor{docker: s.Docker, worker: worker}, nil
+	}
+	return nil, fmt.Errorf("unknown execute mode %q", mode)
+}
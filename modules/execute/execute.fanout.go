@@ -0,0 +1,84 @@
+package execute
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"deployer.com/modules/servers"
+)
+
+// defaultFanOutConcurrency is how many servers a script runs on at once when the caller sets no limit
+const defaultFanOutConcurrency = 5
+
+type fanOutOptions struct {
+	// Concurrency limits the servers handled at once, 0 uses the default
+	Concurrency int
+	// MaxFailures stops starting new servers once that many failed, 0 never stops
+	MaxFailures int
+}
+
+// fanOut calls run for every server with at most Concurrency servers at once. Servers that are not started
+// because the run was stopped get a skipped step called skipStep. A single server returns its own error,
+// several servers an aggregated one
+func (s *ExecuteService) fanOut(ctx context.Context, runId uint, targets []servers.ServerResponse, options fanOutOptions, skipStep string, run func(ctx context.Context, server servers.ServerResponse) error) error {
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultFanOutConcurrency
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+	failures := 0
+	slots := make(chan struct{}, concurrency)
+
+	for i, server := range targets {
+		slots <- struct{}{}
+
+		mutex.Lock()
+		stopped := options.MaxFailures > 0 && failures >= options.MaxFailures
+		mutex.Unlock()
+		if stopped || ctx.Err() != nil {
+			<-slots
+			reason := fmt.Sprintf("skipped after %d failure(s)", options.MaxFailures)
+			if ctx.Err() != nil {
+				reason = fmt.Sprintf("skipped: %v", ctx.Err())
+			}
+			for _, skipped := range targets[i:] {
+				s.skipStep(runId, skipped, skipStep, reason)
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(server servers.ServerResponse) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := run(ctx, server); err != nil {
+				fmt.Printf("ERROR: Run %d failed on server %s@%s: %v\n", runId, server.Username, server.Host, err)
+				mutex.Lock()
+				failures++
+				if firstErr == nil {
+					firstErr = err
+				}
+				mutex.Unlock()
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("run stopped with %d of %d servers failed: %w", failures, len(targets), err)
+	}
+	if len(targets) == 1 || failures == 0 {
+		return firstErr
+	}
+	return fmt.Errorf("failed on %d of %d servers, first error: %w", failures, len(targets), firstErr)
+}
+
+func (s *ExecuteService) skipStep(runId uint, server servers.ServerResponse, name, reason string) {
+	if err := s.RunsService.SkipStep(runId, &server.ID, name, reason); err != nil {
+		fmt.Printf("ERROR: Failed to record skipped step of run %d: %v\n", runId, err)
+	}
+}
@@ -0,0 +1,61 @@
+package execute
+
+import (
+	"context"
+	"fmt"
+
+	"deployer.com/libs"
+	"deployer.com/modules/runs"
+	"deployer.com/modules/servers"
+)
+
+// verifyHostKey checks the host key of the server against the pinned one, pinning it on first use,
+// and keeps the verified key on the server so every later connection of the run is checked against it
+func (s *ExecuteService) verifyHostKey(ctx context.Context, runId uint, server *servers.ServerResponse) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	step, err := s.RunsService.StartStep(runId, &server.ID, "verify_host_key")
+	if err != nil {
+		return fmt.Errorf("failed to record step verify_host_key: %w", err)
+	}
+
+	pinned := server.HostKeyFingerprint
+	hostKey, err := s.checkHostKey(ctx, server)
+	if err != nil {
+		if finishErr := s.RunsService.FinishStep(step, failedStatus(ctx), "", "", nil, err.Error()); finishErr != nil {
+			fmt.Printf("ERROR: Failed to record step %d: %v\n", step.ID, finishErr)
+		}
+		return fmt.Errorf("verify_host_key: %w", err)
+	}
+
+	output := fmt.Sprintf("host key %s matches the pinned key\n", hostKey.Fingerprint)
+	if pinned == "" {
+		output = fmt.Sprintf("host key %s pinned on first use\n", hostKey.Fingerprint)
+	}
+	s.RunsService.PublishOutput(runId, step.ID, libs.ExecStreamStdout, output)
+	if finishErr := s.RunsService.FinishStep(step, runs.RunStatusSuccess, output, "", nil, ""); finishErr != nil {
+		fmt.Printf("ERROR: Failed to record step %d: %v\n", step.ID, finishErr)
+	}
+	return nil
+}
+
+func (s *ExecuteService) checkHostKey(ctx context.Context, server *servers.ServerResponse) (servers.HostKeyResponse, error) {
+	hostKey, err := s.ServersService.CheckHostKey(ctx, server.ID)
+	if err != nil {
+		return servers.HostKeyResponse{}, err
+	}
+	server.HostKey = hostKey.HostKey
+	server.HostKeyFingerprint = hostKey.Fingerprint
+	server.HostKeyPinnedAt = hostKey.PinnedAt
+	return hostKey, nil
+}
+
+// knownHosts returns the known_hosts line of the verified host key, an empty line makes ssh reject the host
+func (s *ExecuteService) knownHosts(server servers.ServerResponse) string {
+	key, err := libs.ParseHostKey(server.HostKey)
+	if err != nil {
+		return ""
+	}
+	return libs.KnownHostsLine(server.Host, server.Port, key)
+}
@@ -0,0 +1,128 @@
+package execute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"deployer.com/modules/deployments"
+	"deployer.com/modules/projects"
+)
+
+// RunProject runs the deployments of a project in ascending order, deployments sharing an order run in parallel
+func (s *ExecuteService) RunProject(id, userId uint, iv string, continueOnError bool) error {
+	project, err := s.ProjectsService.GetProjectEntity(id, userId)
+	if err != nil {
+		return fmt.Errorf("failed to get project: %w", err)
+	}
+	if len(project.ProjectDeployments) == 0 {
+		return fmt.Errorf("project has no deployments")
+	}
+
+	// Resolve every deployment up front so a broken one does not fail the pipeline halfway
+	plans := make(map[uint]*deploymentPlan, len(project.ProjectDeployments))
+	for _, pd := range project.ProjectDeployments {
+		plan, err := s.prepareDeployment(pd.DeploymentID, userId, iv)
+		if err != nil {
+			return err
+		}
+		plans[pd.ID] = plan
+	}
+
+	for _, pd := range project.ProjectDeployments {
+		if err := s.ProjectsService.SetProjectDeploymentStatus(pd.ID, string(deployments.DeploymentStatusPending), ""); err != nil {
+			return fmt.Errorf("failed to update project deployment status: %w", err)
+		}
+	}
+
+	go s.runProject(project, plans, continueOnError)
+
+	return nil
+}
+
+func (s *ExecuteService) runProject(project projects.Project, plans map[uint]*deploymentPlan, continueOnError bool) {
+	failed := false
+	for _, group := range groupProjectDeployments(project.ProjectDeployments) {
+		if failed && !continueOnError {
+			for _, pd := range group {
+				s.setProjectDeploymentStatus(pd.ID, deployments.DeploymentStatusSkipped, "")
+			}
+			continue
+		}
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		for _, pd := range group {
+			wg.Add(1)
+			go func(pd projects.ProjectDeployments) {
+				defer wg.Done()
+				if err := s.runProjectDeployment(pd, plans[pd.ID]); err != nil {
+					fmt.Printf("ERROR: Project %d: deployment %d failed: %v\n", project.ID, pd.DeploymentID, err)
+					mutex.Lock()
+					failed = true
+					mutex.Unlock()
+				}
+			}(pd)
+		}
+		wg.Wait()
+	}
+	fmt.Printf("DEBUG: Project %d pipeline finished (failed=%v)\n", project.ID, failed)
+}
+
+func (s *ExecuteService) runProjectDeployment(pd projects.ProjectDeployments, plan *deploymentPlan) error {
+	s.setProjectDeploymentStatus(pd.ID, deployments.DeploymentStatusRunning, "")
+
+	executor, err := s.newExecutor("")
+	if err != nil {
+		s.setProjectDeploymentStatus(pd.ID, deployments.DeploymentStatusFailed, err.Error())
+		return err
+	}
+	if err := s.createDeploymentRun(plan); err != nil {
+		s.setProjectDeploymentStatus(pd.ID, deployments.DeploymentStatusFailed, err.Error())
+		return err
+	}
+
+	runErr := s.runDeployment(plan, executor)
+
+	status := deployments.DeploymentStatusSuccess
+	if runErr != nil {
+		status = deployments.DeploymentStatusFailed
+		if errors.Is(runErr, context.Canceled) {
+			status = deployments.DeploymentStatusCanceled
+		} else if errors.Is(runErr, context.DeadlineExceeded) {
+			status = deployments.DeploymentStatusTimedOut
+		}
+	}
+	logs, err := s.RunsService.GetRunLogs(plan.RunID)
+	if err != nil {
+		logs = fmt.Sprintf("failed to load logs of run %d: %v", plan.RunID, err)
+	}
+	s.setProjectDeploymentStatus(pd.ID, status, logs)
+	return runErr
+}
+
+func (s *ExecuteService) setProjectDeploymentStatus(id uint, status deployments.DeploymentStatus, logs string) {
+	if err := s.ProjectsService.SetProjectDeploymentStatus(id, string(status), logs); err != nil {
+		fmt.Printf("ERROR: Failed to update project deployment %d status: %v\n", id, err)
+	}
+}
+
+// groupProjectDeployments sorts the pipeline by Order and groups the deployments that share an order
+func groupProjectDeployments(projectDeployments []projects.ProjectDeployments) [][]projects.ProjectDeployments {
+	sorted := make([]projects.ProjectDeployments, len(projectDeployments))
+	copy(sorted, projectDeployments)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Order < sorted[j].Order
+	})
+
+	groups := make([][]projects.ProjectDeployments, 0)
+	for i, pd := range sorted {
+		if i == 0 || pd.Order != sorted[i-1].Order {
+			groups = append(groups, []projects.ProjectDeployments{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], pd)
+	}
+	return groups
+}
@@ -0,0 +1,188 @@
+package execute
+
+import (
+	"errors"
+	"fmt"
+
+	"deployer.com/modules/deployments"
+	"deployer.com/modules/scripts"
+	"deployer.com/modules/secrets"
+	"gorm.io/gorm"
+)
+
+// ErrSecretVersionMissing is returned when a revision cannot be rolled back to with the secrets it ran with
+var ErrSecretVersionMissing = errors.New("secret version of the revision is missing")
+
+// RollbackDeployment runs a deployment again as it was in an earlier revision, a revision of 0 picks the
+// newest successful revision before the latest one. The rollback is recorded as a new revision. Secrets are
+// deployed in the versions of the revision, with currentSecrets in their current versions instead
+func (s *ExecuteService) RollbackDeployment(id, userId uint, iv string, revisionNumber int, currentSecrets bool) (uint, int, error) {
+	var revision deployments.DeploymentRevision
+	var err error
+	if revisionNumber > 0 {
+		revision, err = s.DeploymentsService.GetRevision(id, userId, revisionNumber)
+	} else {
+		revision, err = s.DeploymentsService.GetRollbackRevision(id, userId)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	plan, err := s.prepareRevision(revision, userId, iv, currentSecrets)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	executor, err := s.newExecutor("")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := s.createDeploymentRun(plan); err != nil {
+		return 0, 0, err
+	}
+
+	go s.runDeployment(plan, executor)
+
+	return plan.RunID, revision.Revision, nil
+}
+
+// prepareRevision resolves the deployment with the settings, servers and resources of the revision. Container
+// images, compose files, script contents and secret versions come from the snapshot, credentials are read as
+// they are now
+func (s *ExecuteService) prepareRevision(revision deployments.DeploymentRevision, userId uint, iv string, currentSecrets bool) (*deploymentPlan, error) {
+	deployment, err := s.DeploymentsService.GetDeploymentEntity(revision.DeploymentID, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	snapshot := revision.Snapshot
+	snapshot.Apply(&deployment)
+	if len(deployment.Servers) == 0 {
+		return nil, fmt.Errorf("revision %d of deployment %s has no servers", revision.Revision, deployment.Name)
+	}
+
+	plan, err := s.resolveDeployment(deployment, userId, iv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve revision %d: %w", revision.Revision, err)
+	}
+	if !currentSecrets {
+		// The stacks below are rendered with these values as well
+		plan.Env, err = s.revisionEnv(revision, userId, iv)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	images := make(map[uint]deployments.RevisionContainer, len(snapshot.Containers))
+	for _, container := range snapshot.Containers {
+		images[container.ID] = container
+	}
+	for i := range plan.Containers {
+		image := images[plan.Containers[i].ID]
+		plan.Containers[i].Registry = image.Registry
+		plan.Containers[i].Image = image.Image
+		plan.Containers[i].Tag = image.Tag
+		plan.Containers[i].RunOptions = image.RunOptions
+	}
+
+	composeFiles := make(map[uint]deployments.RevisionStack, len(snapshot.Stacks))
+	for _, stack := range snapshot.Stacks {
+		composeFiles[stack.ID] = stack
+	}
+	for i := range plan.Stacks {
+		stack := composeFiles[plan.Stacks[i].ID]
+		decoded, err := s.EncryptionService.Decrypt(stack.Compose, iv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt stack %d of revision %d: %w", stack.ID, revision.Revision, err)
+		}
+		plan.Stacks[i].Compose = decoded
+		plan.Stacks[i].SecretIDs = stack.SecretIDs
+	}
+	if err := s.renderStacks(plan, userId, iv); err != nil {
+		return nil, err
+	}
+
+	if deployment.RunScripts {
+		for _, script := range snapshot.Scripts {
+			decoded, err := s.EncryptionService.Decrypt(script.Script, iv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt script %d of revision %d: %w", script.ID, revision.Revision, err)
+			}
+			plan.Scripts = append(plan.Scripts, scripts.ScriptResponse{
+				ID:      script.ID,
+				Name:    script.Name,
+				Script:  decoded,
+				Timeout: script.Timeout,
+			})
+		}
+	}
+
+	plan.Snapshot = snapshot
+	plan.RolledBackFrom = &revision.Revision
+	return plan, nil
+}
+
+// revisionEnv reads the secrets of the revision in the versions it ran with. A revision taken before secrets
+// had versions, or a version that is gone, is an error rather than a deployment of other values
+func (s *ExecuteService) revisionEnv(revision deployments.DeploymentRevision, userId uint, iv string) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, secret := range revision.Snapshot.Secrets {
+		if secret.Version == 0 {
+			return nil, fmt.Errorf("%w: revision %d does not record the version of secret %s",
+				ErrSecretVersionMissing, revision.Revision, secret.Name)
+		}
+		version, err := s.EnvsService.GetSecretVersion(secret.ID, userId, secret.Version, iv)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: version %d of secret %s of revision %d is gone",
+				ErrSecretVersionMissing, secret.Version, secret.Name, revision.Revision)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get version %d of secret %d: %w", secret.Version, secret.ID, err)
+		}
+		values := s.EnvsService.GetEnvMap(secrets.SecretResponse{Mode: version.Mode, Content: version.Content, Entries: version.Entries})
+		for key, value := range values {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
+
+// revisionSnapshot captures the resolved plan, script contents and compose files are kept encrypted with the key of the user
+func (s *ExecuteService) revisionSnapshot(plan *deploymentPlan, iv string) (deployments.RevisionSnapshot, error) {
+	snapshot := deployments.NewRevisionSnapshot(plan.Deployment)
+	for _, container := range plan.Containers {
+		snapshot.Containers = append(snapshot.Containers, deployments.RevisionContainer{
+			ID:         container.ID,
+			Name:       container.Name,
+			Registry:   container.Registry,
+			Image:      container.Image,
+			Tag:        container.Tag,
+			RunOptions: container.RunOptions,
+		})
+	}
+	for _, script := range plan.Scripts {
+		encrypted, err := s.EncryptionService.Encrypt(script.Script, iv)
+		if err != nil {
+			return deployments.RevisionSnapshot{}, fmt.Errorf("failed to encrypt script %d: %w", script.ID, err)
+		}
+		snapshot.Scripts = append(snapshot.Scripts, deployments.RevisionScript{
+			ID:      script.ID,
+			Name:    script.Name,
+			Script:  encrypted,
+			Timeout: script.Timeout,
+		})
+	}
+	for _, stack := range plan.Stacks {
+		encrypted, err := s.EncryptionService.Encrypt(stack.Compose, iv)
+		if err != nil {
+			return deployments.RevisionSnapshot{}, fmt.Errorf("failed to encrypt stack %d: %w", stack.ID, err)
+		}
+		snapshot.Stacks = append(snapshot.Stacks, deployments.RevisionStack{
+			ID:        stack.ID,
+			Name:      stack.Name,
+			Compose:   encrypted,
+			SecretIDs: stack.SecretIDs,
+		})
+	}
+	return snapshot, nil
+}
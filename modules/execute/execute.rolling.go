@@ -0,0 +1,97 @@
+package execute
+
+import (
+	"context"
+	"fmt"
+
+	"deployer.com/modules/deployments"
+	"deployer.com/modules/servers"
+)
+
+const (
+	// defaultHealthCheckTimeout is how long a health check or probe is retried when the deployment sets no timeout
+	defaultHealthCheckTimeout = 60
+	// healthCheckInterval is the pause in seconds between two attempts of a health check
+	healthCheckInterval = 5
+)
+
+// retryScriptTemplate runs a check as a shell function until it passes or the timeout is reached
+const retryScriptTemplate = `deployer_check() {
+%s
+}
+deadline=$(( $(date +%%s) + %d ))
+until deployer_check; do
+	if [ "$(date +%%s)" -ge "$deadline" ]; then
+		echo "check did not pass within %ds" >&2
+		exit 1
+	fi
+	sleep %d
+done`
+
+// retryScript builds a script retrying check for up to timeout seconds, 0 uses the default
+func retryScript(check string, timeout int) string {
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	return fmt.Sprintf(retryScriptTemplate, check, timeout, timeout, healthCheckInterval)
+}
+
+// runRollingDeployment deploys the servers batch by batch, the servers of a batch in parallel. A batch only
+// succeeds when every server passed its stages and the health check, otherwise the rollout halts and the
+// servers of the remaining batches are skipped
+func (s *ExecuteService) runRollingDeployment(ctx context.Context, plan *deploymentPlan, stages []deploymentStage, executor commandExecutor) error {
+	batches := rolloutBatches(plan.Deployment, plan.Servers)
+	for i, batch := range batches {
+		fmt.Printf("DEBUG: Deployment %d: rolling out batch %d of %d (%d servers)\n", plan.Deployment.ID, i+1, len(batches), len(batch))
+		err := s.fanOut(ctx, plan.RunID, batch, fanOutOptions{Concurrency: len(batch)}, "deploy", func(ctx context.Context, server servers.ServerResponse) error {
+			if err := s.runDeploymentStages(ctx, plan, stages, server, executor); err != nil {
+				return err
+			}
+			return s.runHealthCheck(ctx, plan, server, executor)
+		})
+		if err != nil {
+			reason := fmt.Sprintf("skipped: batch %d of %d failed", i+1, len(batches))
+			for _, rest := range batches[i+1:] {
+				for _, server := range rest {
+					s.skipStep(plan.RunID, server, "deploy", reason)
+				}
+			}
+			return fmt.Errorf("batch %d of %d: %w", i+1, len(batches), err)
+		}
+	}
+	return nil
+}
+
+// runHealthCheck runs the health check of the deployment on a freshly deployed server, a deployment without
+// a health check passes right away
+func (s *ExecuteService) runHealthCheck(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error {
+	if plan.Deployment.HealthCheck == "" {
+		return nil
+	}
+	config := s.serverConfig(server, executor)
+	config.Script = retryScript(plan.Deployment.HealthCheck, plan.Deployment.HealthCheckTimeout)
+	command, err := s.SSHRuner.CreateScriptRunner(&config)
+	if err != nil {
+		return err
+	}
+	return s.executeStep(ctx, plan.RunID, server, executor, "health_check", command)
+}
+
+// rolloutBatches splits the servers into batches of BatchSize servers, or BatchPercent of the servers
+// rounded up, one server per batch when neither is set
+func rolloutBatches(deployment deployments.Deployment, targets []servers.ServerResponse) [][]servers.ServerResponse {
+	size := deployment.BatchSize
+	if size <= 0 && deployment.BatchPercent > 0 {
+		size = (len(targets)*deployment.BatchPercent + 99) / 100
+	}
+	if size <= 0 {
+		size = 1
+	}
+
+	batches := make([][]servers.ServerResponse, 0, (len(targets)+size-1)/size)
+	for start := 0; start < len(targets); start += size {
+		end := min(start+size, len(targets))
+		batches = append(batches, targets[start:end])
+	}
+	return batches
+}
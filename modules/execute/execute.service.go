@@ -2,21 +2,33 @@ package execute
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"os"
+	"time"
 
 	"deployer.com/libs"
 	"deployer.com/modules/containers"
 	"deployer.com/modules/deployments"
+	"deployer.com/modules/domains"
+	"deployer.com/modules/execute/dto"
 	"deployer.com/modules/projects"
+	"deployer.com/modules/runs"
 	"deployer.com/modules/scripts"
 	"deployer.com/modules/secrets"
 	"deployer.com/modules/servers"
+	"deployer.com/modules/stacks"
 )
 
+// defaultRunTimeout stops runs of scripts and deployments without a timeout of their own
+const defaultRunTimeout = time.Hour
+
 type ExecuteService struct {
 	Docker             *libs.DockerComunication
 	SSHRuner           *libs.SSHRuner
+	SSHExecutor        *libs.SSHExecutor
+	Mode               ExecuteMode
 	EncryptionService  *libs.EncryptionService
 	ScriptsService     *scripts.ScriptsService
 	ServersService     *servers.ServersService
@@ -24,6 +36,9 @@ type ExecuteService struct {
 	ContainersService  *containers.ContainersService
 	DeploymentsService *deployments.DeploymentsService
 	ProjectsService    *projects.ProjectsService
+	DomainsService     *domains.DomainsService
+	StacksService      *stacks.StacksService
+	RunsService        *runs.RunsService
 }
 
 func NewExecuteService(scriptsService *scripts.ScriptsService,
@@ -32,11 +47,20 @@ func NewExecuteService(scriptsService *scripts.ScriptsService,
 	containersService *containers.ContainersService,
 	deploymentsService *deployments.DeploymentsService,
 	projectsService *projects.ProjectsService,
+	domainsService *domains.DomainsService,
+	stacksService *stacks.StacksService,
+	runsService *runs.RunsService,
 	docker *libs.DockerComunication,
 ) *ExecuteService {
 	sshRuner := libs.NewSSHRuner()
 	encryptionService := libs.NewEncryptionService()
 
+	// EXECUTE_MODE selects the default transport, "worker" (default) or "direct"
+	mode := ExecuteMode(os.Getenv("EXECUTE_MODE"))
+	if mode == "" {
+		mode = ExecuteModeWorker
+	}
+
 	return &ExecuteService{
 		ScriptsService:     scriptsService,
 		ServersService:     serversService,
@@ -44,104 +68,267 @@ func NewExecuteService(scriptsService *scripts.ScriptsService,
 		ContainersService:  containersService,
 		DeploymentsService: deploymentsService,
 		ProjectsService:    projectsService,
+		DomainsService:     domainsService,
+		StacksService:      stacksService,
+		RunsService:        runsService,
 		Docker:             docker,
 		SSHRuner:           sshRuner,
+		SSHExecutor:        libs.NewSSHExecutor(),
+		Mode:               mode,
 		EncryptionService:  encryptionService,
 	}
 }
 
-func (s *ExecuteService) RunScript(id, userId, serverId, envId uint, iv string, loadEnv bool) error {
-	script, err := s.ScriptsService.GetScript(id, userId, iv)
+func (s *ExecuteService) RunScript(userId uint, iv string, runScriptDto dto.RunScriptDto) (uint, error) {
+	script, err := s.ScriptsService.GetScript(runScriptDto.ScriptID, userId, iv)
 	if err != nil {
-		return fmt.Errorf("failed to get script: %w", err)
+		return 0, fmt.Errorf("failed to get script: %w", err)
 	}
 
-	server, err := s.ServersService.GetServer(serverId, userId, iv)
+	ids := make([]uint, 0, len(runScriptDto.ServerIDs)+1)
+	if runScriptDto.ServerID != 0 {
+		ids = append(ids, runScriptDto.ServerID)
+	}
+	targets, err := s.resolveServers(userId, iv, append(ids, runScriptDto.ServerIDs...), runScriptDto.DeploymentID)
 	if err != nil {
-		return fmt.Errorf("failed to get server: %w", err)
+		return 0, err
 	}
 
 	var envMap map[string]string
-	if loadEnv {
-		env, err := s.EnvsService.GetSecret(envId, userId, iv)
+	if runScriptDto.LoadEnv {
+		env, err := s.EnvsService.GetSecret(runScriptDto.EnvID, userId, iv)
 		if err != nil {
-			return fmt.Errorf("failed to get secrets: %w", err)
+			return 0, fmt.Errorf("failed to get secrets: %w", err)
 		}
 		envMap = s.EnvsService.GetEnvMap(env)
 	}
 
-	config := libs.SSHRunerConfig{
-		IP:                 server.Host,
-		User:               server.Username,
-		Password:           server.Password,
-		Script:             script.Script,
-		Env:                &envMap,
-		SetSecretsToScript: &loadEnv,
+	// Fail early when no worker is available, every server picks its own executor later
+	mode := ExecuteMode(runScriptDto.Mode)
+	if _, err := s.newExecutor(mode); err != nil {
+		return 0, err
+	}
+
+	run := runs.Run{
+		Type:     runs.RunTypeScript,
+		UserID:   userId,
+		ScriptID: &script.ID,
+		Timeout:  runTimeout(script.Timeout, runScriptDto.Timeout),
+	}
+	if len(targets) == 1 {
+		run.ServerID = &targets[0].ID
+	}
+	if err := s.RunsService.CreateRun(&run); err != nil {
+		return 0, fmt.Errorf("failed to create run: %w", err)
+	}
+
+	fmt.Printf("DEBUG: Executing script %d on %d server(s) (run %d)\n", script.ID, len(targets), run.ID)
+
+	fanOut := fanOutOptions{Concurrency: runScriptDto.Concurrency, MaxFailures: runScriptDto.MaxFailures}
+	go func() {
+		if err := s.RunsService.StartRun(run.ID); err != nil {
+			fmt.Printf("ERROR: Failed to mark run %d as running: %v\n", run.ID, err)
+		}
+		ctx := s.RunsService.Context(run.ID)
+		s.finishRun(run.ID, s.fanOut(ctx, run.ID, targets, fanOut, "script", func(ctx context.Context, server servers.ServerResponse) error {
+			executor, err := s.newExecutor(mode)
+			if err != nil {
+				return err
+			}
+			return s.runScriptOnServer(ctx, run.ID, server, executor, script, envMap, runScriptDto.LoadEnv)
+		}))
+	}()
+
+	return run.ID, nil
+}
+
+// resolveServers collects the servers given by id and the servers of the deployment, without duplicates
+func (s *ExecuteService) resolveServers(userId uint, iv string, ids []uint, deploymentId *uint) ([]servers.ServerResponse, error) {
+	if deploymentId != nil {
+		deployment, err := s.DeploymentsService.GetDeploymentEntity(*deploymentId, userId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get deployment: %w", err)
+		}
+		for _, server := range deployment.Servers {
+			ids = append(ids, server.ID)
+		}
+	}
+
+	seen := make(map[uint]bool, len(ids))
+	result := make([]servers.ServerResponse, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		server, err := s.ServersService.GetServer(id, userId, iv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get server %d: %w", id, err)
+		}
+		result = append(result, server)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no servers to run on")
+	}
+	return result, nil
+}
+
+// runScriptOnServer verifies the host key, tests the connection and runs the script on a single server
+func (s *ExecuteService) runScriptOnServer(ctx context.Context, runId uint, server servers.ServerResponse, executor commandExecutor, script scripts.ScriptResponse, envMap map[string]string, loadEnv bool) error {
+	fmt.Printf("DEBUG: Run %d: executing script %d on server %s@%s using %s\n",
+		runId, script.ID, server.Username, server.Host, executor.Describe())
+
+	// The commands carry the verified host key, so they are built once it is checked
+	if err := s.verifyHostKey(ctx, runId, &server); err != nil {
+		return err
+	}
+
+	testConfig := s.serverConfig(server, executor)
+	testConfig.Script = "echo 'SSH connection test successful'"
+	testCommand, err := s.SSHRuner.CreateScriptRunner(&testConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create script runner: %w", err)
 	}
 
-	worker := s.getWorker()
-	if worker == nil {
-		return fmt.Errorf("no deployment worker containers available")
+	// Test SSH connectivity first so authentication problems show up as their own step
+	if err := s.executeStep(ctx, runId, server, executor, "connection_test", testCommand); err != nil {
+		return err
 	}
 
+	config := s.serverConfig(server, executor)
+	config.Script = script.Script
+	config.Env = &envMap
+	config.SetSecretsToScript = &loadEnv
+	if err := s.uploadEnvFile(ctx, runId, server, executor, &config, "upload_env"); err != nil {
+		return err
+	}
 	command, err := s.SSHRuner.CreateScriptRunner(&config)
 	if err != nil {
 		return fmt.Errorf("failed to create script runner: %w", err)
 	}
+	return s.executeStep(ctx, runId, server, executor, "script", command)
+}
 
-	// Log the execution details for debugging
-	fmt.Printf("DEBUG: Executing script on server %s@%s using worker %s\n",
-		server.Username, server.Host, worker.ID)
-	fmt.Printf("DEBUG: Generated SSH command: %s\n", command)
+// executeStep runs a command on the server and records its output as a step of the run
+func (s *ExecuteService) executeStep(ctx context.Context, runId uint, server servers.ServerResponse, executor commandExecutor, name, command string) error {
+	return s.executeInputStep(ctx, runId, server, executor, name, command, nil)
+}
 
-	go func() {
-		// Test SSH connectivity first
-		testCommand := "echo 'SSH connection test successful'"
-		testSSHCommand := fmt.Sprintf("sshpass -p %s ssh -o StrictHostKeyChecking=no %s@%s %s",
-			server.Password, server.Username, server.Host, testCommand)
-
-		fmt.Printf("DEBUG: Testing SSH connectivity to %s@%s...\n", server.Username, server.Host)
-		fmt.Printf("DEBUG: Test command: %s\n", testSSHCommand)
-		testResult, testErr := s.Docker.ExecuteCommand(context.Background(), worker.ID, testSSHCommand)
-		if testErr != nil {
-			fmt.Printf("ERROR: SSH connectivity test failed: %v\n", testErr)
-			fmt.Printf("DEBUG: Test command output: %s\n", testResult)
-			return
-		}
+// executeInputStep runs a command like executeStep with input passed as its stdin
+func (s *ExecuteService) executeInputStep(ctx context.Context, runId uint, server servers.ServerResponse, executor commandExecutor, name, command string, input []byte) error {
+	// A canceled run does not start further steps
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	step, err := s.RunsService.StartStep(runId, &server.ID, name)
+	if err != nil {
+		return fmt.Errorf("failed to record step %s: %w", name, err)
+	}
 
-		if testResult != "" && testResult != "SSH connection test successful\n" {
-			fmt.Printf("WARNING: SSH test returned unexpected output: %s\n", testResult)
-		} else {
-			fmt.Printf("DEBUG: SSH connectivity test passed\n")
+	result, err := executor.ExecuteWithInput(ctx, server, command, input, func(stream, line string) {
+		s.RunsService.PublishOutput(runId, step.ID, stream, line)
+	})
+	if err != nil {
+		stdout, stderr := "", ""
+		if result != nil {
+			stdout, stderr = result.Stdout, result.Stderr
 		}
+		if finishErr := s.RunsService.FinishStep(step, failedStatus(ctx), stdout, stderr, nil, err.Error()); finishErr != nil {
+			fmt.Printf("ERROR: Failed to record step %d: %v\n", step.ID, finishErr)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
 
-		// Execute the actual script
-		fmt.Printf("DEBUG: Executing actual script...\n")
-		fmt.Printf("DEBUG: Script content: %s\n", script.Script)
-		rs, err := s.Docker.ExecuteCommand(context.Background(), worker.ID, command)
-		if err != nil {
-			fmt.Printf("ERROR: Failed to execute command in container: %v\n", err)
-			return
+	status := runs.RunStatusSuccess
+	errMessage := ""
+	if result.ExitCode != 0 {
+		err = newExitError(name, result.ExitCode, executor.Direct())
+		status = runs.RunStatusFailed
+		errMessage = err.Error()
+	}
+	if finishErr := s.RunsService.FinishStep(step, status, result.Stdout, result.Stderr, &result.ExitCode, errMessage); finishErr != nil {
+		fmt.Printf("ERROR: Failed to record step %d: %v\n", step.ID, finishErr)
+	}
+	return err
+}
+
+func (s *ExecuteService) serverConfig(server servers.ServerResponse, executor commandExecutor) libs.SSHRunerConfig {
+	knownHosts := s.knownHosts(server)
+	config := libs.SSHRunerConfig{
+		IP:         server.Host,
+		Port:       server.Port,
+		User:       server.Username,
+		Password:   server.Password,
+		KnownHosts: &knownHosts,
+		Direct:     executor.Direct(),
+	}
+	// The native client reads the key from the server itself, the worker gets an unlocked copy on stdin
+	if !executor.Direct() {
+		if sshKey := workerKey(server); sshKey != "" {
+			config.SSHKey = &sshKey
 		}
+	}
+	return config
+}
 
-		// Check if the output contains permission denied errors
-		if rs != "" {
-			fmt.Printf("Command executed. Output: %s\n", rs)
-			if rs == "Permission denied, please try again.\n" ||
-				rs == "&Permission denied, please try again.\n" {
-				fmt.Printf("ERROR: SSH authentication failed. Please check:\n")
-				fmt.Printf("  1. Username: %s\n", server.Username)
-				fmt.Printf("  2. Server IP: %s\n", server.Host)
-				fmt.Printf("  3. Password is correct\n")
-				fmt.Printf("  4. SSH server allows password authentication\n")
-				fmt.Printf("  5. Network connectivity from container to server\n")
-			}
-		} else {
-			fmt.Printf("Command executed successfully with no output\n")
+// workerKey returns the unlocked private key of the server for a deploy-worker, empty without a usable key
+func workerKey(server servers.ServerResponse) string {
+	if server.SSHKey == "" {
+		return ""
+	}
+	sshKey, err := libs.UnencryptedPrivateKey(server.SSHKey, server.SSHKeyPassphrase)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to decode ssh key of server %d: %v\n", server.ID, err)
+		return ""
+	}
+	return sshKey
+}
+
+// finishRun stores the final status of a run based on the error returned by its last step
+func (s *ExecuteService) finishRun(runId uint, runErr error) {
+	status := runs.RunStatusSuccess
+	errMessage := ""
+	exitCode := new(int)
+	if runErr != nil {
+		status = runs.RunStatusFailed
+		errMessage = runErr.Error()
+		if errors.Is(runErr, context.Canceled) {
+			status = runs.RunStatusCanceled
+		} else if errors.Is(runErr, context.DeadlineExceeded) {
+			status = runs.RunStatusTimedOut
+			errMessage = "run timed out: " + errMessage
 		}
-	}()
+		exitCode = nil
+		var exitErr *exitError
+		if errors.As(runErr, &exitErr) {
+			exitCode = &exitErr.Code
+		}
+	}
+	if err := s.RunsService.FinishRun(runId, status, exitCode, errMessage); err != nil {
+		fmt.Printf("ERROR: Failed to finish run %d: %v\n", runId, err)
+	}
+}
 
-	return nil
+// failedStatus records steps interrupted by a cancellation or the run timeout as such instead of failed
+func failedStatus(ctx context.Context) runs.RunStatus {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return runs.RunStatusCanceled
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return runs.RunStatusTimedOut
+	}
+	return runs.RunStatusFailed
+}
+
+// runTimeout picks the timeout of a run in seconds: the override, the configured timeout or the default
+func runTimeout(configured int, override *int) int {
+	if override != nil && *override > 0 {
+		return *override
+	}
+	if configured > 0 {
+		return configured
+	}
+	return int(defaultRunTimeout / time.Second)
 }
 
 func (s *ExecuteService) getWorker() *libs.Container {
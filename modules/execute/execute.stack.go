@@ -0,0 +1,290 @@
+package execute
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"deployer.com/modules/execute/dto"
+	"deployer.com/modules/runs"
+	"deployer.com/modules/servers"
+	"deployer.com/modules/stacks"
+)
+
+// stackStatusTimeout bounds the synchronous status request over all servers
+const stackStatusTimeout = 30 * time.Second
+
+type stackAction string
+
+const (
+	stackActionUp   stackAction = "stack_up"
+	stackActionDown stackAction = "stack_down"
+)
+
+// stackPlan is a stack with its compose file interpolated, ready to be uploaded
+type stackPlan struct {
+	stacks.StackResponse
+	File string
+}
+
+// StackServerStatus is the state of the services of a stack on one server
+type StackServerStatus struct {
+	ServerID   uint                 `json:"server_id"`
+	ServerName string               `json:"server_name"`
+	Services   []StackServiceStatus `json:"services"`
+	Error      string               `json:"error,omitempty"`
+}
+
+// StackServiceStatus is a container of the stack as reported by docker compose ps
+type StackServiceStatus struct {
+	Service  string `json:"service"`
+	Name     string `json:"name"`
+	Image    string `json:"image"`
+	State    string `json:"state"`
+	Status   string `json:"status"`
+	Health   string `json:"health"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// DeployStack uploads the compose file of the stack to the servers and brings it up there
+func (s *ExecuteService) DeployStack(id, userId uint, iv string, runStackDto dto.RunStackDto) (uint, error) {
+	return s.runStack(id, userId, iv, runStackDto, stackActionUp)
+}
+
+// StopStack takes the stack down on the servers and removes its compose file
+func (s *ExecuteService) StopStack(id, userId uint, iv string, runStackDto dto.RunStackDto) (uint, error) {
+	return s.runStack(id, userId, iv, runStackDto, stackActionDown)
+}
+
+func (s *ExecuteService) runStack(id, userId uint, iv string, runStackDto dto.RunStackDto, action stackAction) (uint, error) {
+	stack, err := s.StacksService.GetStack(id, userId, iv)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get stack: %w", err)
+	}
+	targets, err := s.resolveServers(userId, iv, runStackDto.ServerIDs, runStackDto.DeploymentID)
+	if err != nil {
+		return 0, err
+	}
+
+	plan := stackPlan{StackResponse: stack}
+	if action == stackActionUp {
+		if plan.File, err = s.renderStack(stack, userId, iv, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	mode := ExecuteMode(runStackDto.Mode)
+	if _, err := s.newExecutor(mode); err != nil {
+		return 0, err
+	}
+
+	run := runs.Run{
+		Type:    runs.RunTypeStack,
+		UserID:  userId,
+		StackID: &stack.ID,
+		Timeout: runTimeout(0, runStackDto.Timeout),
+	}
+	if len(targets) == 1 {
+		run.ServerID = &targets[0].ID
+	}
+	if err := s.RunsService.CreateRun(&run); err != nil {
+		return 0, fmt.Errorf("failed to create run: %w", err)
+	}
+
+	fmt.Printf("DEBUG: Running %s of stack %d on %d server(s) (run %d)\n", action, stack.ID, len(targets), run.ID)
+
+	fanOut := fanOutOptions{Concurrency: runStackDto.Concurrency, MaxFailures: runStackDto.MaxFailures}
+	go func() {
+		if err := s.RunsService.StartRun(run.ID); err != nil {
+			fmt.Printf("ERROR: Failed to mark run %d as running: %v\n", run.ID, err)
+		}
+		ctx := s.RunsService.Context(run.ID)
+		runErr := s.fanOut(ctx, run.ID, targets, fanOut, string(action), func(ctx context.Context, server servers.ServerResponse) error {
+			executor, err := s.newExecutor(mode)
+			if err != nil {
+				return err
+			}
+			if err := s.verifyHostKey(ctx, run.ID, &server); err != nil {
+				return err
+			}
+			return s.runStackAction(ctx, run.ID, server, executor, plan, action)
+		})
+		if runErr == nil && action == stackActionUp {
+			if err := s.StacksService.SetStackDeployed(stack.ID); err != nil {
+				fmt.Printf("ERROR: Failed to update stack %d: %v\n", stack.ID, err)
+			}
+		}
+		s.finishRun(run.ID, runErr)
+	}()
+
+	return run.ID, nil
+}
+
+// runStackAction brings the stack up or down on a server whose host key is verified
+func (s *ExecuteService) runStackAction(ctx context.Context, runId uint, server servers.ServerResponse, executor commandExecutor, plan stackPlan, action stackAction) error {
+	config := s.serverConfig(server, executor)
+	config.ComposeProject = &plan.Name
+	var command string
+	var input []byte
+	var err error
+	if action == stackActionUp {
+		// The rendered compose file is the input of the command
+		command, err = s.SSHRuner.ComposeUpCommand(&config)
+		input = []byte(plan.File)
+	} else {
+		command, err = s.SSHRuner.ComposeDownCommand(&config)
+	}
+	if err != nil {
+		return err
+	}
+	return s.executeInputStep(ctx, runId, server, executor, fmt.Sprintf("%s:%s", action, plan.Name), command, input)
+}
+
+// StackStatus asks every server for the containers of the stack, a server that cannot be reached reports
+// its error instead of failing the request
+func (s *ExecuteService) StackStatus(id, userId uint, iv string, serverIds []uint, deploymentId *uint) ([]StackServerStatus, error) {
+	stack, err := s.StacksService.GetStack(id, userId, iv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stack: %w", err)
+	}
+	targets, err := s.resolveServers(userId, iv, serverIds, deploymentId)
+	if err != nil {
+		return nil, err
+	}
+	executor, err := s.newExecutor("")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stackStatusTimeout)
+	defer cancel()
+
+	result := make([]StackServerStatus, len(targets))
+	var wg sync.WaitGroup
+	for i, server := range targets {
+		wg.Add(1)
+		go func(i int, server servers.ServerResponse) {
+			defer wg.Done()
+			result[i] = StackServerStatus{ServerID: server.ID, ServerName: server.Name, Services: []StackServiceStatus{}}
+			services, err := s.stackServerStatus(ctx, server, executor, stack.Name)
+			if err != nil {
+				result[i].Error = err.Error()
+				return
+			}
+			result[i].Services = services
+		}(i, server)
+	}
+	wg.Wait()
+	return result, nil
+}
+
+func (s *ExecuteService) stackServerStatus(ctx context.Context, server servers.ServerResponse, executor commandExecutor, project string) ([]StackServiceStatus, error) {
+	if _, err := s.checkHostKey(ctx, &server); err != nil {
+		return nil, err
+	}
+	config := s.serverConfig(server, executor)
+	config.ComposeProject = &project
+	command, err := s.SSHRuner.ComposeStatusCommand(&config)
+	if err != nil {
+		return nil, err
+	}
+	result, err := executor.Execute(ctx, server, command, nil)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("docker compose ps exited with code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return parseComposeStatus(result.Stdout)
+}
+
+// parseComposeStatus reads docker compose ps --format json, older releases print one array,
+// newer ones an object per line
+func parseComposeStatus(output string) ([]StackServiceStatus, error) {
+	type composeContainer struct {
+		Service  string
+		Name     string
+		Image    string
+		State    string
+		Status   string
+		Health   string
+		ExitCode int
+	}
+
+	containers := make([]composeContainer, 0)
+	output = strings.TrimSpace(output)
+	if strings.HasPrefix(output, "[") {
+		if err := json.Unmarshal([]byte(output), &containers); err != nil {
+			return nil, fmt.Errorf("failed to parse docker compose ps output: %w", err)
+		}
+	} else {
+		for _, line := range strings.Split(output, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			var container composeContainer
+			if err := json.Unmarshal([]byte(line), &container); err != nil {
+				return nil, fmt.Errorf("failed to parse docker compose ps output: %w", err)
+			}
+			containers = append(containers, container)
+		}
+	}
+
+	services := make([]StackServiceStatus, len(containers))
+	for i, container := range containers {
+		services[i] = StackServiceStatus(container)
+	}
+	return services, nil
+}
+
+// renderStack interpolates the compose file of the stack with its secrets, overrides take precedence
+func (s *ExecuteService) renderStack(stack stacks.StackResponse, userId uint, iv string, overrides map[string]string) (string, error) {
+	env := make(map[string]string)
+	for _, secretId := range stack.SecretIDs {
+		secret, err := s.EnvsService.GetSecret(secretId, userId, iv)
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %d of stack %s: %w", secretId, stack.Name, err)
+		}
+		for key, value := range s.EnvsService.GetEnvMap(secret) {
+			env[key] = value
+		}
+	}
+	for key, value := range overrides {
+		env[key] = value
+	}
+	file, err := stacks.RenderCompose(stack.Compose, env)
+	if err != nil {
+		return "", fmt.Errorf("failed to render stack %s: %w", stack.Name, err)
+	}
+	return file, nil
+}
+
+// renderStacks interpolates the stacks of a deployment, the secrets of the deployment override the ones of
+// the stacks
+func (s *ExecuteService) renderStacks(plan *deploymentPlan, userId uint, iv string) error {
+	for i := range plan.Stacks {
+		file, err := s.renderStack(plan.Stacks[i].StackResponse, userId, iv, plan.Env)
+		if err != nil {
+			return err
+		}
+		plan.Stacks[i].File = file
+	}
+	return nil
+}
+
+// runStacksStage brings up the stacks of the deployment after its containers
+func (s *ExecuteService) runStacksStage(ctx context.Context, plan *deploymentPlan, server servers.ServerResponse, executor commandExecutor) error {
+	for _, stack := range plan.Stacks {
+		if err := s.runStackAction(ctx, plan.RunID, server, executor, stack, stackActionUp); err != nil {
+			return err
+		}
+		if err := s.StacksService.SetStackDeployed(stack.ID); err != nil {
+			fmt.Printf("ERROR: Failed to update stack %d: %v\n", stack.ID, err)
+		}
+	}
+	return nil
+}
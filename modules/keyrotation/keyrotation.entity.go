@@ -0,0 +1,14 @@
+package keyrotation
+
+import "time"
+
+// KeyRotation is the progress of re-encrypting one table with a key, a rerun with the same key continues
+// after LastID
+type KeyRotation struct {
+	Target      string     `gorm:"primaryKey" json:"target"`
+	KeyID       string     `gorm:"not null" json:"key_id"`
+	LastID      uint       `gorm:"not null;default:0" json:"last_id"`
+	Rotated     int64      `gorm:"not null;default:0" json:"rotated"`
+	CompletedAt *time.Time `json:"completed_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,289 @@
+package keyrotation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/deployments"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rotationTarget is a table with encrypted columns, the values are encrypted with the IV of the owning user
+type rotationTarget struct {
+	Table   string
+	Columns []string
+	// OwnIV is set for the users table, which holds the IV itself
+	OwnIV bool
+	// UserJoin joins the owning user as u when the table has no user_id column
+	UserJoin string
+}
+
+var rotationTargets = []rotationTarget{
+	{Table: "secrets", Columns: []string{"content"}},
+	{Table: "secret_versions", Columns: []string{"content"}, UserJoin: "JOIN secrets s ON s.id = t.secret_id JOIN users u ON u.id = s.user_id"},
+	{Table: "servers", Columns: []string{"password", "ssh_key", "ssh_key_passphrase"}},
+	{Table: "server_keys", Columns: []string{"private_key"}},
+	{Table: "containers", Columns: []string{"password", "secret_key"}},
+	{Table: "domains", Columns: []string{"ssl_cert", "ssl_key"}},
+	{Table: "scripts", Columns: []string{"script"}},
+	{Table: "stacks", Columns: []string{"compose"}},
+	{Table: "users", Columns: []string{"api_key"}, OwnIV: true},
+}
+
+// revisionsTarget holds script contents and compose files encrypted inside the snapshot JSON
+const revisionsTarget = "deployment_revisions"
+
+type RotateOptions struct {
+	BatchSize int
+	// Restart ignores the stored progress and checks every row again
+	Restart bool
+	// SkipInvalid leaves values that cannot be decrypted as they are instead of stopping
+	SkipInvalid bool
+}
+
+type KeyRotationService struct {
+	db                *gorm.DB
+	encryptionService *libs.EncryptionService
+}
+
+func NewKeyRotationService(db *gorm.DB, encryptionService *libs.EncryptionService) *KeyRotationService {
+	return &KeyRotationService{db: db, encryptionService: encryptionService}
+}
+
+// Rotate re-encrypts every encrypted value that does not use the active key yet. Every batch is committed
+// with its progress, so an interrupted run continues where it stopped
+func (s *KeyRotationService) Rotate(keyID string, options RotateOptions) error {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	for _, target := range rotationTargets {
+		if err := s.rotateTarget(target.Table, keyID, options, func(tx *gorm.DB, lastID uint) (uint, int64, int, error) {
+			return s.rotateColumns(tx, target, lastID, options)
+		}); err != nil {
+			return err
+		}
+	}
+	return s.rotateTarget(revisionsTarget, keyID, options, func(tx *gorm.DB, lastID uint) (uint, int64, int, error) {
+		return s.rotateRevisions(tx, lastID, options)
+	})
+}
+
+// rotateTarget runs batches until a batch comes back empty, batch returns the last id it read, the number of
+// rotated values and the number of rows read
+func (s *KeyRotationService) rotateTarget(name, keyID string, options RotateOptions, batch func(tx *gorm.DB, lastID uint) (uint, int64, int, error)) error {
+	progress, err := s.progress(name, keyID, options.Restart)
+	if err != nil {
+		return err
+	}
+	if progress.CompletedAt != nil {
+		fmt.Printf("DEBUG: %s is already re-encrypted with key %s\n", name, keyID)
+		return nil
+	}
+
+	for {
+		rows := 0
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			lastID, rotated, read, err := batch(tx, progress.LastID)
+			if err != nil {
+				return err
+			}
+			rows = read
+			progress.LastID = lastID
+			progress.Rotated += rotated
+			if rows == 0 {
+				now := time.Now()
+				progress.CompletedAt = &now
+			}
+			return tx.Save(&progress).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to re-encrypt %s after id %d: %w", name, progress.LastID, err)
+		}
+		if rows == 0 {
+			fmt.Printf("DEBUG: Re-encrypted %d values of %s with key %s\n", progress.Rotated, name, keyID)
+			return nil
+		}
+		fmt.Printf("DEBUG: %s: re-encrypted up to id %d\n", name, progress.LastID)
+	}
+}
+
+// progress loads the stored progress of a target, progress made with another key starts over
+func (s *KeyRotationService) progress(name, keyID string, restart bool) (KeyRotation, error) {
+	var progress KeyRotation
+	err := s.db.Where("target = ?", name).First(&progress).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return KeyRotation{}, fmt.Errorf("failed to load progress of %s: %w", name, err)
+	}
+	if err != nil || restart || progress.KeyID != keyID {
+		progress = KeyRotation{Target: name, KeyID: keyID}
+	}
+	return progress, nil
+}
+
+func (s *KeyRotationService) rotateColumns(tx *gorm.DB, target rotationTarget, lastID uint, options RotateOptions) (uint, int64, int, error) {
+	columns := make([]string, len(target.Columns))
+	for i, column := range target.Columns {
+		columns[i] = "t." + column
+	}
+	query := fmt.Sprintf("SELECT t.id, t.iv, %s FROM %s t WHERE t.id > ? ORDER BY t.id LIMIT ?",
+		strings.Join(columns, ", "), target.Table)
+	if !target.OwnIV {
+		join := target.UserJoin
+		if join == "" {
+			join = "JOIN users u ON u.id = t.user_id"
+		}
+		query = fmt.Sprintf("SELECT t.id, u.iv, %s FROM %s t %s WHERE t.id > ? ORDER BY t.id LIMIT ?",
+			strings.Join(columns, ", "), target.Table, join)
+	}
+	// Locked so a value changed by the app meanwhile is not overwritten with an older one
+	rows, err := tx.Raw(query+" FOR UPDATE OF t", lastID, options.BatchSize).Rows()
+	if err != nil {
+		return lastID, 0, 0, err
+	}
+
+	type row struct {
+		id     uint
+		iv     string
+		values []sql.NullString
+	}
+	batch := make([]row, 0, options.BatchSize)
+	for rows.Next() {
+		r := row{values: make([]sql.NullString, len(target.Columns))}
+		dest := []interface{}{&r.id, &r.iv}
+		for i := range r.values {
+			dest = append(dest, &r.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return lastID, 0, 0, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return lastID, 0, 0, err
+	}
+
+	var rotated int64
+	for _, r := range batch {
+		updates := make(map[string]interface{})
+		for i, column := range target.Columns {
+			if !r.values[i].Valid {
+				continue
+			}
+			value, changed, err := s.rotateValue(fmt.Sprintf("%s %d: %s", target.Table, r.id, column), r.values[i].String, r.iv, options)
+			if err != nil {
+				return lastID, 0, 0, err
+			}
+			if changed {
+				updates[column] = value
+			}
+		}
+		if len(updates) > 0 {
+			// UpdateColumns keeps updated_at, the values did not change
+			if err := tx.Table(target.Table).Where("id = ?", r.id).UpdateColumns(updates).Error; err != nil {
+				return lastID, 0, 0, err
+			}
+			rotated += int64(len(updates))
+		}
+		lastID = r.id
+	}
+	return lastID, rotated, len(batch), nil
+}
+
+func (s *KeyRotationService) rotateRevisions(tx *gorm.DB, lastID uint, options RotateOptions) (uint, int64, int, error) {
+	var revisions []deployments.DeploymentRevision
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id > ?", lastID).Order("id").Limit(options.BatchSize).
+		Find(&revisions).Error; err != nil {
+		return lastID, 0, 0, err
+	}
+
+	ivs, err := s.userIVs(tx, revisions)
+	if err != nil {
+		return lastID, 0, 0, err
+	}
+
+	var rotated int64
+	for _, revision := range revisions {
+		iv := ivs[revision.UserID]
+		count := 0
+		for i, script := range revision.Snapshot.Scripts {
+			value, changed, err := s.rotateValue(fmt.Sprintf("revision %d: script %d", revision.ID, script.ID), script.Script, iv, options)
+			if err != nil {
+				return lastID, 0, 0, err
+			}
+			if changed {
+				revision.Snapshot.Scripts[i].Script = value
+				count++
+			}
+		}
+		for i, stack := range revision.Snapshot.Stacks {
+			value, changed, err := s.rotateValue(fmt.Sprintf("revision %d: stack %d", revision.ID, stack.ID), stack.Compose, iv, options)
+			if err != nil {
+				return lastID, 0, 0, err
+			}
+			if changed {
+				revision.Snapshot.Stacks[i].Compose = value
+				count++
+			}
+		}
+		if count > 0 {
+			snapshot, err := json.Marshal(revision.Snapshot)
+			if err != nil {
+				return lastID, 0, 0, err
+			}
+			if err := tx.Table(revisionsTarget).Where("id = ?", revision.ID).UpdateColumn("snapshot", string(snapshot)).Error; err != nil {
+				return lastID, 0, 0, err
+			}
+			rotated += int64(count)
+		}
+		lastID = revision.ID
+	}
+	return lastID, rotated, len(revisions), nil
+}
+
+func (s *KeyRotationService) userIVs(tx *gorm.DB, revisions []deployments.DeploymentRevision) (map[uint]string, error) {
+	ids := make([]uint, 0, len(revisions))
+	for _, revision := range revisions {
+		ids = append(ids, revision.UserID)
+	}
+	var users []struct {
+		ID uint
+		IV string
+	}
+	if len(ids) > 0 {
+		if err := tx.Table("users").Select("id, iv").Where("id IN ?", ids).Scan(&users).Error; err != nil {
+			return nil, err
+		}
+	}
+	ivs := make(map[uint]string, len(users))
+	for _, user := range users {
+		ivs[user.ID] = user.IV
+	}
+	return ivs, nil
+}
+
+// rotateValue returns the value encrypted with the active key and whether it changed, name identifies the
+// value in errors
+func (s *KeyRotationService) rotateValue(name, value, iv string, options RotateOptions) (string, bool, error) {
+	current, err := s.encryptionService.IsCurrent(value)
+	if err != nil || current {
+		return value, false, err
+	}
+	rotated, err := s.encryptionService.Reencrypt(value, iv)
+	if err != nil {
+		if options.SkipInvalid {
+			fmt.Printf("ERROR: Skipping %s, it cannot be decrypted: %v\n", name, err)
+			return value, false, nil
+		}
+		return "", false, fmt.Errorf("%s: %w", name, err)
+	}
+	return rotated, true, nil
+}
@@ -328,3 +328,20 @@ func (s *ProjectsService) DeleteProject(id, userId uint) error {
 
 	return tx.Commit().Error
 }
+
+// GetProjectEntity returns the raw project with its deployment rows, used by the execute module
+func (s *ProjectsService) GetProjectEntity(id, userId uint) (Project, error) {
+	var project Project
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).Preload("ProjectDeployments").First(&project).Error; err != nil {
+		return Project{}, err
+	}
+	return project, nil
+}
+
+// SetProjectDeploymentStatus stores the status and captured logs of a single pipeline step
+func (s *ProjectsService) SetProjectDeploymentStatus(id uint, status string, logs string) error {
+	return s.db.Model(&ProjectDeployments{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status": status,
+		"logs":   logs,
+	}).Error
+}
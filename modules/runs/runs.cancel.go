@@ -0,0 +1,103 @@
+package runs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// runCancels holds the cancel functions of the runs executed by this process and
+// the channels closed once they finish
+type runCancels struct {
+	mutex    sync.Mutex
+	contexts map[uint]context.Context
+	cancels  map[uint]context.CancelFunc
+	timeouts map[uint]time.Duration
+	done     map[uint]chan struct{}
+}
+
+func newRunCancels() *runCancels {
+	return &runCancels{
+		contexts: make(map[uint]context.Context),
+		cancels:  make(map[uint]context.CancelFunc),
+		timeouts: make(map[uint]time.Duration),
+		done:     make(map[uint]chan struct{}),
+	}
+}
+
+// open creates the context of a pending run, the timeout only starts counting once the run starts. A zero
+// timeout means no deadline
+func (c *runCancels) open(runId uint, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.contexts[runId] = ctx
+	c.cancels[runId] = cancel
+	c.timeouts[runId] = timeout
+	c.done[runId] = make(chan struct{})
+}
+
+// start arms the deadline of a run, the context of the run is replaced by one carrying the deadline
+func (c *runCancels) start(runId uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	parent, ok := c.contexts[runId]
+	timeout := c.timeouts[runId]
+	if !ok || timeout <= 0 {
+		return
+	}
+	ctx, cancelTimeout := context.WithTimeout(parent, timeout)
+	cancelParent := c.cancels[runId]
+	c.contexts[runId] = ctx
+	c.cancels[runId] = func() {
+		cancelTimeout()
+		cancelParent()
+	}
+	// A run is started once
+	delete(c.timeouts, runId)
+}
+
+func (c *runCancels) context(runId uint) context.Context {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if ctx, ok := c.contexts[runId]; ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// cancel reports false when the run is not executed by this process
+func (c *runCancels) cancel(runId uint) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cancel, ok := c.cancels[runId]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (c *runCancels) close(runId uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if cancel, ok := c.cancels[runId]; ok {
+		cancel()
+	}
+	if done, ok := c.done[runId]; ok {
+		close(done)
+	}
+	delete(c.contexts, runId)
+	delete(c.cancels, runId)
+	delete(c.timeouts, runId)
+	delete(c.done, runId)
+}
+
+// finished returns a channel closed when the run finishes, nil when the run is not executed by this process
+func (c *runCancels) finished(runId uint) <-chan struct{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if done, ok := c.done[runId]; ok {
+		return done
+	}
+	return nil
+}
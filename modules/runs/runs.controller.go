@@ -0,0 +1,181 @@
+package runs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/auth/guards"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type RunsController struct {
+	runsService *RunsService
+	router      *fiber.Router
+}
+
+func NewRunsController(router *fiber.Router, runsService *RunsService) *RunsController {
+	return &RunsController{router: router, runsService: runsService}
+}
+
+func (c *RunsController) RegisterRoutes(router *fiber.Router) {
+	(*c.router).Get("/", guards.JwtGuard, c.GetRuns)
+	(*c.router).Get("/:id", guards.JwtGuard, c.GetRun)
+	(*c.router).Get("/:id/stream", guards.JwtStreamGuard, c.StreamRun)
+	(*c.router).Post("/:id/cancel", guards.JwtGuard, c.CancelRun)
+}
+
+func (c *RunsController) GetRuns(ctx *fiber.Ctx) error {
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	limit := ctx.QueryInt("limit", 50)
+	if limit < 1 || limit > 200 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Limit must be between 1 and 200",
+		})
+	}
+	runs, err := c.runsService.GetRuns(uint(userClaims.UserID), limit)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(runs)
+}
+
+func (c *RunsController) GetRun(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	run, err := c.runsService.GetRun(uint(id), uint(userClaims.UserID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Run not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(run)
+}
+
+// StreamRun tails a run as server-sent events, finished runs get a single snapshot followed by "done"
+func (c *RunsController) StreamRun(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+
+	// Check ownership before subscribing to the live events
+	run, err := c.runsService.GetRun(uint(id), uint(userClaims.UserID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Run not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	history, events, unsubscribe, active := c.runsService.Subscribe(run.ID)
+	if !active {
+		// The run finished (or runs elsewhere) in the meantime, reload the stored state
+		if run, err = c.runsService.GetRun(run.ID, uint(userClaims.UserID)); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	ctx.Set("Content-Type", "text/event-stream")
+	ctx.Set("Cache-Control", "no-cache")
+	ctx.Set("Connection", "keep-alive")
+	ctx.Set("X-Accel-Buffering", "no")
+
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if !active {
+			writeRunEvent(w, "snapshot", run)
+			writeRunEvent(w, RunEventDone, RunEvent{Type: RunEventDone, RunID: run.ID, Status: run.Status})
+			w.Flush()
+			return
+		}
+		defer unsubscribe()
+
+		for _, event := range history {
+			writeRunEvent(w, event.Type, event)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				writeRunEvent(w, event.Type, event)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+			// A failed flush means the client went away
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (c *RunsController) CancelRun(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	if err := c.runsService.CancelRun(uint(id), uint(userClaims.UserID)); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Run not found",
+			})
+		}
+		if err == ErrRunNotActive {
+			return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Run cancellation requested",
+		"run_id":  id,
+	})
+}
+
+func writeRunEvent(w *bufio.Writer, name string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+}
@@ -0,0 +1,65 @@
+package runs
+
+import (
+	"time"
+
+	"deployer.com/modules/users"
+	"gorm.io/gorm"
+)
+
+type RunStatus string
+
+const (
+	RunStatusPending  RunStatus = "pending"
+	RunStatusRunning  RunStatus = "running"
+	RunStatusSuccess  RunStatus = "success"
+	RunStatusFailed   RunStatus = "failed"
+	RunStatusCanceled RunStatus = "canceled"
+	RunStatusTimedOut RunStatus = "timed_out"
+	RunStatusSkipped  RunStatus = "skipped"
+)
+
+type RunType string
+
+const (
+	RunTypeScript     RunType = "script"
+	RunTypeDeployment RunType = "deployment"
+	RunTypeStack      RunType = "stack"
+)
+
+type Run struct {
+	gorm.Model
+	Type   RunType    `gorm:"not null;index" json:"type"`
+	User   users.User `gorm:"foreignKey:UserID" json:"-"`
+	UserID uint       `gorm:"not null;index" json:"user_id"`
+
+	// Target of the run, only the fields relevant to the run type are set
+	ScriptID     *uint `gorm:"index;default:null" json:"script_id"`
+	DeploymentID *uint `gorm:"index;default:null" json:"deployment_id"`
+	ServerID     *uint `gorm:"index;default:null" json:"server_id"`
+	StackID      *uint `gorm:"index;default:null" json:"stack_id"`
+
+	Status RunStatus `gorm:"not null;index" json:"status"`
+	// Timeout in seconds after which the run is stopped, 0 means no limit
+	Timeout    int        `gorm:"not null;default:0" json:"timeout"`
+	ExitCode   *int       `gorm:"default:null" json:"exit_code"`
+	Error      string     `gorm:"type:text" json:"error"`
+	StartedAt  *time.Time `gorm:"default:null" json:"started_at"`
+	FinishedAt *time.Time `gorm:"default:null" json:"finished_at"`
+	Steps      []RunStep  `gorm:"foreignKey:RunID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"steps"`
+}
+
+type RunStep struct {
+	gorm.Model
+	RunID      uint       `gorm:"not null;index" json:"run_id"`
+	ServerID   *uint      `gorm:"default:null" json:"server_id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Order      int        `gorm:"not null" json:"order"`
+	Status     RunStatus  `gorm:"not null" json:"status"`
+	Stdout     string     `gorm:"type:text" json:"stdout"`
+	Stderr     string     `gorm:"type:text" json:"stderr"`
+	ExitCode   *int       `gorm:"default:null" json:"exit_code"`
+	Error      string     `gorm:"type:text" json:"error"`
+	StartedAt  *time.Time `gorm:"default:null" json:"started_at"`
+	FinishedAt *time.Time `gorm:"default:null" json:"finished_at"`
+}
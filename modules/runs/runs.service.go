@@ -0,0 +1,340 @@
+package runs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RunsService struct {
+	db      *gorm.DB
+	streams *runStreams
+	cancels *runCancels
+	// stepMutex keeps the order of steps started in parallel unique
+	stepMutex sync.Mutex
+}
+
+type RunStepResponse struct {
+	ID         uint       `json:"id"`
+	ServerID   *uint      `json:"server_id"`
+	Name       string     `json:"name"`
+	Order      int        `json:"order"`
+	Status     RunStatus  `json:"status"`
+	Stdout     string     `json:"stdout"`
+	Stderr     string     `json:"stderr"`
+	ExitCode   *int       `json:"exit_code"`
+	Error      string     `json:"error"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+type RunResponse struct {
+	ID           uint              `json:"id"`
+	Type         RunType           `json:"type"`
+	ScriptID     *uint             `json:"script_id"`
+	DeploymentID *uint             `json:"deployment_id"`
+	ServerID     *uint             `json:"server_id"`
+	StackID      *uint             `json:"stack_id"`
+	Status       RunStatus         `json:"status"`
+	Timeout      int               `json:"timeout"`
+	ExitCode     *int              `json:"exit_code"`
+	Error        string            `json:"error"`
+	StartedAt    *time.Time        `json:"started_at"`
+	FinishedAt   *time.Time        `json:"finished_at"`
+	Steps        []RunStepResponse `json:"steps,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+var ErrRunNotActive = errors.New("run is not active")
+
+// RunResultResponse is the outcome of a finished run for callers waiting on it
+type RunResultResponse struct {
+	RunID      uint              `json:"run_id"`
+	Status     RunStatus         `json:"status"`
+	ExitCode   *int              `json:"exit_code"`
+	Stdout     string            `json:"stdout"`
+	Stderr     string            `json:"stderr"`
+	Error      string            `json:"error"`
+	DurationMs int64             `json:"duration_ms"`
+	Servers    []RunServerResult `json:"servers"`
+	Steps      []RunStepResponse `json:"steps"`
+}
+
+// RunServerResult aggregates the steps a run executed on one server
+type RunServerResult struct {
+	ServerID uint      `json:"server_id"`
+	Status   RunStatus `json:"status"`
+	ExitCode *int      `json:"exit_code"`
+	Stdout   string    `json:"stdout"`
+	Stderr   string    `json:"stderr"`
+	Error    string    `json:"error"`
+}
+
+func NewRunsService(db *gorm.DB) *RunsService {
+	return &RunsService{db: db, streams: newRunStreams(), cancels: newRunCancels()}
+}
+
+func (s *RunsService) convertToResponse(run Run) RunResponse {
+	steps := make([]RunStepResponse, len(run.Steps))
+	for i, step := range run.Steps {
+		steps[i] = RunStepResponse{
+			ID:         step.ID,
+			ServerID:   step.ServerID,
+			Name:       step.Name,
+			Order:      step.Order,
+			Status:     step.Status,
+			Stdout:     step.Stdout,
+			Stderr:     step.Stderr,
+			ExitCode:   step.ExitCode,
+			Error:      step.Error,
+			StartedAt:  step.StartedAt,
+			FinishedAt: step.FinishedAt,
+		}
+	}
+	return RunResponse{
+		ID:           run.ID,
+		Type:         run.Type,
+		ScriptID:     run.ScriptID,
+		DeploymentID: run.DeploymentID,
+		ServerID:     run.ServerID,
+		StackID:      run.StackID,
+		Status:       run.Status,
+		Timeout:      run.Timeout,
+		ExitCode:     run.ExitCode,
+		Error:        run.Error,
+		StartedAt:    run.StartedAt,
+		FinishedAt:   run.FinishedAt,
+		Steps:        steps,
+		CreatedAt:    run.CreatedAt,
+		UpdatedAt:    run.UpdatedAt,
+	}
+}
+
+func (s *RunsService) GetRuns(userId uint, limit int) ([]RunResponse, error) {
+	var runs []Run
+	if err := s.db.Where("user_id = ?", userId).Order("created_at DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	result := make([]RunResponse, len(runs))
+	for i, run := range runs {
+		result[i] = s.convertToResponse(run)
+	}
+	return result, nil
+}
+
+func (s *RunsService) GetRun(id, userId uint) (RunResponse, error) {
+	var run Run
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).
+		Preload("Steps", func(db *gorm.DB) *gorm.DB {
+			return db.Order("\"order\" ASC")
+		}).
+		First(&run).Error; err != nil {
+		return RunResponse{}, err
+	}
+	return s.convertToResponse(run), nil
+}
+
+// GetRunLogs joins the output of every step of a run into a single log
+func (s *RunsService) GetRunLogs(runId uint) (string, error) {
+	var steps []RunStep
+	if err := s.db.Where("run_id = ?", runId).Order("\"order\" ASC").Find(&steps).Error; err != nil {
+		return "", err
+	}
+	var logs strings.Builder
+	for _, step := range steps {
+		fmt.Fprintf(&logs, "==> %s [%s]\n", step.Name, step.Status)
+		logs.WriteString(step.Stdout)
+		logs.WriteString(step.Stderr)
+		if step.Error != "" {
+			fmt.Fprintf(&logs, "error: %s\n", step.Error)
+		}
+	}
+	return logs.String(), nil
+}
+
+// CreateRun stores a new pending run, the caller fills in the type, user and target
+func (s *RunsService) CreateRun(run *Run) error {
+	run.Status = RunStatusPending
+	if err := s.db.Create(run).Error; err != nil {
+		return err
+	}
+	s.streams.open(run.ID)
+	s.cancels.open(run.ID, time.Duration(run.Timeout)*time.Second)
+	s.streams.publish(RunEvent{Type: RunEventStatus, RunID: run.ID, Status: run.Status})
+	return nil
+}
+
+// StartRun marks a run as running and starts its timeout, Context returns the context with the deadline
+// from then on
+func (s *RunsService) StartRun(id uint) error {
+	s.cancels.start(id)
+	now := time.Now()
+	if err := s.db.Model(&Run{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":     RunStatusRunning,
+		"started_at": now,
+	}).Error; err != nil {
+		return err
+	}
+	s.streams.publish(RunEvent{Type: RunEventStatus, RunID: id, Status: RunStatusRunning})
+	return nil
+}
+
+func (s *RunsService) FinishRun(id uint, status RunStatus, exitCode *int, errMessage string) error {
+	now := time.Now()
+	// Live clients are released even if the final state could not be stored
+	defer s.streams.close(id, status)
+	defer s.cancels.close(id)
+	return s.db.Model(&Run{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":      status,
+		"exit_code":   exitCode,
+		"error":       errMessage,
+		"finished_at": now,
+	}).Error
+}
+
+// Context returns the context driving a run, it is canceled by CancelRun, when the timeout of the run
+// expires and once the run finishes
+func (s *RunsService) Context(id uint) context.Context {
+	return s.cancels.context(id)
+}
+
+// CancelRun stops an active run, the executor records it as canceled and keeps the output produced so far
+func (s *RunsService) CancelRun(id, userId uint) error {
+	var run Run
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&run).Error; err != nil {
+		return err
+	}
+	if run.Status != RunStatusPending && run.Status != RunStatusRunning {
+		return ErrRunNotActive
+	}
+	if !s.cancels.cancel(run.ID) {
+		return fmt.Errorf("run %d is not executed by this instance", run.ID)
+	}
+	return nil
+}
+
+// WaitRun blocks until the run finishes or the timeout expires, finished is false when the run is still active.
+// The output of the result joins the steps named in outputSteps, or of every step when none are given
+func (s *RunsService) WaitRun(id, userId uint, timeout time.Duration, outputSteps ...string) (result RunResultResponse, finished bool, err error) {
+	if done := s.cancels.finished(id); done != nil {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+		}
+	}
+
+	run, err := s.GetRun(id, userId)
+	if err != nil {
+		return RunResultResponse{}, false, err
+	}
+	result = RunResultResponse{
+		RunID:    run.ID,
+		Status:   run.Status,
+		ExitCode: run.ExitCode,
+		Error:    run.Error,
+		Steps:    run.Steps,
+	}
+	if run.StartedAt != nil && run.FinishedAt != nil {
+		result.DurationMs = run.FinishedAt.Sub(*run.StartedAt).Milliseconds()
+	}
+	var stdout, stderr strings.Builder
+	serverIndex := make(map[uint]int)
+	result.Servers = make([]RunServerResult, 0)
+	for _, step := range run.Steps {
+		var server *RunServerResult
+		if step.ServerID != nil {
+			index, ok := serverIndex[*step.ServerID]
+			if !ok {
+				index = len(result.Servers)
+				serverIndex[*step.ServerID] = index
+				result.Servers = append(result.Servers, RunServerResult{ServerID: *step.ServerID, Status: RunStatusSuccess})
+			}
+			server = &result.Servers[index]
+			// The first step that did not succeed decides the outcome on the server
+			if server.Status == RunStatusSuccess && step.Status != RunStatusSuccess {
+				server.Status = step.Status
+				server.Error = step.Error
+			}
+			if step.ExitCode != nil {
+				server.ExitCode = step.ExitCode
+			}
+		}
+		if len(outputSteps) > 0 && !slices.Contains(outputSteps, step.Name) {
+			continue
+		}
+		stdout.WriteString(step.Stdout)
+		stderr.WriteString(step.Stderr)
+		if server != nil {
+			server.Stdout += step.Stdout
+			server.Stderr += step.Stderr
+		}
+	}
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, run.Status != RunStatusPending && run.Status != RunStatusRunning, nil
+}
+
+// StartStep appends a running step to the run
+func (s *RunsService) StartStep(runId uint, serverId *uint, name string) (*RunStep, error) {
+	s.stepMutex.Lock()
+	defer s.stepMutex.Unlock()
+	var count int64
+	if err := s.db.Model(&RunStep{}).Where("run_id = ?", runId).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	step := RunStep{
+		RunID:     runId,
+		ServerID:  serverId,
+		Name:      name,
+		Order:     int(count) + 1,
+		Status:    RunStatusRunning,
+		StartedAt: &now,
+	}
+	if err := s.db.Create(&step).Error; err != nil {
+		return nil, err
+	}
+	s.streams.publish(RunEvent{Type: RunEventStep, RunID: runId, StepID: step.ID, Name: name, Status: step.Status})
+	return &step, nil
+}
+
+func (s *RunsService) FinishStep(step *RunStep, status RunStatus, stdout, stderr string, exitCode *int, errMessage string) error {
+	now := time.Now()
+	step.Status = status
+	step.Stdout = stdout
+	step.Stderr = stderr
+	step.ExitCode = exitCode
+	step.Error = errMessage
+	step.FinishedAt = &now
+	s.streams.publish(RunEvent{Type: RunEventStep, RunID: step.RunID, StepID: step.ID, Name: step.Name, Status: status})
+	return s.db.Save(step).Error
+}
+
+// SkipStep records a step that was not executed
+func (s *RunsService) SkipStep(runId uint, serverId *uint, name, reason string) error {
+	step, err := s.StartStep(runId, serverId, name)
+	if err != nil {
+		return err
+	}
+	return s.FinishStep(step, RunStatusSkipped, "", "", nil, reason)
+}
+
+// PublishOutput sends a line of step output to the clients tailing the run
+func (s *RunsService) PublishOutput(runId, stepId uint, stream, line string) {
+	s.streams.publish(RunEvent{Type: RunEventOutput, RunID: runId, StepID: stepId, Stream: stream, Line: line})
+}
+
+// Subscribe returns the events of an active run published so far and a channel with the next ones,
+// ok is false when the run is not executed by this process
+func (s *RunsService) Subscribe(runId uint) (history []RunEvent, events <-chan RunEvent, unsubscribe func(), ok bool) {
+	return s.streams.subscribe(runId)
+}
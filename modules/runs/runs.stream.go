@@ -0,0 +1,111 @@
+package runs
+
+import "sync"
+
+const (
+	RunEventStatus = "status"
+	RunEventStep   = "step"
+	RunEventOutput = "output"
+	RunEventDone   = "done"
+)
+
+// maxRunStreamHistory bounds the events kept in memory for clients that connect mid-run
+const maxRunStreamHistory = 10000
+
+// subscriberBuffer is how many events a slow client may lag behind before it is dropped
+const subscriberBuffer = 256
+
+type RunEvent struct {
+	Type   string    `json:"type"`
+	RunID  uint      `json:"run_id"`
+	StepID uint      `json:"step_id,omitempty"`
+	Name   string    `json:"name,omitempty"`
+	Status RunStatus `json:"status,omitempty"`
+	Stream string    `json:"stream,omitempty"`
+	Line   string    `json:"line,omitempty"`
+}
+
+// runStream holds the live events of a run that is executed by this process
+type runStream struct {
+	history     []RunEvent
+	subscribers map[chan RunEvent]struct{}
+}
+
+type runStreams struct {
+	mutex   sync.Mutex
+	streams map[uint]*runStream
+}
+
+func newRunStreams() *runStreams {
+	return &runStreams{streams: make(map[uint]*runStream)}
+}
+
+func (r *runStreams) open(runId uint) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.streams[runId]; !ok {
+		r.streams[runId] = &runStream{subscribers: make(map[chan RunEvent]struct{})}
+	}
+}
+
+func (r *runStreams) publish(event RunEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stream, ok := r.streams[event.RunID]
+	if !ok {
+		return
+	}
+	if len(stream.history) < maxRunStreamHistory {
+		stream.history = append(stream.history, event)
+	}
+	for subscriber := range stream.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// The client cannot keep up, it can reconnect and replay the history
+			delete(stream.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// close publishes the final event and disconnects every subscriber of the run
+func (r *runStreams) close(runId uint, status RunStatus) {
+	r.publish(RunEvent{Type: RunEventDone, RunID: runId, Status: status})
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stream, ok := r.streams[runId]
+	if !ok {
+		return
+	}
+	for subscriber := range stream.subscribers {
+		close(subscriber)
+	}
+	delete(r.streams, runId)
+}
+
+// subscribe returns the events published so far and a channel with the following ones,
+// ok is false when the run is not active in this process
+func (r *runStreams) subscribe(runId uint) (history []RunEvent, events chan RunEvent, unsubscribe func(), ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stream, ok := r.streams[runId]
+	if !ok {
+		return nil, nil, nil, false
+	}
+	history = make([]RunEvent, len(stream.history))
+	copy(history, stream.history)
+	events = make(chan RunEvent, subscriberBuffer)
+	stream.subscribers[events] = struct{}{}
+	unsubscribe = func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if stream, ok := r.streams[runId]; ok {
+			if _, ok := stream.subscribers[events]; ok {
+				delete(stream.subscribers, events)
+				close(events)
+			}
+		}
+	}
+	return history, events, unsubscribe, true
+}
@@ -6,6 +6,8 @@ type CreateScriptDto struct {
 	Name        string `json:"name" validate:"required,min=1,max=255"`
 	Script      string `json:"script" validate:"required,min=1,max=10000"`
 	Description string `json:"description" validate:"omitempty,min=0,max=1000"`
+	// Timeout in seconds, 0 uses the default
+	Timeout int `json:"timeout" validate:"omitempty,min=0,max=86400"`
 }
 
 func ValidateCreateScriptDto(dto CreateScriptDto) error {
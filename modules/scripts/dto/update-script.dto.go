@@ -6,6 +6,7 @@ type UpdateScriptDto struct {
 	Name        *string `json:"name" validate:"omitempty,min=1,max=255"`
 	Script      *string `json:"script" validate:"omitempty,min=1,max=10000"`
 	Description *string `json:"description" validate:"omitempty,min=1,max=1000"`
+	Timeout     *int    `json:"timeout" validate:"omitempty,min=0,max=86400"`
 }
 
 func (dto *UpdateScriptDto) GetUpdates() (map[string]interface{}, []string) {
@@ -23,6 +24,10 @@ func (dto *UpdateScriptDto) GetUpdates() (map[string]interface{}, []string) {
 		updates["description"] = *dto.Description
 		fields = append(fields, "description")
 	}
+	if dto.Timeout != nil {
+		updates["timeout"] = *dto.Timeout
+		fields = append(fields, "timeout")
+	}
 	return updates, fields
 }
 
@@ -15,6 +15,8 @@ type Script struct {
 	Description string     `gorm:"null;default:null" json:"description"`
 	User        users.User `gorm:"foreignKey:UserID" json:"-"`
 	UserID      uint       `gorm:"not null" json:"user_id"`
+	// Timeout bounds a run of the script in seconds, 0 uses the default of the executor
+	Timeout int `gorm:"not null;default:0" json:"timeout"`
 	// CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	// UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	// DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
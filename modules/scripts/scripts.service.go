@@ -18,6 +18,7 @@ type ScriptResponse struct {
 	Name        string     `json:"name"`
 	Script      string     `json:"script"`
 	Description string     `json:"description"`
+	Timeout     int        `json:"timeout"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 	LastRunAt   *time.Time `json:"last_run_at"`
@@ -29,7 +30,7 @@ func NewScriptsService(db *gorm.DB) *ScriptsService {
 
 func (s *ScriptsService) GetScripts(userId uint, iv string) ([]ScriptResponse, error) {
 	var scripts []Script
-	if err := s.db.Where("user_id = ?", userId).Select("id, name, script, description, created_at, updated_at").Order("created_at DESC").Find(&scripts).Error; err != nil {
+	if err := s.db.Where("user_id = ?", userId).Select("id, name, script, description, timeout, created_at, updated_at").Order("created_at DESC").Find(&scripts).Error; err != nil {
 		return nil, err
 	}
 	result := make([]ScriptResponse, len(scripts))
@@ -43,6 +44,7 @@ func (s *ScriptsService) GetScripts(userId uint, iv string) ([]ScriptResponse, e
 			Name:        script.Name,
 			Script:      decoded,
 			Description: script.Description,
+			Timeout:     script.Timeout,
 			CreatedAt:   script.CreatedAt,
 			UpdatedAt:   script.UpdatedAt,
 			LastRunAt:   script.LastRunAt,
@@ -65,6 +67,7 @@ func (s *ScriptsService) GetScript(id, userId uint, iv string) (ScriptResponse,
 		Name:        script.Name,
 		Script:      decoded,
 		Description: script.Description,
+		Timeout:     script.Timeout,
 		CreatedAt:   script.CreatedAt,
 		UpdatedAt:   script.UpdatedAt,
 		LastRunAt:   script.LastRunAt,
@@ -80,6 +83,7 @@ func (s *ScriptsService) CreateScript(userId uint, dto dto.CreateScriptDto, iv s
 		Name:        dto.Name,
 		Script:      encrypted,
 		Description: dto.Description,
+		Timeout:     dto.Timeout,
 		UserID:      userId,
 	}
 	if err := s.db.Create(&script).Error; err != nil {
@@ -90,6 +94,7 @@ func (s *ScriptsService) CreateScript(userId uint, dto dto.CreateScriptDto, iv s
 		Name:        script.Name,
 		Script:      dto.Script,
 		Description: dto.Description,
+		Timeout:     script.Timeout,
 		CreatedAt:   script.CreatedAt,
 		UpdatedAt:   script.UpdatedAt,
 		LastRunAt:   script.LastRunAt,
@@ -127,6 +132,7 @@ func (s *ScriptsService) UpdateScript(id, userId uint, updates map[string]interf
 		Name:        script.Name,
 		Script:      decoded,
 		Description: script.Description,
+		Timeout:     script.Timeout,
 		CreatedAt:   script.CreatedAt,
 		UpdatedAt:   script.UpdatedAt,
 		LastRunAt:   script.LastRunAt,
@@ -3,8 +3,10 @@ package dto
 import "github.com/go-playground/validator/v10"
 
 type CreateSecretDto struct {
-	Name    string `json:"name" validate:"required,min=1,max=255"`
-	Content string `json:"content" validate:"required,min=1,max=10000"`
+	Name string `json:"name" validate:"required,min=1,max=255"`
+	// Content may be left empty for a structured secret, its keys are added later
+	Content string `json:"content" validate:"required_unless=Mode structured,max=10000"`
+	Mode    string `json:"mode" validate:"omitempty,oneof=raw structured"`
 }
 
 func ValidateCreateSecretDto(dto CreateSecretDto) error {
@@ -0,0 +1,15 @@
+package dto
+
+import "github.com/go-playground/validator/v10"
+
+type SetSecretKeyDto struct {
+	Value *string `json:"value" validate:"required,max=10000"`
+	// Type and Description keep their current value when they are not passed
+	Type        string  `json:"type" validate:"omitempty,oneof=string number boolean json"`
+	Description *string `json:"description" validate:"omitempty,max=1000"`
+}
+
+func ValidateSetSecretKeyDto(dto SetSecretKeyDto) error {
+	validate := validator.New()
+	return validate.Struct(dto)
+}
@@ -1,6 +1,8 @@
 package secrets
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 
 	"deployer.com/libs"
@@ -8,8 +10,12 @@ import (
 	"deployer.com/modules/secrets/dto"
 	"deployer.com/modules/users"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
+// maxImportSize limits the documents accepted by ImportSecret
+const maxImportSize = 64 * 1024
+
 type SecretsController struct {
 	secretsService *SecretsService
 	router         *fiber.Router
@@ -25,6 +31,16 @@ func (c *SecretsController) RegisterRoutes(router *fiber.Router) {
 	(*c.router).Post("/", guards.JwtGuard, c.CreateSecret)
 	(*c.router).Patch("/:id", guards.JwtGuard, c.UpdateSecret)
 	(*c.router).Delete("/:id", guards.JwtGuard, c.DeleteSecret)
+	(*c.router).Get("/:id/versions", guards.JwtGuard, c.GetSecretVersions)
+	(*c.router).Get("/:id/versions/:version", guards.JwtGuard, c.GetSecretVersion)
+	(*c.router).Get("/:id/diff", guards.JwtGuard, c.DiffSecretVersions)
+	(*c.router).Post("/:id/restore/:version", guards.JwtGuard, c.RestoreSecretVersion)
+	(*c.router).Get("/:id/keys", guards.JwtGuard, c.GetSecretKeys)
+	(*c.router).Get("/:id/keys/:key", guards.JwtGuard, c.GetSecretKey)
+	(*c.router).Put("/:id/keys/:key", guards.JwtGuard, c.SetSecretKey)
+	(*c.router).Delete("/:id/keys/:key", guards.JwtGuard, c.DeleteSecretKey)
+	(*c.router).Post("/:id/import", guards.JwtGuard, c.ImportSecret)
+	(*c.router).Get("/:id/export", guards.JwtGuard, c.ExportSecret)
 }
 
 // RegisterApiKeyRoutes creates API key only protected routes for external integrations
@@ -83,9 +99,7 @@ func (c *SecretsController) CreateSecret(ctx *fiber.Ctx) error {
 	}
 	secret, err := c.secretsService.CreateSecret(uint(userClaims.UserID), body, userClaims.IV)
 	if err != nil {
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return c.entryError(ctx, err)
 	}
 	return ctx.Status(fiber.StatusCreated).JSON(secret)
 }
@@ -117,9 +131,7 @@ func (c *SecretsController) UpdateSecret(ctx *fiber.Ctx) error {
 	updates, _ := body.GetUpdates()
 	secret, err := c.secretsService.UpdateSecret(uint(id), uint(userClaims.UserID), updates, userClaims.IV)
 	if err != nil {
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return c.entryError(ctx, err)
 	}
 	return ctx.Status(fiber.StatusOK).JSON(secret)
 }
@@ -142,6 +154,230 @@ func (c *SecretsController) DeleteSecret(ctx *fiber.Ctx) error {
 	})
 }
 
+func (c *SecretsController) GetSecretVersions(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	versions, err := c.secretsService.GetSecretVersions(uint(id), uint(userClaims.UserID))
+	if err != nil {
+		return c.versionError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(versions)
+}
+
+func (c *SecretsController) GetSecretVersion(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	version, err := strconv.Atoi(ctx.Params("version"))
+	if err != nil || version < 1 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid version format",
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	result, err := c.secretsService.GetSecretVersion(uint(id), uint(userClaims.UserID), version, userClaims.IV)
+	if err != nil {
+		return c.versionError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(result)
+}
+
+// DiffSecretVersions compares the versions ?from= and ?to=, by default the current version with the one before
+func (c *SecretsController) DiffSecretVersions(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	from, to := ctx.QueryInt("from", 0), ctx.QueryInt("to", 0)
+	if from < 0 || to < 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid version format",
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	diff, err := c.secretsService.DiffSecretVersions(uint(id), uint(userClaims.UserID), from, to, userClaims.IV)
+	if err != nil {
+		return c.versionError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(diff)
+}
+
+func (c *SecretsController) RestoreSecretVersion(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	version, err := strconv.Atoi(ctx.Params("version"))
+	if err != nil || version < 1 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid version format",
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	secret, err := c.secretsService.RestoreSecretVersion(uint(id), uint(userClaims.UserID), version, userClaims.IV)
+	if err != nil {
+		return c.versionError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(secret)
+}
+
+func (c *SecretsController) versionError(ctx *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		status = fiber.StatusNotFound
+	} else if errors.Is(err, ErrVersionIsCurrent) {
+		status = fiber.StatusConflict
+	}
+	return ctx.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+func (c *SecretsController) GetSecretKeys(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	entries, err := c.secretsService.GetSecretKeys(uint(id), uint(userClaims.UserID), userClaims.IV)
+	if err != nil {
+		return c.entryError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(entries)
+}
+
+func (c *SecretsController) GetSecretKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	entry, err := c.secretsService.GetSecretKey(uint(id), uint(userClaims.UserID), ctx.Params("key"), userClaims.IV)
+	if err != nil {
+		return c.entryError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(entry)
+}
+
+// SetSecretKey creates or changes a key, a raw secret is converted to a structured one
+func (c *SecretsController) SetSecretKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	var body dto.SetSecretKeyDto
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := dto.ValidateSetSecretKeyDto(body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	entry, err := c.secretsService.SetSecretKey(uint(id), uint(userClaims.UserID), ctx.Params("key"), body, userClaims.IV)
+	if err != nil {
+		return c.entryError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(entry)
+}
+
+func (c *SecretsController) DeleteSecretKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	if err := c.secretsService.DeleteSecretKey(uint(id), uint(userClaims.UserID), ctx.Params("key"), userClaims.IV); err != nil {
+		return c.entryError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Secret key deleted successfully",
+	})
+}
+
+// ImportSecret merges the keys of the request body, a document in ?format= (dotenv by default), into the
+// secret. ?replace=true drops the keys the document does not contain
+func (c *SecretsController) ImportSecret(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if len(ctx.Body()) > maxImportSize {
+		return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("Import is larger than %d bytes", maxImportSize),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	secret, err := c.secretsService.ImportSecret(uint(id), uint(userClaims.UserID), ctx.Query("format", FormatDotenv),
+		ctx.Body(), ctx.QueryBool("replace", false), userClaims.IV)
+	if err != nil {
+		return c.entryError(ctx, err)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(secret)
+}
+
+// ExportSecret returns the keys of the secret as a document in ?format=, dotenv by default
+func (c *SecretsController) ExportSecret(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	format := ctx.Query("format", FormatDotenv)
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	document, err := c.secretsService.ExportSecret(uint(id), uint(userClaims.UserID), format, userClaims.IV)
+	if err != nil {
+		return c.entryError(ctx, err)
+	}
+	contentType := fiber.MIMETextPlainCharsetUTF8
+	switch format {
+	case FormatJSON:
+		contentType = fiber.MIMEApplicationJSONCharsetUTF8
+	case FormatYAML:
+		contentType = "application/yaml; charset=utf-8"
+	}
+	ctx.Set(fiber.HeaderContentType, contentType)
+	return ctx.Status(fiber.StatusOK).Send(document)
+}
+
+func (c *SecretsController) entryError(ctx *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, ErrSecretKeyNotFound) {
+		status = fiber.StatusNotFound
+	} else if errors.Is(err, ErrInvalidSecretContent) {
+		status = fiber.StatusBadRequest
+	}
+	return ctx.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
 // GetSecretsApiKey handles API key authenticated requests
 func (c *SecretsController) GetSecretsApiKey(ctx *fiber.Ctx) error {
 	// Get user from API key authentication
@@ -12,7 +12,26 @@ type Secret struct {
 	Content string     `gorm:"not null" json:"content"`
 	User    users.User `gorm:"foreignKey:UserID" json:"user"`
 	UserID  uint       `gorm:"not null" json:"user_id"`
+	// Version is the number of the SecretVersion holding the current content
+	Version int `gorm:"not null;default:1" json:"version"`
+	// Mode is SecretModeRaw or SecretModeStructured and decides how Content is read
+	Mode string `gorm:"not null;default:'raw'" json:"mode"`
 	// CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	// UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	// DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
+
+// SecretVersion is an immutable copy of the content of a secret, every change of the content adds one
+type SecretVersion struct {
+	gorm.Model
+	SecretID uint `gorm:"not null;uniqueIndex:idx_secret_versions_version" json:"secret_id"`
+	Version  int  `gorm:"not null;uniqueIndex:idx_secret_versions_version" json:"version"`
+	// Content is encrypted like Secret.Content
+	Content string `gorm:"type:text;not null" json:"-"`
+	// Mode is the mode of the secret the content was written in
+	Mode     string     `gorm:"not null;default:'raw'" json:"mode"`
+	Author   users.User `gorm:"foreignKey:AuthorID" json:"-"`
+	AuthorID uint       `gorm:"not null" json:"author_id"`
+	// RestoredFrom is the version this one restored, nil for regular updates
+	RestoredFrom *int `gorm:"default:null" json:"restored_from"`
+}
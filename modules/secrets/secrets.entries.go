@@ -0,0 +1,319 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"deployer.com/libs"
+	"deployer.com/modules/secrets/dto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// SecretModeRaw stores the content as the dotenv text it was written as
+	SecretModeRaw = "raw"
+	// SecretModeStructured stores the content as a list of SecretEntry encoded as JSON
+	SecretModeStructured = "structured"
+)
+
+const (
+	EntryTypeString  = "string"
+	EntryTypeNumber  = "number"
+	EntryTypeBoolean = "boolean"
+	EntryTypeJSON    = "json"
+)
+
+// ErrInvalidSecretContent is returned for content, keys or values that cannot be stored
+var ErrInvalidSecretContent = errors.New("invalid secret content")
+
+// ErrSecretKeyNotFound is returned when a key is not part of the secret
+var ErrSecretKeyNotFound = errors.New("secret key not found")
+
+// numberPattern matches JSON numbers, numbers are kept as written so exports do not reformat them
+var numberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// SecretEntry is a key of a structured secret. Value always holds the text passed to scripts and
+// containers, Type decides how it is written to JSON and YAML
+type SecretEntry struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+// validateEntry checks the key and that the value matches the type, json values are normalized
+func validateEntry(entry *SecretEntry) error {
+	if !libs.ValidEnvName(entry.Key) {
+		return fmt.Errorf("%w: invalid key %q, keys must match [A-Za-z_][A-Za-z0-9_]*", ErrInvalidSecretContent, entry.Key)
+	}
+	switch entry.Type {
+	case EntryTypeString:
+	case EntryTypeNumber:
+		if !numberPattern.MatchString(entry.Value) {
+			return fmt.Errorf("%w: %s is not a number", ErrInvalidSecretContent, entry.Key)
+		}
+	case EntryTypeBoolean:
+		if entry.Value != "true" && entry.Value != "false" {
+			return fmt.Errorf("%w: %s is not true or false", ErrInvalidSecretContent, entry.Key)
+		}
+	case EntryTypeJSON:
+		normalized, err := normalizeJSON(entry.Value)
+		if err != nil {
+			return fmt.Errorf("%w: %s is not valid JSON: %v", ErrInvalidSecretContent, entry.Key, err)
+		}
+		entry.Value = normalized
+	default:
+		return fmt.Errorf("%w: unknown type %q of %s", ErrInvalidSecretContent, entry.Type, entry.Key)
+	}
+	return nil
+}
+
+// decodeEntries returns the entries of decrypted content, raw content is parsed as dotenv with every
+// value as a string and the comment above a key as its description
+func decodeEntries(mode, content string) ([]SecretEntry, error) {
+	if mode == SecretModeStructured {
+		entries := make([]SecretEntry, 0)
+		if err := json.Unmarshal([]byte(content), &entries); err != nil {
+			return nil, fmt.Errorf("failed to decode secret entries: %w", err)
+		}
+		return entries, nil
+	}
+	parsed, err := libs.ParseDotenv(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSecretContent, err)
+	}
+	entries := make([]SecretEntry, len(parsed))
+	for i, entry := range parsed {
+		entries[i] = SecretEntry{Key: entry.Key, Value: entry.Value, Type: EntryTypeString, Description: entry.Comment}
+	}
+	return entries, nil
+}
+
+func encodeEntries(entries []SecretEntry) (string, error) {
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode secret entries: %w", err)
+	}
+	return string(encoded), nil
+}
+
+func entriesToDotenv(entries []SecretEntry) string {
+	dotenv := make([]libs.DotenvEntry, len(entries))
+	for i, entry := range entries {
+		dotenv[i] = libs.DotenvEntry{Key: entry.Key, Value: entry.Value, Comment: entry.Description}
+	}
+	return libs.FormatDotenv(dotenv)
+}
+
+// envMap returns the values of decrypted content by key. Raw content that is not valid dotenv falls back
+// to splitting KEY=value lines, so secrets written before the parser keep working
+func envMap(mode, content string) map[string]string {
+	result := make(map[string]string)
+	entries, err := decodeEntries(mode, content)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to parse secret content, reading it line by line: %v\n", err)
+		return parseEnvLines(content)
+	}
+	for _, entry := range entries {
+		result[entry.Key] = entry.Value
+	}
+	return result
+}
+
+// mergeEntries applies imported entries to the current ones. An imported entry without a type keeps the
+// type of the current key when its value still fits it, one without a description keeps the current
+// description. replace drops the keys that are not imported
+func mergeEntries(current, imported []SecretEntry, replace bool) ([]SecretEntry, error) {
+	index := make(map[string]int, len(current))
+	for i, entry := range current {
+		index[entry.Key] = i
+	}
+	result := make([]SecretEntry, 0, len(current)+len(imported))
+	if !replace {
+		result = append(result, current...)
+	}
+	positions := make(map[string]int, len(result))
+	for i, entry := range result {
+		positions[entry.Key] = i
+	}
+
+	for _, entry := range imported {
+		if i, ok := index[entry.Key]; ok {
+			existing := current[i]
+			if entry.Type == "" {
+				candidate := entry
+				candidate.Type = existing.Type
+				if validateEntry(&candidate) == nil {
+					entry.Type = existing.Type
+				}
+			}
+			if entry.Description == "" {
+				entry.Description = existing.Description
+			}
+		}
+		if entry.Type == "" {
+			entry.Type = EntryTypeString
+		}
+		if err := validateEntry(&entry); err != nil {
+			return nil, err
+		}
+		if i, ok := positions[entry.Key]; ok {
+			result[i] = entry
+		} else {
+			positions[entry.Key] = len(result)
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// updateEntries changes the entries of a secret inside a locked transaction. A raw secret is converted to
+// structured mode first. A change is stored as a new version, a secret that stays the same keeps its version
+func (s *SecretsService) updateEntries(id, userId uint, iv string, apply func(entries []SecretEntry) ([]SecretEntry, error)) (Secret, []SecretEntry, error) {
+	var secret Secret
+	var entries []SecretEntry
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", id, userId).First(&secret).Error; err != nil {
+			return err
+		}
+		if err := s.ensureCurrentVersion(tx, secret); err != nil {
+			return err
+		}
+		current, err := s.encryptionService.Decrypt(secret.Content, iv)
+		if err != nil {
+			return err
+		}
+		entries, err = decodeEntries(secret.Mode, current)
+		if err != nil {
+			return err
+		}
+		entries, err = apply(entries)
+		if err != nil {
+			return err
+		}
+		content, err := encodeEntries(entries)
+		if err != nil {
+			return err
+		}
+		if secret.Mode == SecretModeStructured && content == current {
+			return nil
+		}
+
+		encrypted, err := s.encryptionService.Encrypt(content, iv)
+		if err != nil {
+			return err
+		}
+		secret.Content = encrypted
+		secret.Mode = SecretModeStructured
+		secret.Version++
+		if err := tx.Save(&secret).Error; err != nil {
+			return err
+		}
+		return s.createVersion(tx, secret, userId, nil)
+	})
+	if err != nil {
+		return Secret{}, nil, err
+	}
+	return secret, entries, nil
+}
+
+// GetSecretKeys returns the entries of a secret, raw secrets are parsed without converting them
+func (s *SecretsService) GetSecretKeys(id, userId uint, iv string) ([]SecretEntry, error) {
+	secret, err := s.findSecret(id, userId)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := s.encryptionService.Decrypt(secret.Content, iv)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntries(secret.Mode, decoded)
+}
+
+func (s *SecretsService) GetSecretKey(id, userId uint, key, iv string) (SecretEntry, error) {
+	entries, err := s.GetSecretKeys(id, userId, iv)
+	if err != nil {
+		return SecretEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.Key == key {
+			return entry, nil
+		}
+	}
+	return SecretEntry{}, ErrSecretKeyNotFound
+}
+
+// SetSecretKey creates or changes a key, a type or description that is not passed stays as it is
+func (s *SecretsService) SetSecretKey(id, userId uint, key string, body dto.SetSecretKeyDto, iv string) (SecretEntry, error) {
+	var result SecretEntry
+	_, _, err := s.updateEntries(id, userId, iv, func(entries []SecretEntry) ([]SecretEntry, error) {
+		position := -1
+		result = SecretEntry{Key: key, Type: EntryTypeString}
+		for i, entry := range entries {
+			if entry.Key == key {
+				position = i
+				result = entry
+			}
+		}
+		result.Value = *body.Value
+		if body.Type != "" {
+			result.Type = body.Type
+		}
+		if body.Description != nil {
+			result.Description = *body.Description
+		}
+		if err := validateEntry(&result); err != nil {
+			return nil, err
+		}
+		if position < 0 {
+			return append(entries, result), nil
+		}
+		entries[position] = result
+		return entries, nil
+	})
+	if err != nil {
+		return SecretEntry{}, err
+	}
+	return result, nil
+}
+
+func (s *SecretsService) DeleteSecretKey(id, userId uint, key, iv string) error {
+	_, _, err := s.updateEntries(id, userId, iv, func(entries []SecretEntry) ([]SecretEntry, error) {
+		for i, entry := range entries {
+			if entry.Key == key {
+				return append(entries[:i], entries[i+1:]...), nil
+			}
+		}
+		return nil, ErrSecretKeyNotFound
+	})
+	return err
+}
+
+// ImportSecret reads data in format and merges its keys into the secret, replace drops the keys missing
+// from data
+func (s *SecretsService) ImportSecret(id, userId uint, format string, data []byte, replace bool, iv string) (SecretResponse, error) {
+	imported, err := ParseSecretEntries(format, data)
+	if err != nil {
+		return SecretResponse{}, err
+	}
+	secret, _, err := s.updateEntries(id, userId, iv, func(entries []SecretEntry) ([]SecretEntry, error) {
+		return mergeEntries(entries, imported, replace)
+	})
+	if err != nil {
+		return SecretResponse{}, err
+	}
+	return s.convertToResponse(secret, iv)
+}
+
+// ExportSecret writes the keys of the secret in format
+func (s *SecretsService) ExportSecret(id, userId uint, format, iv string) ([]byte, error) {
+	entries, err := s.GetSecretKeys(id, userId, iv)
+	if err != nil {
+		return nil, err
+	}
+	return FormatSecretEntries(format, entries)
+}
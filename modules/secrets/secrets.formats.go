@@ -0,0 +1,330 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"deployer.com/libs"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatDotenv = "dotenv"
+	FormatJSON   = "json"
+	FormatYAML   = "yaml"
+)
+
+// ParseSecretEntries reads the keys of a dotenv, JSON or YAML document. Dotenv entries have no type, JSON
+// and YAML values are typed by what they hold and nested objects and lists become json entries. Comments
+// above a key in dotenv and YAML become its description
+func ParseSecretEntries(format string, data []byte) ([]SecretEntry, error) {
+	switch format {
+	case FormatDotenv:
+		parsed, err := libs.ParseDotenv(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSecretContent, err)
+		}
+		entries := make([]SecretEntry, len(parsed))
+		for i, entry := range parsed {
+			entries[i] = SecretEntry{Key: entry.Key, Value: entry.Value, Description: entry.Comment}
+		}
+		return entries, nil
+	case FormatJSON:
+		entries, err := parseJSONEntries(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSecretContent, err)
+		}
+		return entries, nil
+	case FormatYAML:
+		entries, err := parseYAMLEntries(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSecretContent, err)
+		}
+		return entries, nil
+	}
+	return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidSecretContent, format)
+}
+
+// FormatSecretEntries writes entries as a document ParseSecretEntries reads back to the same entries,
+// JSON has no comments so descriptions are only written to dotenv and YAML
+func FormatSecretEntries(format string, entries []SecretEntry) ([]byte, error) {
+	switch format {
+	case FormatDotenv:
+		return []byte(entriesToDotenv(entries)), nil
+	case FormatJSON:
+		return formatJSONEntries(entries)
+	case FormatYAML:
+		return formatYAMLEntries(entries)
+	}
+	return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidSecretContent, format)
+}
+
+// parseJSONEntries reads a JSON object token by token, so the keys keep their order
+func parseJSONEntries(data []byte) ([]SecretEntry, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	entries := make([]SecretEntry, 0)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+		entry := SecretEntry{Key: key}
+		switch raw[0] {
+		case '"':
+			entry.Type = EntryTypeString
+			if err := json.Unmarshal(raw, &entry.Value); err != nil {
+				return nil, err
+			}
+		case 't', 'f':
+			entry.Type, entry.Value = EntryTypeBoolean, string(raw)
+		case '{', '[', 'n':
+			value, err := normalizeJSON(string(raw))
+			if err != nil {
+				return nil, err
+			}
+			entry.Type, entry.Value = EntryTypeJSON, value
+		default:
+			entry.Type, entry.Value = EntryTypeNumber, string(raw)
+		}
+		entries = append(entries, entry)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err == nil {
+		return nil, fmt.Errorf("unexpected data after the JSON object")
+	}
+	return entries, nil
+}
+
+func formatJSONEntries(entries []SecretEntry) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, entry := range entries {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, err := json.Marshal(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		switch entry.Type {
+		case EntryTypeNumber, EntryTypeBoolean, EntryTypeJSON:
+			buffer.WriteString(entry.Value)
+		default:
+			value, err := json.Marshal(entry.Value)
+			if err != nil {
+				return nil, err
+			}
+			buffer.Write(value)
+		}
+	}
+	buffer.WriteByte('}')
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, buffer.Bytes(), "", "  "); err != nil {
+		return nil, fmt.Errorf("failed to format secret as JSON: %w", err)
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
+
+func parseYAMLEntries(data []byte) ([]SecretEntry, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	entries := make([]SecretEntry, 0)
+	if len(document.Content) == 0 {
+		return entries, nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a YAML mapping")
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		entry := SecretEntry{Key: keyNode.Value, Description: yamlComment(keyNode.HeadComment)}
+		switch {
+		case valueNode.Kind == yaml.MappingNode || valueNode.Kind == yaml.SequenceNode || valueNode.ShortTag() == "!!null":
+			value, err := yamlToJSON(valueNode)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", entry.Key, err)
+			}
+			entry.Type, entry.Value = EntryTypeJSON, value
+		case valueNode.Kind != yaml.ScalarNode:
+			return nil, fmt.Errorf("%s: aliases are not supported", entry.Key)
+		case valueNode.ShortTag() == "!!int" || valueNode.ShortTag() == "!!float":
+			if !numberPattern.MatchString(valueNode.Value) {
+				return nil, fmt.Errorf("%s: unsupported number %s, quote it to keep it as a string", entry.Key, valueNode.Value)
+			}
+			entry.Type, entry.Value = EntryTypeNumber, valueNode.Value
+		case valueNode.ShortTag() == "!!bool":
+			value, _ := strconv.ParseBool(strings.ToLower(valueNode.Value))
+			entry.Type, entry.Value = EntryTypeBoolean, strconv.FormatBool(value)
+		default:
+			entry.Type, entry.Value = EntryTypeString, valueNode.Value
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func formatYAMLEntries(entries []SecretEntry) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, entry := range entries {
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: entry.Key}
+		if entry.Description != "" {
+			lines := strings.Split(entry.Description, "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimRight("# "+line, " ")
+			}
+			keyNode.HeadComment = strings.Join(lines, "\n")
+		}
+		valueNode := &yaml.Node{Kind: yaml.ScalarNode, Value: entry.Value}
+		switch entry.Type {
+		case EntryTypeNumber:
+			valueNode.Tag = "!!float"
+			if !strings.ContainsAny(entry.Value, ".eE") {
+				valueNode.Tag = "!!int"
+			}
+		case EntryTypeBoolean:
+			valueNode.Tag = "!!bool"
+		case EntryTypeJSON:
+			var document yaml.Node
+			if err := yaml.Unmarshal([]byte(entry.Value), &document); err != nil {
+				return nil, fmt.Errorf("failed to format %s as YAML: %w", entry.Key, err)
+			}
+			valueNode = document.Content[0]
+		default:
+			valueNode.Tag = "!!str"
+			// Double quotes keep every character, including trailing spaces and line breaks
+			valueNode.Style = yaml.DoubleQuotedStyle
+		}
+		root.Content = append(root.Content, keyNode, valueNode)
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, fmt.Errorf("failed to format secret as YAML: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// yamlComment strips the # of every comment line
+func yamlComment(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		line = strings.TrimPrefix(line, "#")
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeJSON writes a JSON value compactly with its keys in their original order, JSON read from YAML
+// and from JSON ends up as the same text
+func normalizeJSON(value string) (string, error) {
+	if !json.Valid([]byte(value)) {
+		return "", fmt.Errorf("invalid JSON")
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &document); err != nil {
+		return "", err
+	}
+	return yamlToJSON(document.Content[0])
+}
+
+// yamlToJSON converts a YAML value to compact JSON, mappings keep the order of their keys
+func yamlToJSON(node *yaml.Node) (string, error) {
+	var buffer bytes.Buffer
+	if err := writeYAMLAsJSON(&buffer, node); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+func writeYAMLAsJSON(buffer *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		buffer.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := writeJSONString(buffer, node.Content[i].Value); err != nil {
+				return err
+			}
+			buffer.WriteByte(':')
+			if err := writeYAMLAsJSON(buffer, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('}')
+	case yaml.SequenceNode:
+		buffer.WriteByte('[')
+		for i, child := range node.Content {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := writeYAMLAsJSON(buffer, child); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte(']')
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			buffer.WriteString("null")
+		case "!!bool":
+			value, _ := strconv.ParseBool(strings.ToLower(node.Value))
+			buffer.WriteString(strconv.FormatBool(value))
+		case "!!int", "!!float":
+			if !numberPattern.MatchString(node.Value) {
+				return fmt.Errorf("unsupported number %s", node.Value)
+			}
+			buffer.WriteString(node.Value)
+		default:
+			return writeJSONString(buffer, node.Value)
+		}
+	default:
+		return fmt.Errorf("aliases are not supported")
+	}
+	return nil
+}
+
+func writeJSONString(buffer *bytes.Buffer, value string) error {
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	// Encode ends the value with a line break
+	buffer.Truncate(buffer.Len() - 1)
+	return nil
+}
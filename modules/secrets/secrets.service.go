@@ -7,6 +7,7 @@ import (
 	"deployer.com/libs"
 	"deployer.com/modules/secrets/dto"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SecretsService struct {
@@ -15,11 +16,16 @@ type SecretsService struct {
 }
 
 type SecretResponse struct {
-	ID        uint      `json:"id"`
-	Name      string    `json:"name"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	// Content of a structured secret is its entries written as dotenv
+	Content string `json:"content"`
+	Mode    string `json:"mode"`
+	// Entries is only set for structured secrets
+	Entries   []SecretEntry `json:"entries,omitempty"`
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
 }
 
 func NewSecretsService(db *gorm.DB) *SecretsService {
@@ -28,22 +34,16 @@ func NewSecretsService(db *gorm.DB) *SecretsService {
 
 func (s *SecretsService) GetSecrets(userId uint, iv string) ([]SecretResponse, error) {
 	var secrets []Secret
-	if err := s.db.Where("user_id = ?", userId).Select("id, name, content, created_at").Order("created_at DESC").Find(&secrets).Error; err != nil {
+	if err := s.db.Where("user_id = ?", userId).Select("id, name, content, mode, version, created_at").Order("created_at DESC").Find(&secrets).Error; err != nil {
 		return nil, err
 	}
 	result := make([]SecretResponse, len(secrets))
 	for i, secret := range secrets {
-		decoded, err := s.encryptionService.Decrypt(secret.Content, iv)
+		response, err := s.convertToResponse(secret, iv)
 		if err != nil {
 			return nil, err
 		}
-		result[i] = SecretResponse{
-			ID:        secret.ID,
-			Name:      secret.Name,
-			Content:   decoded,
-			CreatedAt: secret.CreatedAt,
-			UpdatedAt: secret.UpdatedAt,
-		}
+		result[i] = response
 	}
 	return result, nil
 }
@@ -53,21 +53,20 @@ func (s *SecretsService) GetSecret(id, userId uint, iv string) (SecretResponse,
 	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&secret).Error; err != nil {
 		return SecretResponse{}, err
 	}
-	decoded, err := s.encryptionService.Decrypt(secret.Content, iv)
-	if err != nil {
-		return SecretResponse{}, err
-	}
-	return SecretResponse{
-		ID:        secret.ID,
-		Name:      secret.Name,
-		Content:   decoded,
-		CreatedAt: secret.CreatedAt,
-		UpdatedAt: secret.UpdatedAt,
-	}, nil
+	return s.convertToResponse(secret, iv)
 }
 
+// CreateSecret stores the secret as its first version, the content of a structured secret is read as dotenv
 func (s *SecretsService) CreateSecret(userId uint, dto dto.CreateSecretDto, iv string) (SecretResponse, error) {
-	encrypted, err := s.encryptionService.Encrypt(dto.Content, iv)
+	mode := dto.Mode
+	if mode == "" {
+		mode = SecretModeRaw
+	}
+	content, err := s.storedContent(mode, dto.Content)
+	if err != nil {
+		return SecretResponse{}, err
+	}
+	encrypted, err := s.encryptionService.Encrypt(content, iv)
 	if err != nil {
 		return SecretResponse{}, err
 	}
@@ -75,58 +74,132 @@ func (s *SecretsService) CreateSecret(userId uint, dto dto.CreateSecretDto, iv s
 		Name:    dto.Name,
 		Content: encrypted,
 		UserID:  userId,
+		Version: 1,
+		Mode:    mode,
 	}
-	if err := s.db.Create(&secret).Error; err != nil {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&secret).Error; err != nil {
+			return err
+		}
+		return s.createVersion(tx, secret, userId, nil)
+	})
+	if err != nil {
 		return SecretResponse{}, err
 	}
-	return SecretResponse{
-		ID:        secret.ID,
-		Name:      secret.Name,
-		Content:   dto.Content,
-		CreatedAt: secret.CreatedAt,
-		UpdatedAt: secret.UpdatedAt,
-	}, nil
+	return s.convertToResponse(secret, iv)
 }
 
+// UpdateSecret applies the updates, a changed content is stored as a new version authored by the user. The
+// content of a structured secret is read as dotenv
 func (s *SecretsService) UpdateSecret(id, userId uint, updates map[string]interface{}, iv string) (SecretResponse, error) {
 	var secret Secret
-	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&secret).Error; err != nil {
-		return SecretResponse{}, err
-	}
-	libs.SetStructFieldsFromMap(&secret, updates)
-	if updates["content"] != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Locked so concurrent updates get consecutive versions
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", id, userId).First(&secret).Error; err != nil {
+			return err
+		}
+		if err := s.ensureCurrentVersion(tx, secret); err != nil {
+			return err
+		}
+		stored := secret.Content
+		current, err := s.encryptionService.Decrypt(stored, iv)
+		if err != nil {
+			return err
+		}
+		libs.SetStructFieldsFromMap(&secret, updates)
+		if updates["content"] != nil {
+			secret.Content, err = s.storedContent(secret.Mode, secret.Content)
+			if err != nil {
+				return err
+			}
+		}
+		if updates["content"] == nil || secret.Content == current {
+			// Only the name changed, the current version stays
+			secret.Content = stored
+			return tx.Save(&secret).Error
+		}
+
 		encrypted, err := s.encryptionService.Encrypt(secret.Content, iv)
 		if err != nil {
-			return SecretResponse{}, err
+			return err
 		}
 		secret.Content = encrypted
-	}
-	if err := s.db.Save(&secret).Error; err != nil {
+		secret.Version++
+		if err := tx.Save(&secret).Error; err != nil {
+			return err
+		}
+		return s.createVersion(tx, secret, userId, nil)
+	})
+	if err != nil {
 		return SecretResponse{}, err
 	}
+	return s.convertToResponse(secret, iv)
+}
+
+func (s *SecretsService) DeleteSecret(id, userId uint) error {
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).Delete(&Secret{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetEnvMap returns the values of the secret by key
+func (s *SecretsService) GetEnvMap(secret SecretResponse) map[string]string {
+	if secret.Mode == SecretModeStructured {
+		result := make(map[string]string, len(secret.Entries))
+		for _, entry := range secret.Entries {
+			result[entry.Key] = entry.Value
+		}
+		return result
+	}
+	return envMap(secret.Mode, secret.Content)
+}
+
+// storedContent returns the content to encrypt for a secret in mode, structured secrets read content as dotenv
+func (s *SecretsService) storedContent(mode, content string) (string, error) {
+	if mode != SecretModeStructured {
+		return content, nil
+	}
+	imported, err := ParseSecretEntries(FormatDotenv, []byte(content))
+	if err != nil {
+		return "", err
+	}
+	entries, err := mergeEntries(nil, imported, true)
+	if err != nil {
+		return "", err
+	}
+	return encodeEntries(entries)
+}
+
+func (s *SecretsService) convertToResponse(secret Secret, iv string) (SecretResponse, error) {
 	decoded, err := s.encryptionService.Decrypt(secret.Content, iv)
 	if err != nil {
 		return SecretResponse{}, err
 	}
-	return SecretResponse{
+	response := SecretResponse{
 		ID:        secret.ID,
 		Name:      secret.Name,
 		Content:   decoded,
+		Mode:      secret.Mode,
+		Version:   secret.Version,
 		CreatedAt: secret.CreatedAt,
 		UpdatedAt: secret.UpdatedAt,
-	}, nil
-}
-
-func (s *SecretsService) DeleteSecret(id, userId uint) error {
-	if err := s.db.Where("id = ? AND user_id = ?", id, userId).Delete(&Secret{}).Error; err != nil {
-		return err
 	}
-	return nil
+	if secret.Mode == SecretModeStructured {
+		response.Entries, err = decodeEntries(secret.Mode, decoded)
+		if err != nil {
+			return SecretResponse{}, err
+		}
+		response.Content = entriesToDotenv(response.Entries)
+	}
+	return response, nil
 }
 
-func (s *SecretsService) GetEnvMap(secret SecretResponse) map[string]string {
+// parseEnvLines splits KEY=value lines without any quoting, other lines are skipped
+func parseEnvLines(content string) map[string]string {
 	envMap := make(map[string]string)
-	lines := strings.Split(secret.Content, "\n")
+	lines := strings.Split(content, "\n")
 	for _, line := range lines {
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) == 2 {
@@ -0,0 +1,248 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrVersionIsCurrent is returned when restoring the version that is already current
+var ErrVersionIsCurrent = errors.New("version is already the current version")
+
+// maskedValue replaces every value in a diff, only whether a value is set shows
+const maskedValue = "********"
+
+type SecretVersionResponse struct {
+	Version      int    `json:"version"`
+	AuthorID     uint   `json:"author_id"`
+	Author       string `json:"author"`
+	RestoredFrom *int   `json:"restored_from"`
+	Current      bool   `json:"current"`
+	Mode         string `json:"mode"`
+	// Content and Entries are only returned for a single version
+	Content   string        `json:"content,omitempty"`
+	Entries   []SecretEntry `json:"entries,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type SecretDiffResponse struct {
+	From    int               `json:"from"`
+	To      int               `json:"to"`
+	Added   []SecretDiffEntry `json:"added"`
+	Removed []SecretDiffEntry `json:"removed"`
+	Changed []SecretDiffEntry `json:"changed"`
+}
+
+// SecretDiffEntry is a key of the content, its values are masked
+type SecretDiffEntry struct {
+	Key      string `json:"key"`
+	OldValue string `json:"old_value,omitempty"`
+	NewValue string `json:"new_value,omitempty"`
+}
+
+// createVersion stores the current content of the secret as its version secret.Version
+func (s *SecretsService) createVersion(tx *gorm.DB, secret Secret, authorId uint, restoredFrom *int) error {
+	version := SecretVersion{
+		SecretID:     secret.ID,
+		Version:      secret.Version,
+		Content:      secret.Content,
+		Mode:         secret.Mode,
+		AuthorID:     authorId,
+		RestoredFrom: restoredFrom,
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return fmt.Errorf("failed to create secret version: %w", err)
+	}
+	return nil
+}
+
+// ensureCurrentVersion stores the current content as its version when that version is missing. Secrets
+// created before versions existed have none when only AutoMigrate ran, their first edit would lose the content
+func (s *SecretsService) ensureCurrentVersion(tx *gorm.DB, secret Secret) error {
+	var count int64
+	if err := tx.Model(&SecretVersion{}).Where("secret_id = ? AND version = ?", secret.ID, secret.Version).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check secret version: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.createVersion(tx, secret, secret.UserID, nil)
+}
+
+func (s *SecretsService) findSecret(id, userId uint) (Secret, error) {
+	var secret Secret
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&secret).Error; err != nil {
+		return Secret{}, err
+	}
+	return secret, nil
+}
+
+func (s *SecretsService) findVersion(secretId uint, version int) (SecretVersion, error) {
+	var result SecretVersion
+	if err := s.db.Preload("Author").Where("secret_id = ? AND version = ?", secretId, version).
+		First(&result).Error; err != nil {
+		return SecretVersion{}, err
+	}
+	return result, nil
+}
+
+func (s *SecretsService) convertToVersionResponse(version SecretVersion, current int) SecretVersionResponse {
+	return SecretVersionResponse{
+		Version:      version.Version,
+		AuthorID:     version.AuthorID,
+		Author:       version.Author.Username,
+		RestoredFrom: version.RestoredFrom,
+		Current:      version.Version == current,
+		Mode:         version.Mode,
+		CreatedAt:    version.CreatedAt,
+	}
+}
+
+// GetSecretVersions lists the versions of a secret, newest first and without their content
+func (s *SecretsService) GetSecretVersions(id, userId uint) ([]SecretVersionResponse, error) {
+	secret, err := s.findSecret(id, userId)
+	if err != nil {
+		return nil, err
+	}
+	var versions []SecretVersion
+	if err := s.db.Preload("Author").Where("secret_id = ?", secret.ID).
+		Order("version DESC").Find(&versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get secret versions: %w", err)
+	}
+	result := make([]SecretVersionResponse, len(versions))
+	for i, version := range versions {
+		result[i] = s.convertToVersionResponse(version, secret.Version)
+	}
+	return result, nil
+}
+
+// GetSecretVersion returns a version of a secret with its decrypted content
+func (s *SecretsService) GetSecretVersion(id, userId uint, version int, iv string) (SecretVersionResponse, error) {
+	secret, err := s.findSecret(id, userId)
+	if err != nil {
+		return SecretVersionResponse{}, err
+	}
+	result, err := s.findVersion(secret.ID, version)
+	if err != nil {
+		return SecretVersionResponse{}, err
+	}
+	decoded, err := s.encryptionService.Decrypt(result.Content, iv)
+	if err != nil {
+		return SecretVersionResponse{}, err
+	}
+	response := s.convertToVersionResponse(result, secret.Version)
+	response.Content = decoded
+	if result.Mode == SecretModeStructured {
+		response.Entries, err = decodeEntries(result.Mode, decoded)
+		if err != nil {
+			return SecretVersionResponse{}, err
+		}
+		response.Content = entriesToDotenv(response.Entries)
+	}
+	return response, nil
+}
+
+// DiffSecretVersions compares the keys of two versions of a secret, to 0 compares with the current version
+// and from 0 with the version before to
+func (s *SecretsService) DiffSecretVersions(id, userId uint, from, to int, iv string) (SecretDiffResponse, error) {
+	secret, err := s.findSecret(id, userId)
+	if err != nil {
+		return SecretDiffResponse{}, err
+	}
+	if to == 0 {
+		to = secret.Version
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	contents := map[int]map[string]string{0: {}}
+	for _, number := range []int{from, to} {
+		// Version 0 is the empty secret, the first version diffs against it
+		if number == 0 {
+			continue
+		}
+		version, err := s.findVersion(secret.ID, number)
+		if err != nil {
+			return SecretDiffResponse{}, err
+		}
+		decoded, err := s.encryptionService.Decrypt(version.Content, iv)
+		if err != nil {
+			return SecretDiffResponse{}, err
+		}
+		contents[number] = envMap(version.Mode, decoded)
+	}
+	return diffEnv(from, to, contents[from], contents[to]), nil
+}
+
+// RestoreSecretVersion makes the content of an earlier version current again, as a new version
+func (s *SecretsService) RestoreSecretVersion(id, userId uint, version int, iv string) (SecretResponse, error) {
+	var secret Secret
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", id, userId).First(&secret).Error; err != nil {
+			return err
+		}
+		if err := s.ensureCurrentVersion(tx, secret); err != nil {
+			return err
+		}
+		var restored SecretVersion
+		if err := tx.Where("secret_id = ? AND version = ?", secret.ID, version).First(&restored).Error; err != nil {
+			return err
+		}
+		if restored.Version == secret.Version {
+			return ErrVersionIsCurrent
+		}
+		secret.Content = restored.Content
+		secret.Mode = restored.Mode
+		secret.Version++
+		if err := tx.Save(&secret).Error; err != nil {
+			return err
+		}
+		return s.createVersion(tx, secret, userId, &restored.Version)
+	})
+	if err != nil {
+		return SecretResponse{}, err
+	}
+	return s.convertToResponse(secret, iv)
+}
+
+func diffEnv(from, to int, before, after map[string]string) SecretDiffResponse {
+	result := SecretDiffResponse{
+		From:    from,
+		To:      to,
+		Added:   make([]SecretDiffEntry, 0),
+		Removed: make([]SecretDiffEntry, 0),
+		Changed: make([]SecretDiffEntry, 0),
+	}
+	for key, value := range after {
+		old, ok := before[key]
+		if !ok {
+			result.Added = append(result.Added, SecretDiffEntry{Key: key, NewValue: maskValue(value)})
+		} else if old != value {
+			result.Changed = append(result.Changed, SecretDiffEntry{Key: key, OldValue: maskValue(old), NewValue: maskValue(value)})
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			result.Removed = append(result.Removed, SecretDiffEntry{Key: key, OldValue: maskValue(value)})
+		}
+	}
+	for _, entries := range [][]SecretDiffEntry{result.Added, result.Removed, result.Changed} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Key < entries[j].Key
+		})
+	}
+	return result
+}
+
+func maskValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return maskedValue
+}
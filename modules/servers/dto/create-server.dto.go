@@ -3,12 +3,16 @@ package dto
 import "github.com/go-playground/validator/v10"
 
 type CreateServerDto struct {
-	Name     string  `json:"name" validate:"required,min=1,max=255"`
-	Host     string  `json:"host" validate:"required,min=1,max=255"`
-	Port     int     `json:"port" validate:"omitempty,min=1,max=65535"`
-	Username string  `json:"username" validate:"required,min=1,max=255"`
-	Password string  `json:"password" validate:"required,min=1,max=255"`
-	SSHKey   *string `json:"ssh_key" validate:"omitempty,min=1,max=10000"`
+	Name     string `json:"name" validate:"required,min=1,max=255"`
+	Host     string `json:"host" validate:"required,min=1,max=255"`
+	Port     int    `json:"port" validate:"omitempty,min=1,max=65535"`
+	Username string `json:"username" validate:"required,min=1,max=255"`
+	// Password or SSHKey is required, key-only servers need no password
+	Password         string  `json:"password" validate:"required_without=SSHKey,omitempty,max=255"`
+	SSHKey           *string `json:"ssh_key" validate:"required_without=Password,omitempty,min=1,max=10000"`
+	SSHKeyPassphrase *string `json:"ssh_key_passphrase" validate:"omitempty,max=1024"`
+	// HostKeyFingerprint pins the host key up front instead of trusting it on first connect
+	HostKeyFingerprint *string `json:"host_key_fingerprint" validate:"omitempty,startswith=SHA256:,max=255"`
 }
 
 func ValidateCreateServerDto(dto CreateServerDto) error {
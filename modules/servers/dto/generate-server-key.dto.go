@@ -0,0 +1,16 @@
+package dto
+
+import "github.com/go-playground/validator/v10"
+
+// GenerateServerKeyDto creates a keypair shared by the servers of the user, or bound to ServerID.
+// Install also adds the public key to the server and switches it to key auth
+type GenerateServerKeyDto struct {
+	Name     string `json:"name" validate:"required,min=1,max=255"`
+	ServerID *uint  `json:"server_id" validate:"omitempty,min=1"`
+	Install  bool   `json:"install"`
+}
+
+func ValidateGenerateServerKeyDto(dto GenerateServerKeyDto) error {
+	validate := validator.New()
+	return validate.Struct(dto)
+}
@@ -0,0 +1,12 @@
+package dto
+
+import "github.com/go-playground/validator/v10"
+
+type InstallServerKeyDto struct {
+	ServerID uint `json:"server_id" validate:"required"`
+}
+
+func ValidateInstallServerKeyDto(dto InstallServerKeyDto) error {
+	validate := validator.New()
+	return validate.Struct(dto)
+}
@@ -0,0 +1,14 @@
+package dto
+
+import "github.com/go-playground/validator/v10"
+
+// PinHostKeyDto pins the given host key or fingerprint, an empty body pins the key the server presents now
+type PinHostKeyDto struct {
+	HostKey     string `json:"host_key" validate:"omitempty,max=10000"`
+	Fingerprint string `json:"fingerprint" validate:"omitempty,startswith=SHA256:,max=255"`
+}
+
+func ValidatePinHostKeyDto(dto PinHostKeyDto) error {
+	validate := validator.New()
+	return validate.Struct(dto)
+}
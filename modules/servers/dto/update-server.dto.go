@@ -7,8 +7,10 @@ type UpdateServerDto struct {
 	Host     *string `json:"host" validate:"omitempty,min=1,max=255"`
 	Port     *int    `json:"port" validate:"omitempty,min=1,max=65535"`
 	Username *string `json:"username" validate:"omitempty,min=1,max=255"`
-	Password *string `json:"password" validate:"omitempty,min=1,max=255"`
-	SSHKey   *string `json:"ssh_key" validate:"omitempty,min=1,max=10000"`
+	Password *string `json:"password" validate:"omitempty,max=255"`
+	SSHKey   *string `json:"ssh_key" validate:"omitempty,max=10000"`
+	// SSHKeyPassphrase unlocks a passphrase protected key, an empty value removes it
+	SSHKeyPassphrase *string `json:"ssh_key_passphrase" validate:"omitempty,max=1024"`
 }
 
 func (dto *UpdateServerDto) GetUpdates() (map[string]interface{}, []string) {
@@ -38,6 +40,10 @@ func (dto *UpdateServerDto) GetUpdates() (map[string]interface{}, []string) {
 		updates["ssh_key"] = *dto.SSHKey
 		fields = append(fields, "ssh_key")
 	}
+	if dto.SSHKeyPassphrase != nil {
+		updates["ssh_key_passphrase"] = *dto.SSHKeyPassphrase
+		fields = append(fields, "ssh_key_passphrase")
+	}
 	return updates, fields
 }
 
@@ -7,6 +7,7 @@ import (
 	"deployer.com/modules/auth/guards"
 	"deployer.com/modules/servers/dto"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type ServersController struct {
@@ -19,11 +20,19 @@ func NewServersController(router *fiber.Router, serversService *ServersService)
 }
 
 func (c *ServersController) RegisterRoutes(router *fiber.Router) {
+	// Registered before /:id so "keys" is not taken for a server id
+	(*c.router).Get("/keys", guards.JwtGuard, c.GetKeys)
+	(*c.router).Post("/keys", guards.JwtGuard, c.GenerateKey)
+	(*c.router).Post("/keys/:id/install", guards.JwtGuard, c.InstallKey)
 	(*c.router).Get("/", guards.JwtGuard, c.GetServers)
 	(*c.router).Get("/:id", guards.JwtGuard, c.GetServer)
 	(*c.router).Post("/", guards.JwtGuard, c.CreateServer)
 	(*c.router).Patch("/:id", guards.JwtGuard, c.UpdateServer)
 	(*c.router).Delete("/:id", guards.JwtGuard, c.DeleteServer)
+	(*c.router).Post("/:id/test", guards.JwtGuard, c.TestServer)
+	(*c.router).Get("/:id/host-key", guards.JwtGuard, c.GetHostKey)
+	(*c.router).Put("/:id/host-key", guards.JwtGuard, c.PinHostKey)
+	(*c.router).Delete("/:id/host-key", guards.JwtGuard, c.ClearHostKey)
 }
 
 func (c *ServersController) GetServers(ctx *fiber.Ctx) error {
@@ -127,3 +136,168 @@ func (c *ServersController) DeleteServer(ctx *fiber.Ctx) error {
 		"message": "Secret deleted successfully",
 	})
 }
+
+// TestServer connects to the server and returns the collected facts, a failed check is reported in last_check_error
+func (c *ServersController) TestServer(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	facts, err := c.serversService.TestServer(uint(id), uint(userClaims.UserID), userClaims.IV)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Server not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(facts)
+}
+
+func (c *ServersController) GetHostKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	hostKey, err := c.serversService.GetHostKey(uint(id), uint(userClaims.UserID))
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(hostKey)
+}
+
+func (c *ServersController) PinHostKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	var body dto.PinHostKeyDto
+	// The body is optional, without one the current key of the server is pinned
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&body); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	if err := dto.ValidatePinHostKeyDto(body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	hostKey, err := c.serversService.PinHostKey(uint(id), uint(userClaims.UserID), body)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Server not found",
+			})
+		}
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(hostKey)
+}
+
+func (c *ServersController) ClearHostKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	if err := c.serversService.ClearHostKey(uint(id), uint(userClaims.UserID)); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Server not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Host key cleared successfully",
+	})
+}
+
+func (c *ServersController) GetKeys(ctx *fiber.Ctx) error {
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	keys, err := c.serversService.GetKeys(uint(userClaims.UserID))
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(keys)
+}
+
+func (c *ServersController) GenerateKey(ctx *fiber.Ctx) error {
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	var body dto.GenerateServerKeyDto
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := dto.ValidateGenerateServerKeyDto(body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	key, err := c.serversService.GenerateKey(uint(userClaims.UserID), body, userClaims.IV)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(key)
+}
+
+func (c *ServersController) InstallKey(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	var body dto.InstallServerKeyDto
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := dto.ValidateInstallServerKeyDto(body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	key, err := c.serversService.InstallKey(uint(id), uint(userClaims.UserID), body.ServerID, userClaims.IV)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Key or server not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(key)
+}
@@ -1,6 +1,8 @@
 package servers
 
 import (
+	"time"
+
 	"deployer.com/modules/users"
 	"gorm.io/gorm"
 )
@@ -8,15 +10,51 @@ import (
 type Server struct {
 	*gorm.Model
 	// ID        uint           `gorm:"primaryKey" json:"id"`
-	Name     string     `gorm:"not null" json:"name"`
-	Host     string     `gorm:"not null" json:"host"`
-	Port     int        `gorm:"not null;default:22" json:"port"`
-	Password string     `gorm:"not null" json:"-"`
-	SSHKey   *string    `json:"ssh_key"`
-	Username string     `gorm:"not null" json:"username"`
-	User     users.User `gorm:"foreignKey:UserID" json:"user"`
-	UserID   uint       `gorm:"not null" json:"user_id"`
+	Name     string  `gorm:"not null" json:"name"`
+	Host     string  `gorm:"not null" json:"host"`
+	Port     int     `gorm:"not null;default:22" json:"port"`
+	Password string  `gorm:"not null" json:"-"`
+	SSHKey   *string `json:"ssh_key"`
+	// SSHKeyPassphrase unlocks a passphrase protected SSHKey, both are stored encrypted
+	SSHKeyPassphrase *string    `json:"-"`
+	Username         string     `gorm:"not null" json:"username"`
+	User             users.User `gorm:"foreignKey:UserID" json:"user"`
+	UserID           uint       `gorm:"not null" json:"user_id"`
+	// HostKey is the pinned host key in authorized_keys format, HostKeyFingerprint its SHA256 fingerprint.
+	// The key is empty when only the fingerprint was supplied, it is filled in on the next connection
+	HostKey            string      `gorm:"type:text" json:"host_key"`
+	HostKeyFingerprint string      `json:"host_key_fingerprint"`
+	HostKeyPinnedAt    *time.Time  `json:"host_key_pinned_at"`
+	Facts              ServerFacts `gorm:"embedded" json:"facts"`
 	// CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	// UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	// DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
+
+// ServerFacts is the outcome of the last connectivity test of a server
+type ServerFacts struct {
+	Reachable      bool       `gorm:"not null;default:false" json:"reachable"`
+	Authenticated  bool       `gorm:"not null;default:false" json:"authenticated"`
+	OSRelease      string     `json:"os_release"`
+	Kernel         string     `json:"kernel"`
+	CPUCount       int        `json:"cpu_count"`
+	MemoryMB       int64      `json:"memory_mb"`
+	DiskTotalMB    int64      `json:"disk_total_mb"`
+	DiskFreeMB     int64      `json:"disk_free_mb"`
+	DockerVersion  string     `json:"docker_version"`
+	DockerAccess   bool       `gorm:"not null;default:false" json:"docker_access"`
+	LastCheckError string     `gorm:"type:text" json:"last_check_error"`
+	LastCheckedAt  *time.Time `json:"last_checked_at"`
+}
+
+// ServerKey is an ssh keypair generated by the deployer, for a single server or shared by the servers of a user
+type ServerKey struct {
+	gorm.Model
+	Name        string     `gorm:"not null" json:"name"`
+	PublicKey   string     `gorm:"type:text;not null" json:"public_key"`
+	PrivateKey  string     `gorm:"type:text;not null" json:"-"`
+	Fingerprint string     `gorm:"not null" json:"fingerprint"`
+	User        users.User `gorm:"foreignKey:UserID" json:"-"`
+	UserID      uint       `gorm:"not null" json:"user_id"`
+	ServerID    *uint      `gorm:"index" json:"server_id"`
+}
@@ -0,0 +1,136 @@
+package servers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"deployer.com/libs"
+)
+
+// factsScript prints one key=value pair per line, every probe tolerates missing tools
+const factsScript = `echo "os_release=$( (. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME") || uname -s)"
+echo "kernel=$(uname -r)"
+echo "cpu_count=$(nproc 2>/dev/null || getconf _NPROCESSORS_ONLN 2>/dev/null)"
+echo "memory_kb=$(awk '/^MemTotal:/ {print $2}' /proc/meminfo 2>/dev/null)"
+echo "disk_kb=$(df -Pk / 2>/dev/null | awk 'NR==2 {print $2" "$4}')"
+echo "docker_version=$(docker version --format '{{.Server.Version}}' 2>/dev/null || docker --version 2>/dev/null)"
+if docker info >/dev/null 2>&1; then echo "docker_access=true"; else echo "docker_access=false"; fi`
+
+const serverTestTimeout = 30 * time.Second
+
+// TestServer checks that the server is reachable and accepts the stored credentials, collects its facts
+// and stores them on the server. A failed check is not an error, it is reported in the facts
+func (s *ServersService) TestServer(id, userId uint, iv string) (ServerFacts, error) {
+	server, err := s.GetServer(id, userId, iv)
+	if err != nil {
+		return ServerFacts{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverTestTimeout)
+	defer cancel()
+
+	now := time.Now()
+	facts := ServerFacts{LastCheckedAt: &now}
+	if err := s.collectFacts(ctx, server, &facts); err != nil {
+		facts.LastCheckError = err.Error()
+	}
+
+	if err := s.db.Model(&Server{}).Where("id = ?", server.ID).Updates(map[string]interface{}{
+		"reachable":        facts.Reachable,
+		"authenticated":    facts.Authenticated,
+		"os_release":       facts.OSRelease,
+		"kernel":           facts.Kernel,
+		"cpu_count":        facts.CPUCount,
+		"memory_mb":        facts.MemoryMB,
+		"disk_total_mb":    facts.DiskTotalMB,
+		"disk_free_mb":     facts.DiskFreeMB,
+		"docker_version":   facts.DockerVersion,
+		"docker_access":    facts.DockerAccess,
+		"last_check_error": facts.LastCheckError,
+		"last_checked_at":  facts.LastCheckedAt,
+	}).Error; err != nil {
+		return ServerFacts{}, fmt.Errorf("failed to store server facts: %w", err)
+	}
+	return facts, nil
+}
+
+// executorConfig checks the host key of the server and returns the config to connect with its stored credentials
+func (s *ServersService) executorConfig(ctx context.Context, server ServerResponse) (libs.SSHExecutorConfig, error) {
+	hostKey, err := s.CheckHostKey(ctx, server.ID)
+	if err != nil {
+		return libs.SSHExecutorConfig{}, err
+	}
+	return libs.SSHExecutorConfig{
+		Host:                 server.Host,
+		Port:                 server.Port,
+		User:                 server.Username,
+		Password:             server.Password,
+		PrivateKey:           server.SSHKey,
+		PrivateKeyPassphrase: server.SSHKeyPassphrase,
+		HostKeyFingerprint:   hostKey.Fingerprint,
+		HostKey:              hostKey.HostKey,
+	}, nil
+}
+
+func (s *ServersService) collectFacts(ctx context.Context, server ServerResponse, facts *ServerFacts) error {
+	config, err := s.executorConfig(ctx, server)
+	if err != nil {
+		// A server presenting the wrong host key still answered
+		var mismatch *libs.HostKeyMismatchError
+		facts.Reachable = errors.As(err, &mismatch)
+		return err
+	}
+	facts.Reachable = true
+
+	result, err := s.sshExecutor.Run(ctx, &config, factsScript, nil)
+	if err != nil {
+		return err
+	}
+	facts.Authenticated = true
+	if result.ExitCode != 0 {
+		return fmt.Errorf("facts script exited with code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	parseFacts(result.Stdout, facts)
+	return nil
+}
+
+// parseFacts reads the key=value lines printed by the facts script, missing or malformed values stay unset
+func parseFacts(output string, facts *ServerFacts) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "os_release":
+			facts.OSRelease = value
+		case "kernel":
+			facts.Kernel = value
+		case "cpu_count":
+			facts.CPUCount, _ = strconv.Atoi(value)
+		case "memory_kb":
+			memory, _ := strconv.ParseInt(value, 10, 64)
+			facts.MemoryMB = memory / 1024
+		case "disk_kb":
+			// Total and available size of the root filesystem
+			fields := strings.Fields(value)
+			if len(fields) == 2 {
+				total, _ := strconv.ParseInt(fields[0], 10, 64)
+				free, _ := strconv.ParseInt(fields[1], 10, 64)
+				facts.DiskTotalMB = total / 1024
+				facts.DiskFreeMB = free / 1024
+			}
+		case "docker_version":
+			facts.DockerVersion = value
+		case "docker_access":
+			facts.DockerAccess = value == "true"
+		}
+	}
+}
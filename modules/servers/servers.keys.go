@@ -0,0 +1,159 @@
+package servers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/servers/dto"
+)
+
+// installKeyScript appends the public key to authorized_keys once, %s is the quoted public key line
+const installKeyScript = `umask 077
+mkdir -p ~/.ssh
+touch ~/.ssh/authorized_keys
+grep -qxF %[1]s ~/.ssh/authorized_keys || printf '%%s\n' %[1]s >> ~/.ssh/authorized_keys
+chmod 700 ~/.ssh
+chmod 600 ~/.ssh/authorized_keys`
+
+type ServerKeyResponse struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"public_key"`
+	Fingerprint string    `json:"fingerprint"`
+	ServerID    *uint     `json:"server_id"`
+	Installed   bool      `json:"installed"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (s *ServersService) convertToKeyResponse(key ServerKey) ServerKeyResponse {
+	return ServerKeyResponse{
+		ID:          key.ID,
+		Name:        key.Name,
+		PublicKey:   key.PublicKey,
+		Fingerprint: key.Fingerprint,
+		ServerID:    key.ServerID,
+		CreatedAt:   key.CreatedAt,
+	}
+}
+
+func (s *ServersService) GetKeys(userId uint) ([]ServerKeyResponse, error) {
+	var keys []ServerKey
+	if err := s.db.Where("user_id = ?", userId).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	result := make([]ServerKeyResponse, len(keys))
+	for i, key := range keys {
+		result[i] = s.convertToKeyResponse(key)
+	}
+	return result, nil
+}
+
+// GenerateKey creates an ed25519 keypair, only the public key ever leaves the service
+func (s *ServersService) GenerateKey(userId uint, dto dto.GenerateServerKeyDto, iv string) (ServerKeyResponse, error) {
+	if dto.Install && dto.ServerID == nil {
+		return ServerKeyResponse{}, fmt.Errorf("server_id is required to install the key")
+	}
+	if dto.ServerID != nil {
+		var count int64
+		if err := s.db.Model(&Server{}).Where("id = ? AND user_id = ?", *dto.ServerID, userId).Count(&count).Error; err != nil {
+			return ServerKeyResponse{}, err
+		}
+		if count == 0 {
+			return ServerKeyResponse{}, fmt.Errorf("server not found")
+		}
+	}
+
+	privateKey, publicKey, err := libs.GenerateSSHKeyPair("deployer")
+	if err != nil {
+		return ServerKeyResponse{}, err
+	}
+	parsedPublicKey, err := libs.ParseHostKey(publicKey)
+	if err != nil {
+		return ServerKeyResponse{}, err
+	}
+	encryptedPrivateKey, err := s.encryptionService.Encrypt(privateKey, iv)
+	if err != nil {
+		return ServerKeyResponse{}, err
+	}
+	key := ServerKey{
+		Name:        dto.Name,
+		PublicKey:   publicKey,
+		PrivateKey:  encryptedPrivateKey,
+		Fingerprint: libs.HostKeyFingerprint(parsedPublicKey),
+		UserID:      userId,
+		ServerID:    dto.ServerID,
+	}
+	if err := s.db.Create(&key).Error; err != nil {
+		return ServerKeyResponse{}, err
+	}
+
+	if dto.Install {
+		response, err := s.InstallKey(key.ID, userId, *dto.ServerID, iv)
+		if err != nil {
+			// Nobody has seen the key yet, keeping it would only leave a private key behind that is never used
+			if deleteErr := s.db.Unscoped().Delete(&key).Error; deleteErr != nil {
+				fmt.Printf("ERROR: Failed to delete key %d after its install failed: %v\n", key.ID, deleteErr)
+			}
+			return ServerKeyResponse{}, err
+		}
+		return response, nil
+	}
+	return s.convertToKeyResponse(key), nil
+}
+
+// InstallKey adds the public key to authorized_keys of the server using its current credentials,
+// checks that the key alone logs in and then switches the server to the key
+func (s *ServersService) InstallKey(id, userId, serverId uint, iv string) (ServerKeyResponse, error) {
+	var key ServerKey
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&key).Error; err != nil {
+		return ServerKeyResponse{}, err
+	}
+	if key.ServerID != nil && *key.ServerID != serverId {
+		return ServerKeyResponse{}, fmt.Errorf("key belongs to another server")
+	}
+	server, err := s.GetServer(serverId, userId, iv)
+	if err != nil {
+		return ServerKeyResponse{}, err
+	}
+	privateKey, err := s.encryptionService.Decrypt(key.PrivateKey, iv)
+	if err != nil {
+		return ServerKeyResponse{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverTestTimeout)
+	defer cancel()
+
+	config, err := s.executorConfig(ctx, server)
+	if err != nil {
+		return ServerKeyResponse{}, err
+	}
+	result, err := s.sshExecutor.Run(ctx, &config, fmt.Sprintf(installKeyScript, libs.ShellQuote(key.PublicKey)), nil)
+	if err != nil {
+		return ServerKeyResponse{}, fmt.Errorf("failed to install key: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return ServerKeyResponse{}, fmt.Errorf("failed to install key, exit code %d: %s", result.ExitCode, result.Stderr)
+	}
+
+	keyConfig := config
+	keyConfig.Password = ""
+	keyConfig.PrivateKey = privateKey
+	keyConfig.PrivateKeyPassphrase = ""
+	if _, err := s.sshExecutor.Run(ctx, &keyConfig, "true", nil); err != nil {
+		return ServerKeyResponse{}, fmt.Errorf("key was installed but does not log in: %w", err)
+	}
+
+	// Both are encrypted with the IV of the user, so the ciphertext can be reused as is
+	if err := s.db.Model(&Server{}).Where("id = ?", server.ID).Updates(map[string]interface{}{
+		"ssh_key":            key.PrivateKey,
+		"ssh_key_passphrase": nil,
+	}).Error; err != nil {
+		return ServerKeyResponse{}, err
+	}
+
+	response := s.convertToKeyResponse(key)
+	response.Installed = true
+	return response, nil
+}
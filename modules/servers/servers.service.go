@@ -1,66 +1,104 @@
 package servers
 
 import (
+	"context"
+	"fmt"
 	"time"
 
 	"deployer.com/libs"
 	"deployer.com/modules/servers/dto"
+	"golang.org/x/crypto/ssh"
 	"gorm.io/gorm"
 )
 
 type ServersService struct {
 	db                *gorm.DB
 	encryptionService *libs.EncryptionService
+	sshExecutor       *libs.SSHExecutor
 }
 
 type ServerResponse struct {
-	ID        uint      `json:"id"`
-	Name      string    `json:"name"`
-	Username  string    `json:"username"`
-	Host      string    `json:"host"`
-	Port      int       `json:"port"`
-	SSHKey    string    `json:"ssh_key"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                 uint        `json:"id"`
+	Name               string      `json:"name"`
+	Username           string      `json:"username"`
+	Host               string      `json:"host"`
+	Port               int         `json:"port"`
+	SSHKey             string      `json:"ssh_key"`
+	SSHKeyPassphrase   string      `json:"-"`
+	Password           string      `json:"password"`
+	HostKey            string      `json:"host_key"`
+	HostKeyFingerprint string      `json:"host_key_fingerprint"`
+	HostKeyPinnedAt    *time.Time  `json:"host_key_pinned_at"`
+	Facts              ServerFacts `json:"facts"`
+	CreatedAt          time.Time   `json:"created_at"`
+	UpdatedAt          time.Time   `json:"updated_at"`
 }
 
 func NewServersService(db *gorm.DB) *ServersService {
-	return &ServersService{db: db, encryptionService: libs.NewEncryptionService()}
+	return &ServersService{db: db, encryptionService: libs.NewEncryptionService(), sshExecutor: libs.NewSSHExecutor()}
+}
+
+func (s *ServersService) decryptOptional(value *string, iv string) (string, error) {
+	if value == nil || *value == "" {
+		return "", nil
+	}
+	return s.encryptionService.Decrypt(*value, iv)
+}
+
+func (s *ServersService) encryptOptional(value string, iv string) (*string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	encrypted, err := s.encryptionService.Encrypt(value, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &encrypted, nil
+}
+
+func (s *ServersService) convertToResponse(server Server, iv string) (ServerResponse, error) {
+	decodedSSHKey, err := s.decryptOptional(server.SSHKey, iv)
+	if err != nil {
+		return ServerResponse{}, err
+	}
+	decodedPassphrase, err := s.decryptOptional(server.SSHKeyPassphrase, iv)
+	if err != nil {
+		return ServerResponse{}, err
+	}
+	decodedPassword, err := s.decryptOptional(&server.Password, iv)
+	if err != nil {
+		return ServerResponse{}, err
+	}
+	return ServerResponse{
+		ID:                 server.ID,
+		Name:               server.Name,
+		Username:           server.Username,
+		Host:               server.Host,
+		Port:               server.Port,
+		SSHKey:             decodedSSHKey,
+		SSHKeyPassphrase:   decodedPassphrase,
+		Password:           decodedPassword,
+		HostKey:            server.HostKey,
+		HostKeyFingerprint: server.HostKeyFingerprint,
+		HostKeyPinnedAt:    server.HostKeyPinnedAt,
+		Facts:              server.Facts,
+		CreatedAt:          server.CreatedAt,
+		UpdatedAt:          server.UpdatedAt,
+	}, nil
 }
 
 func (s *ServersService) GetServers(userId uint, iv string) ([]ServerResponse, error) {
 	var servers []Server
-	if err := s.db.Where("user_id = ?", userId).Select("id, name,username, host, port, ssh_key, password, created_at").Order("created_at DESC").Find(&servers).Error; err != nil {
+	if err := s.db.Where("user_id = ?", userId).Select("id, name,username, host, port, ssh_key, ssh_key_passphrase, password, host_key, host_key_fingerprint, host_key_pinned_at, reachable, authenticated, os_release, kernel, cpu_count, memory_mb, disk_total_mb, disk_free_mb, docker_version, docker_access, last_check_error, last_checked_at, created_at").Order("created_at DESC").Find(&servers).Error; err != nil {
 		return nil, err
 	}
 	result := make([]ServerResponse, len(servers))
 	for i, server := range servers {
-		var err error
-		var decodedSSHKey string
-		if server.SSHKey != nil {
-			decodedSSHKey, err = s.encryptionService.Decrypt(*server.SSHKey, iv)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			decodedSSHKey = ""
-		}
-		decodedPassword, err := s.encryptionService.Decrypt(server.Password, iv)
+		response, err := s.convertToResponse(server, iv)
 		if err != nil {
 			return nil, err
 		}
-		result[i] = ServerResponse{
-			ID:        server.ID,
-			Name:      server.Name,
-			Username:  server.Username,
-			Host:      server.Host,
-			Port:      server.Port,
-			SSHKey:    decodedSSHKey,
-			Password:  decodedPassword,
-			CreatedAt: server.CreatedAt,
-			UpdatedAt: server.UpdatedAt,
-		}
+		result[i] = response
 	}
 	return result, nil
 }
@@ -70,82 +108,57 @@ func (s *ServersService) GetServer(id, userId uint, iv string) (ServerResponse,
 	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&server).Error; err != nil {
 		return ServerResponse{}, err
 	}
-	var err error
-	var decodedSSHKey string
-	if server.SSHKey != nil {
-		decodedSSHKey, err = s.encryptionService.Decrypt(*server.SSHKey, iv)
-		if err != nil {
-			return ServerResponse{}, err
-		}
-	} else {
-		decodedSSHKey = ""
-	}
-	decodedPassword, err := s.encryptionService.Decrypt(server.Password, iv)
-	if err != nil {
-		return ServerResponse{}, err
-	}
-	return ServerResponse{
-		ID:        server.ID,
-		Name:      server.Name,
-		Username:  server.Username,
-		Host:      server.Host,
-		Port:      server.Port,
-		SSHKey:    decodedSSHKey,
-		Password:  decodedPassword,
-		CreatedAt: server.CreatedAt,
-		UpdatedAt: server.UpdatedAt,
-	}, nil
+	return s.convertToResponse(server, iv)
 }
 
 func (s *ServersService) CreateServer(userId uint, dto dto.CreateServerDto, iv string) (ServerResponse, error) {
-	var err error
-	var encryptedSSHKey string
+	sshKey := ""
 	if dto.SSHKey != nil {
-		encryptedSSHKey, err = s.encryptionService.Encrypt(*dto.SSHKey, iv)
-		if err != nil {
-			return ServerResponse{}, err
-		}
+		sshKey = *dto.SSHKey
 	}
-	encryptedPassword, err := s.encryptionService.Encrypt(dto.Password, iv)
-	if err != nil {
+	passphrase := ""
+	if dto.SSHKeyPassphrase != nil {
+		passphrase = *dto.SSHKeyPassphrase
+	}
+	if err := validateCredentials(dto.Password, sshKey, passphrase); err != nil {
 		return ServerResponse{}, err
 	}
-	server := Server{
-		Name:     dto.Name,
-		Username: dto.Username,
-		Host:     dto.Host,
-		Port:     dto.Port,
-		UserID:   userId,
-		SSHKey:   &encryptedSSHKey,
-		Password: encryptedPassword,
+
+	encryptedSSHKey, err := s.encryptOptional(sshKey, iv)
+	if err != nil {
+		return ServerResponse{}, err
 	}
-	if err := s.db.Create(&server).Error; err != nil {
+	encryptedPassphrase, err := s.encryptOptional(passphrase, iv)
+	if err != nil {
 		return ServerResponse{}, err
 	}
-	var decodedSSHKey string
-	if server.SSHKey != nil {
-		decodedSSHKey, err = s.encryptionService.Decrypt(*server.SSHKey, iv)
+	// Key-only servers keep an empty password
+	encryptedPassword := ""
+	if dto.Password != "" {
+		encryptedPassword, err = s.encryptionService.Encrypt(dto.Password, iv)
 		if err != nil {
 			return ServerResponse{}, err
 		}
-	} else {
-		decodedSSHKey = ""
 	}
-	decodedPassword, err := s.encryptionService.Decrypt(server.Password, iv)
-	if err != nil {
+	server := Server{
+		Name:             dto.Name,
+		Username:         dto.Username,
+		Host:             dto.Host,
+		Port:             dto.Port,
+		UserID:           userId,
+		SSHKey:           encryptedSSHKey,
+		SSHKeyPassphrase: encryptedPassphrase,
+		Password:         encryptedPassword,
+	}
+	if dto.HostKeyFingerprint != nil {
+		now := time.Now()
+		server.HostKeyFingerprint = *dto.HostKeyFingerprint
+		server.HostKeyPinnedAt = &now
+	}
+	if err := s.db.Create(&server).Error; err != nil {
 		return ServerResponse{}, err
 	}
-	return ServerResponse{
-		ID:        server.ID,
-		Name:      server.Name,
-		Username:  server.Username,
-		Host:      server.Host,
-		Port:      server.Port,
-		SSHKey:    decodedSSHKey,
-		Password:  decodedPassword,
-		CreatedAt: server.CreatedAt,
-		UpdatedAt: server.UpdatedAt,
-	}, nil
+	return s.convertToResponse(server, iv)
 }
 
 func (s *ServersService) UpdateServer(id, userId uint, updates map[string]interface{}, iv string) (ServerResponse, error) {
@@ -153,52 +166,64 @@ func (s *ServersService) UpdateServer(id, userId uint, updates map[string]interf
 	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&server).Error; err != nil {
 		return ServerResponse{}, err
 	}
+	current, err := s.convertToResponse(server, iv)
+	if err != nil {
+		return ServerResponse{}, err
+	}
+
+	// Another address is another machine, its host key gets pinned again on the next connection
+	hostChanged := (updates["host"] != nil && updates["host"] != server.Host) || (updates["port"] != nil && updates["port"] != server.Port)
 	libs.SetStructFieldsFromMap(&server, updates)
+	if hostChanged {
+		server.HostKey = ""
+		server.HostKeyFingerprint = ""
+		server.HostKeyPinnedAt = nil
+	}
 
+	// An empty ssh_key, passphrase or password removes it
 	if updates["ssh_key"] != nil {
-		encryptedSSHKey, err := s.encryptionService.Encrypt(updates["ssh_key"].(string), iv)
-		if err != nil {
+		current.SSHKey = updates["ssh_key"].(string)
+		if server.SSHKey, err = s.encryptOptional(current.SSHKey, iv); err != nil {
 			return ServerResponse{}, err
 		}
-		server.SSHKey = &encryptedSSHKey
 	}
-	if updates["password"] != nil {
-		encryptedPassword, err := s.encryptionService.Encrypt(updates["password"].(string), iv)
-		if err != nil {
+	if updates["ssh_key_passphrase"] != nil {
+		current.SSHKeyPassphrase = updates["ssh_key_passphrase"].(string)
+		if server.SSHKeyPassphrase, err = s.encryptOptional(current.SSHKeyPassphrase, iv); err != nil {
 			return ServerResponse{}, err
 		}
-		server.Password = encryptedPassword
+	}
+	if updates["password"] != nil {
+		current.Password = updates["password"].(string)
+		server.Password = ""
+		if current.Password != "" {
+			if server.Password, err = s.encryptionService.Encrypt(current.Password, iv); err != nil {
+				return ServerResponse{}, err
+			}
+		}
+	}
+	if err := validateCredentials(current.Password, current.SSHKey, current.SSHKeyPassphrase); err != nil {
+		return ServerResponse{}, err
 	}
 
 	if err := s.db.Save(&server).Error; err != nil {
 		return ServerResponse{}, err
 	}
+	return s.convertToResponse(server, iv)
+}
 
-	var err error
-	var decodedSSHKey string
-	if server.SSHKey != nil {
-		decodedSSHKey, err = s.encryptionService.Decrypt(*server.SSHKey, iv)
-		if err != nil {
-			return ServerResponse{}, err
-		}
-	} else {
-		decodedSSHKey = ""
+// validateCredentials requires a password or a private key that can be decrypted with the passphrase
+func validateCredentials(password, sshKey, passphrase string) error {
+	if password == "" && sshKey == "" {
+		return fmt.Errorf("a password or an ssh key is required")
 	}
-	decodedPassword, err := s.encryptionService.Decrypt(server.Password, iv)
-	if err != nil {
-		return ServerResponse{}, err
+	if sshKey == "" {
+		return nil
 	}
-	return ServerResponse{
-		ID:        server.ID,
-		Name:      server.Name,
-		Username:  server.Username,
-		Host:      server.Host,
-		Port:      server.Port,
-		SSHKey:    decodedSSHKey,
-		Password:  decodedPassword,
-		CreatedAt: server.CreatedAt,
-		UpdatedAt: server.UpdatedAt,
-	}, nil
+	if _, err := libs.UnencryptedPrivateKey(sshKey, passphrase); err != nil {
+		return err
+	}
+	return nil
 }
 
 func (s *ServersService) DeleteServer(id, userId uint) error {
@@ -207,3 +232,171 @@ func (s *ServersService) DeleteServer(id, userId uint) error {
 	}
 	return nil
 }
+
+type HostKeyResponse struct {
+	ServerID    uint       `json:"server_id"`
+	Host        string     `json:"host"`
+	Port        int        `json:"port"`
+	HostKey     string     `json:"host_key"`
+	Fingerprint string     `json:"fingerprint"`
+	PinnedAt    *time.Time `json:"pinned_at"`
+}
+
+func (s *ServersService) convertToHostKeyResponse(server Server) HostKeyResponse {
+	return HostKeyResponse{
+		ServerID:    server.ID,
+		Host:        server.Host,
+		Port:        server.Port,
+		HostKey:     server.HostKey,
+		Fingerprint: server.HostKeyFingerprint,
+		PinnedAt:    server.HostKeyPinnedAt,
+	}
+}
+
+func (s *ServersService) GetHostKey(id, userId uint) (HostKeyResponse, error) {
+	var server Server
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&server).Error; err != nil {
+		return HostKeyResponse{}, err
+	}
+	return s.convertToHostKeyResponse(server), nil
+}
+
+// PinHostKey pins the supplied key or fingerprint, without either it pins the key the server presents right now
+func (s *ServersService) PinHostKey(id, userId uint, dto dto.PinHostKeyDto) (HostKeyResponse, error) {
+	var server Server
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&server).Error; err != nil {
+		return HostKeyResponse{}, err
+	}
+
+	hostKey := ""
+	fingerprint := dto.Fingerprint
+	switch {
+	case dto.HostKey != "":
+		key, err := libs.ParseHostKey(dto.HostKey)
+		if err != nil {
+			return HostKeyResponse{}, err
+		}
+		hostKey = libs.MarshalHostKey(key)
+		if fingerprint != "" && fingerprint != libs.HostKeyFingerprint(key) {
+			return HostKeyResponse{}, fmt.Errorf("fingerprint does not match the host key")
+		}
+		fingerprint = libs.HostKeyFingerprint(key)
+	case fingerprint == "":
+		key, err := s.sshExecutor.FetchHostKey(context.Background(), server.Host, server.Port, nil)
+		if err != nil {
+			return HostKeyResponse{}, err
+		}
+		hostKey = libs.MarshalHostKey(key)
+		fingerprint = libs.HostKeyFingerprint(key)
+	}
+
+	now := time.Now()
+	server.HostKey = hostKey
+	server.HostKeyFingerprint = fingerprint
+	server.HostKeyPinnedAt = &now
+	if err := s.db.Model(&Server{}).Where("id = ?", server.ID).Updates(map[string]interface{}{
+		"host_key":             server.HostKey,
+		"host_key_fingerprint": server.HostKeyFingerprint,
+		"host_key_pinned_at":   server.HostKeyPinnedAt,
+	}).Error; err != nil {
+		return HostKeyResponse{}, err
+	}
+	return s.convertToHostKeyResponse(server), nil
+}
+
+// ClearHostKey forgets the pinned host key, the next connection pins whatever the server presents
+func (s *ServersService) ClearHostKey(id, userId uint) error {
+	result := s.db.Model(&Server{}).Where("id = ? AND user_id = ?", id, userId).Updates(map[string]interface{}{
+		"host_key":             "",
+		"host_key_fingerprint": "",
+		"host_key_pinned_at":   nil,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CheckHostKey fetches the host key of the server and verifies it with VerifyHostKey. A pinned key is asked
+// for by its type and a server pinned by fingerprint only is asked for every key it has, so the pin is
+// found even when the server prefers another key type
+func (s *ServersService) CheckHostKey(ctx context.Context, id uint) (HostKeyResponse, error) {
+	var server Server
+	if err := s.db.Where("id = ?", id).First(&server).Error; err != nil {
+		return HostKeyResponse{}, err
+	}
+
+	var key ssh.PublicKey
+	var err error
+	switch {
+	case server.HostKey != "":
+		pinned, parseErr := libs.ParseHostKey(server.HostKey)
+		if parseErr != nil {
+			return HostKeyResponse{}, parseErr
+		}
+		key, err = s.sshExecutor.FetchHostKey(ctx, server.Host, server.Port, libs.HostKeyAlgorithms(pinned.Type()))
+		if err != nil && ctx.Err() == nil {
+			// The server has no key of the pinned type anymore, its preferred key reports the mismatch
+			key, err = s.sshExecutor.FetchHostKey(ctx, server.Host, server.Port, nil)
+		}
+	case server.HostKeyFingerprint != "":
+		var keys []ssh.PublicKey
+		keys, err = s.sshExecutor.FetchHostKeys(ctx, server.Host, server.Port)
+		if err == nil {
+			key = keys[0]
+			for _, candidate := range keys {
+				if libs.HostKeyFingerprint(candidate) == server.HostKeyFingerprint {
+					key = candidate
+					break
+				}
+			}
+		}
+	default:
+		key, err = s.sshExecutor.FetchHostKey(ctx, server.Host, server.Port, nil)
+	}
+	if err != nil {
+		return HostKeyResponse{}, err
+	}
+	return s.VerifyHostKey(server.ID, key)
+}
+
+// VerifyHostKey checks the key a server presented against the pinned one. Servers without a pin trust
+// the key on first use, servers pinned by fingerprint only get the full key stored on the first match
+func (s *ServersService) VerifyHostKey(id uint, key ssh.PublicKey) (HostKeyResponse, error) {
+	var server Server
+	if err := s.db.Where("id = ?", id).First(&server).Error; err != nil {
+		return HostKeyResponse{}, err
+	}
+
+	hostKey := libs.MarshalHostKey(key)
+	fingerprint := libs.HostKeyFingerprint(key)
+	if server.HostKeyFingerprint != "" && server.HostKeyFingerprint != fingerprint {
+		return HostKeyResponse{}, &libs.HostKeyMismatchError{
+			Host:     server.Host,
+			Expected: server.HostKeyFingerprint,
+			Actual:   fingerprint,
+		}
+	}
+	if server.HostKey == hostKey {
+		return s.convertToHostKeyResponse(server), nil
+	}
+
+	updates := map[string]interface{}{
+		"host_key":             hostKey,
+		"host_key_fingerprint": fingerprint,
+	}
+	if server.HostKeyPinnedAt == nil {
+		now := time.Now()
+		server.HostKeyPinnedAt = &now
+		updates["host_key_pinned_at"] = now
+	}
+	server.HostKey = hostKey
+	server.HostKeyFingerprint = fingerprint
+	if err := s.db.Model(&Server{}).Where("id = ?", server.ID).Updates(updates).Error; err != nil {
+		return HostKeyResponse{}, err
+	}
+	return s.convertToHostKeyResponse(server), nil
+}
@@ -0,0 +1,29 @@
+package dto
+
+import (
+	"regexp"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// projectNamePattern is what docker compose accepts as a project name
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type CreateStackDto struct {
+	// Name is the compose project name, lowercase letters, digits, dashes and underscores
+	Name        string `json:"name" validate:"required,min=1,max=63,compose_project"`
+	Description string `json:"description" validate:"omitempty,min=0,max=1000"`
+	Compose     string `json:"compose" validate:"required,min=1,max=65536"`
+	// SecretIDs are the secrets whose keys fill the ${NAME} references of the compose file
+	SecretIDs []uint `json:"secret_ids" validate:"omitempty,dive,min=1"`
+}
+
+func ValidateCreateStackDto(dto CreateStackDto) error {
+	validate := validator.New()
+	validate.RegisterValidation("compose_project", validateComposeProject)
+	return validate.Struct(dto)
+}
+
+func validateComposeProject(fl validator.FieldLevel) bool {
+	return projectNamePattern.MatchString(fl.Field().String())
+}
@@ -0,0 +1,21 @@
+package dto
+
+import "github.com/go-playground/validator/v10"
+
+type UpdateStackDto struct {
+	Name        *string `json:"name" validate:"omitempty,min=1,max=63,compose_project"`
+	Description *string `json:"description" validate:"omitempty,min=0,max=1000"`
+	Compose     *string `json:"compose" validate:"omitempty,min=1,max=65536"`
+	// SecretIDs replaces the secrets of the stack, an empty list removes them
+	SecretIDs *[]uint `json:"secret_ids" validate:"omitempty,dive,min=1"`
+}
+
+func (dto UpdateStackDto) HasUpdates() bool {
+	return dto.Name != nil || dto.Description != nil || dto.Compose != nil || dto.SecretIDs != nil
+}
+
+func ValidateUpdateStackDto(dto UpdateStackDto) error {
+	validate := validator.New()
+	validate.RegisterValidation("compose_project", validateComposeProject)
+	return validate.Struct(dto)
+}
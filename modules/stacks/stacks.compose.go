@@ -0,0 +1,103 @@
+package stacks
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// composeVariablePattern matches $$, $NAME and ${NAME} with the compose modifiers :-, -, :?, ?, :+ and +
+var composeVariablePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)((:?[-?+])[^}]*)?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// ValidateCompose checks that the YAML is a compose file with at least one service
+func ValidateCompose(compose string) error {
+	var document struct {
+		Services map[string]yaml.Node `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(compose), &document); err != nil {
+		return fmt.Errorf("invalid compose file: %w", err)
+	}
+	if len(document.Services) == 0 {
+		return fmt.Errorf("invalid compose file: no services defined")
+	}
+	return nil
+}
+
+// RenderCompose fills the variables of the compose file that are set in env. Values are interpolated into
+// the parsed scalars, not into the YAML text, so a secret cannot change the structure of the file, and a
+// $ inside a secret is escaped so compose does not interpolate it again. Unknown variables are left to compose
+func RenderCompose(compose string, env map[string]string) (string, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(compose), &document); err != nil {
+		return "", fmt.Errorf("invalid compose file: %w", err)
+	}
+	interpolateNode(&document, env)
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return "", fmt.Errorf("failed to encode compose file: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to encode compose file: %w", err)
+	}
+	return buffer.String(), nil
+}
+
+func interpolateNode(node *yaml.Node, env map[string]string) {
+	if node.Kind == yaml.ScalarNode {
+		if interpolated := interpolate(node.Value, env); interpolated != node.Value {
+			node.Value = interpolated
+			// Keep numbers and booleans produced by a secret as strings
+			node.Tag = "!!str"
+			node.Style = yaml.DoubleQuotedStyle
+		}
+		return
+	}
+	if node.Kind == yaml.MappingNode {
+		// Like compose only values are interpolated, keys are kept
+		for i := 1; i < len(node.Content); i += 2 {
+			interpolateNode(node.Content[i], env)
+		}
+		return
+	}
+	for _, child := range node.Content {
+		interpolateNode(child, env)
+	}
+}
+
+func interpolate(value string, env map[string]string) string {
+	return composeVariablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return match
+		}
+		groups := composeVariablePattern.FindStringSubmatch(match)
+		name, modifier := groups[1], groups[3]
+		if name == "" {
+			name = groups[4]
+		}
+		resolved, ok := env[name]
+		if !ok {
+			return match
+		}
+		switch modifier {
+		case ":-", ":?":
+			// The default or the error only apply to unset or empty variables, compose handles those
+			if resolved == "" {
+				return match
+			}
+		case ":+":
+			if resolved == "" {
+				return ""
+			}
+			return strings.TrimPrefix(groups[2], modifier)
+		case "+":
+			return strings.TrimPrefix(groups[2], modifier)
+		}
+		return strings.ReplaceAll(resolved, "$", "$$")
+	})
+}
@@ -0,0 +1,147 @@
+package stacks
+
+import (
+	"errors"
+	"strconv"
+
+	"deployer.com/libs"
+	"deployer.com/modules/auth/guards"
+	"deployer.com/modules/stacks/dto"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type StacksController struct {
+	stacksService *StacksService
+	router        *fiber.Router
+}
+
+func NewStacksController(router *fiber.Router, stacksService *StacksService) *StacksController {
+	return &StacksController{router: router, stacksService: stacksService}
+}
+
+func (c *StacksController) RegisterRoutes(router *fiber.Router) {
+	(*c.router).Get("/", guards.JwtGuard, c.GetStacks)
+	(*c.router).Get("/:id", guards.JwtGuard, c.GetStack)
+	(*c.router).Post("/", guards.JwtGuard, c.CreateStack)
+	(*c.router).Patch("/:id", guards.JwtGuard, c.UpdateStack)
+	(*c.router).Delete("/:id", guards.JwtGuard, c.DeleteStack)
+}
+
+func (c *StacksController) GetStacks(ctx *fiber.Ctx) error {
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	stacks, err := c.stacksService.GetStacks(uint(userClaims.UserID), userClaims.IV)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(stacks)
+}
+
+func (c *StacksController) GetStack(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	stack, err := c.stacksService.GetStack(uint(id), uint(userClaims.UserID), userClaims.IV)
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(stack)
+}
+
+func (c *StacksController) CreateStack(ctx *fiber.Ctx) error {
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	var body dto.CreateStackDto
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := dto.ValidateCreateStackDto(body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := ValidateCompose(body.Compose); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	stack, err := c.stacksService.CreateStack(uint(userClaims.UserID), body, userClaims.IV)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(stack)
+}
+
+func (c *StacksController) UpdateStack(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	var body dto.UpdateStackDto
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := dto.ValidateUpdateStackDto(body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if !body.HasUpdates() {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No updates provided",
+		})
+	}
+	if body.Compose != nil {
+		if err := ValidateCompose(*body.Compose); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	stack, err := c.stacksService.UpdateStack(uint(id), uint(userClaims.UserID), body, userClaims.IV)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(stack)
+}
+
+func (c *StacksController) DeleteStack(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userClaims := ctx.Locals("user").(*libs.UserClaims)
+	if err := c.stacksService.DeleteStack(uint(id), uint(userClaims.UserID)); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Stack deleted successfully",
+	})
+}
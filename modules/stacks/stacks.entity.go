@@ -0,0 +1,23 @@
+package stacks
+
+import (
+	"time"
+
+	"deployer.com/modules/secrets"
+	"deployer.com/modules/users"
+	"gorm.io/gorm"
+)
+
+type Stack struct {
+	gorm.Model
+	// Name is the compose project name on the servers
+	Name        string `gorm:"not null;index" json:"name"`
+	Description string `gorm:"null;default:null" json:"description"`
+	// Compose is the encrypted docker-compose YAML, its ${NAME} references are filled from Secrets
+	Compose string           `gorm:"type:text;not null" json:"compose"`
+	Secrets []secrets.Secret `gorm:"many2many:stack_secrets;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"secrets"`
+	User    users.User       `gorm:"foreignKey:UserID" json:"-"`
+	UserID  uint             `gorm:"not null" json:"user_id"`
+
+	LastDeployedAt *time.Time `gorm:"default:null" json:"last_deployed_at"`
+}
@@ -0,0 +1,158 @@
+package stacks
+
+import (
+	"fmt"
+	"time"
+
+	"deployer.com/libs"
+	"deployer.com/modules/secrets"
+	"deployer.com/modules/stacks/dto"
+	"gorm.io/gorm"
+)
+
+type StacksService struct {
+	db                *gorm.DB
+	encryptionService *libs.EncryptionService
+}
+
+type StackResponse struct {
+	ID             uint       `json:"id"`
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	Compose        string     `json:"compose"`
+	SecretIDs      []uint     `json:"secret_ids"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	LastDeployedAt *time.Time `json:"last_deployed_at"`
+}
+
+func NewStacksService(db *gorm.DB) *StacksService {
+	return &StacksService{db: db, encryptionService: libs.NewEncryptionService()}
+}
+
+func (s *StacksService) GetStacks(userId uint, iv string) ([]StackResponse, error) {
+	var stacks []Stack
+	if err := s.db.Where("user_id = ?", userId).Preload("Secrets").Order("created_at DESC").Find(&stacks).Error; err != nil {
+		return nil, err
+	}
+	result := make([]StackResponse, len(stacks))
+	for i, stack := range stacks {
+		response, err := s.convertToResponse(stack, iv)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = response
+	}
+	return result, nil
+}
+
+func (s *StacksService) GetStack(id, userId uint, iv string) (StackResponse, error) {
+	var stack Stack
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).Preload("Secrets").First(&stack).Error; err != nil {
+		return StackResponse{}, err
+	}
+	return s.convertToResponse(stack, iv)
+}
+
+func (s *StacksService) CreateStack(userId uint, dto dto.CreateStackDto, iv string) (StackResponse, error) {
+	stackSecrets, err := s.findSecrets(dto.SecretIDs, userId)
+	if err != nil {
+		return StackResponse{}, err
+	}
+	encrypted, err := s.encryptionService.Encrypt(dto.Compose, iv)
+	if err != nil {
+		return StackResponse{}, err
+	}
+	stack := Stack{
+		Name:        dto.Name,
+		Description: dto.Description,
+		Compose:     encrypted,
+		Secrets:     stackSecrets,
+		UserID:      userId,
+	}
+	if err := s.db.Omit("Secrets.*").Create(&stack).Error; err != nil {
+		return StackResponse{}, err
+	}
+	return s.convertToResponse(stack, iv)
+}
+
+func (s *StacksService) UpdateStack(id, userId uint, dto dto.UpdateStackDto, iv string) (StackResponse, error) {
+	var stack Stack
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).First(&stack).Error; err != nil {
+		return StackResponse{}, err
+	}
+	if dto.Name != nil {
+		stack.Name = *dto.Name
+	}
+	if dto.Description != nil {
+		stack.Description = *dto.Description
+	}
+	if dto.Compose != nil {
+		encrypted, err := s.encryptionService.Encrypt(*dto.Compose, iv)
+		if err != nil {
+			return StackResponse{}, err
+		}
+		stack.Compose = encrypted
+	}
+	if err := s.db.Omit("Secrets").Save(&stack).Error; err != nil {
+		return StackResponse{}, err
+	}
+	if dto.SecretIDs != nil {
+		stackSecrets, err := s.findSecrets(*dto.SecretIDs, userId)
+		if err != nil {
+			return StackResponse{}, err
+		}
+		if err := s.db.Model(&stack).Association("Secrets").Replace(stackSecrets); err != nil {
+			return StackResponse{}, fmt.Errorf("failed to update stack secrets: %w", err)
+		}
+	}
+	return s.GetStack(stack.ID, userId, iv)
+}
+
+func (s *StacksService) DeleteStack(id, userId uint) error {
+	if err := s.db.Where("id = ? AND user_id = ?", id, userId).Delete(&Stack{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetStackDeployed records when the stack was last brought up
+func (s *StacksService) SetStackDeployed(id uint) error {
+	return s.db.Model(&Stack{}).Where("id = ?", id).Update("last_deployed_at", time.Now()).Error
+}
+
+// findSecrets loads the secrets by id and makes sure all of them belong to the user
+func (s *StacksService) findSecrets(ids []uint, userId uint) ([]secrets.Secret, error) {
+	result := make([]secrets.Secret, 0, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	if err := s.db.Where("id IN ? AND user_id = ?", ids, userId).Find(&result).Error; err != nil {
+		return nil, err
+	}
+	if len(result) != len(ids) {
+		return nil, fmt.Errorf("some secrets do not belong to this user or do not exist")
+	}
+	return result, nil
+}
+
+func (s *StacksService) convertToResponse(stack Stack, iv string) (StackResponse, error) {
+	decoded, err := s.encryptionService.Decrypt(stack.Compose, iv)
+	if err != nil {
+		return StackResponse{}, err
+	}
+	secretIDs := make([]uint, len(stack.Secrets))
+	for i, secret := range stack.Secrets {
+		secretIDs[i] = secret.ID
+	}
+	return StackResponse{
+		ID:             stack.ID,
+		Name:           stack.Name,
+		Description:    stack.Description,
+		Compose:        decoded,
+		SecretIDs:      secretIDs,
+		CreatedAt:      stack.CreatedAt,
+		UpdatedAt:      stack.UpdatedAt,
+		LastDeployedAt: stack.LastDeployedAt,
+	}, nil
+}